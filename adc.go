@@ -1,54 +1,210 @@
 package adc
 
 import (
+	"errors"
+	"math"
+
 	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/gonumExtensions"
+	"github.com/hammal/adc/reconstruct"
+	"github.com/hammal/adc/samplingnetwork"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
 )
 
 // ADC is the overall interface for controlling the simulation and reconstruction
 // of and ADC.
 type ADC interface {
-	// Simulate the system. Returns a tuple [time index][output]float64
+	// Simulate the system. Returns a tuple [time index][output]float64 where
+	// the observation at index k is taken at the end of the sample period in
+	// which control decision k acts, i.e., at GetTimeStamps()[k] + Ts.
 	Simulate() [][]float64
-	// Reconstruct the inputs. Returns a tuple [time index][input]float64
+	// Reconstruct the inputs. Returns a tuple [time index][input]float64.
+	// Panics if the reconstruction fails, see ReconstructChecked.
 	Reconstruct() [][]float64
+	// ReconstructChecked is Reconstruct returning the errors of the design and
+	// of the reconstruction.
+	ReconstructChecked() ([][]float64, error)
+
+	// Save the adc system, including the control decisions, to a file filename
+	Save(filename string) error
+	// Load the adc from a file filename into this instance
+	Load(filename string) error
 
-	// Save the adc system to a file filename
-	Save(filename string)
-	// Load the adc from a file filename
-	Load(filename string) *ADC
+	// Set the input and measurement noise variances used to design the
+	// reconstruction.
+	SetNoiseVariances(inputNoiseVariance, measurementNoiseVariance float64)
 
-	// Get time stamps. Returns time stamps corresponding to the data points from
-	// Simulate() and Reconstruct().
+	// Get time stamps. Returns the time stamps of the control decisions and
+	// the estimates from Reconstruct(). The observations from Simulate() are
+	// one sample period later.
 	GetTimeStamps() []float64
 }
 
 type adc struct {
 	cont control.Control
-	sim  simulator.Simulator
-	rec  reconstructor.Reconstruction
-	sys  system
+	rec  reconstruct.Reconstruction
+	// State space model used for simulation
+	stateSpaceModel *ssm.LinearStateSpaceModel
+	sys             System
+	// Noise variances used when designing the reconstruction filter
+	inputNoiseVariance       float64
+	measurementNoiseVariance float64
 }
 
 // Simulate initates the control for simulating each control sequence
 func (a *adc) Simulate() [][]float64 {
 	// run the simulation
-	a.cont.Simulate()
+	states := a.cont.Simulate()
 	// Return the observations
-	tmp := a.sim.GetObservations()
-	return tmp
+	res := make([][]float64, len(states))
+	for index := range states {
+		t := a.sys.StartTime + float64(index+1)*a.sys.Ts
+		observation := a.stateSpaceModel.Observation(t, mat.NewVecDense(len(states[index]), states[index]))
+		res[index] = make([]float64, observation.Len())
+		for row := range res[index] {
+			res[index][row] = observation.AtVec(row)
+		}
+	}
+	return res
 }
 
-// Reconstruct using the reconstruction object.
+// Reconstruct using the reconstruction object. The reconstruction filter is
+// designed upon the first call such that it can be used after a simulation.
 func (a *adc) Reconstruct() [][]float64 {
-	return a.rec.Reconstruct()
+	res, err := a.ReconstructChecked()
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// ReconstructChecked is Reconstruct returning an error if the reconstruction
+// filter can't be designed or the reconstruction fails.
+func (a *adc) ReconstructChecked() ([][]float64, error) {
+	if a.rec == nil {
+		rec, err := a.newReconstruction()
+		if err != nil {
+			return nil, err
+		}
+		a.rec = rec
+	}
+	return a.rec.ReconstructionChecked()
+}
+
+// newReconstruction designs a steady state reconstruction for the current
+// control from the observation of the controls.
+func (a *adc) newReconstruction() (reconstruct.Reconstruction, error) {
+	order := a.stateSpaceModel.StateSpaceOrder()
+	var observation mat.Matrix = gonumExtensions.Eye(order, order, 0)
+	if cont, ok := a.cont.(*control.AnalogSwitchControl); ok {
+		observation = cont.GetObservation()
+	}
+	return newSteadyStateReconstruction(a.cont, a.stateSpaceModel, observation, a.inputNoiseVariance, a.measurementNoiseVariance)
+}
+
+// newSteadyStateReconstruction designs a steady state reconstruction of the
+// inputs of the state space model with the noise variances. The reconstruction
+// observes what the controls observe, i.e., the observation matrix has one row
+// per control with the measurement noise variance on each.
+func newSteadyStateReconstruction(cont control.Control, stateSpaceModel *ssm.LinearStateSpaceModel, observation mat.Matrix, inputNoiseVariance, measurementNoiseVariance float64) (reconstruct.Reconstruction, error) {
+	var inputNoiseCovariance, tmp mat.Dense
+
+	order := stateSpaceModel.StateSpaceOrder()
+	inputNoiseCovariance = *mat.NewDense(order, order, nil)
+	for _, input := range stateSpaceModel.Input {
+		tmp.Outer(inputNoiseVariance, input.B, input.B)
+		inputNoiseCovariance.Add(&inputNoiseCovariance, &tmp)
+	}
+
+	observations, _ := observation.Dims()
+	measurementNoiseCovariance := mat.NewDense(observations, observations, nil)
+	for row := 0; row < observations; row++ {
+		measurementNoiseCovariance.Set(row, row, measurementNoiseVariance)
+	}
+
+	reconstructionModel, err := ssm.NewLinearStateSpaceModelChecked(stateSpaceModel.A, observation, stateSpaceModel.Input)
+	if err != nil {
+		return nil, err
+	}
+	return reconstruct.NewSteadyStateReconstructorChecked(cont, measurementNoiseCovariance, &inputNoiseCovariance, *reconstructionModel)
 }
 
-// Return the time stamps for Ts
+// SetNoiseVariances sets the noise variances of the reconstruction. The
+// reconstruction filter is redesigned upon the next call to Reconstruct.
+func (a *adc) SetNoiseVariances(inputNoiseVariance, measurementNoiseVariance float64) {
+	a.inputNoiseVariance = inputNoiseVariance
+	a.measurementNoiseVariance = measurementNoiseVariance
+	a.rec = nil
+}
+
+// GetTimeStamps returns the time stamps of the control decisions and
+// estimates, see ADC.
 func (a adc) GetTimeStamps() []float64 {
-	N := int((a.sys.t1 - a.sys.t0) / a.sys.ts)
-	tmp := make([]float64, N)
-	for index := 0; index < N; index++ {
-		tmp[index] = float64(index) * a.sys.ts
+	tmp := make([]float64, a.sys.N)
+	for index := range tmp {
+		tmp[index] = a.sys.StartTime + float64(index)*a.sys.Ts
 	}
 	return tmp
 }
+
+// NewADC returns an ADC where the sampling network is controlled by analog
+// switches every sample period ts over the time span [t0, t1). The input
+// functions are connected to the inputs of the sampling network in order.
+// Panics if the parameters are inconsistent, see NewADCChecked.
+func NewADC(network samplingnetwork.SamplingNetwork, ts, t0, t1 float64, input []func(float64) float64) ADC {
+	a, err := NewADCChecked(network, ts, t0, t1, input)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// NewADCChecked is NewADC returning an error instead of panicking, e.g., a
+// *ssm.DimensionError if the sampling network and the inputs don't agree.
+func NewADCChecked(network samplingnetwork.SamplingNetwork, ts, t0, t1 float64, input []func(float64) float64) (ADC, error) {
+	if ts <= 0 || t1 <= t0 {
+		return nil, errors.New("Not a valid sample period and time span")
+	}
+	if err := network.Validate(); err != nil {
+		return nil, err
+	}
+
+	stateSpaceModel, err := samplingnetwork.LinearSystemToLinearStateSpaceModelChecked(network.System, input)
+	if err != nil {
+		return nil, err
+	}
+
+	length := int(math.Floor((t1-t0)/ts + 0.5))
+
+	// Extract the control vectors from the sampling network
+	controls := make([]mat.Vector, len(network.Control))
+	for index := range network.Control {
+		controls[index] = network.Control[index].GetVector()
+	}
+
+	cont, err := control.NewAnalogSwitchControlChecked(length, controls, ts, t0, nil, stateSpaceModel)
+	if err != nil {
+		return nil, err
+	}
+	// Decide each control from the direction it acts on such that networks
+	// with more states than controls are controlled correctly.
+	if err := cont.SetObservation(control.ObservationFromControls(controls)); err != nil {
+		return nil, err
+	}
+
+	return &adc{
+		cont:            cont,
+		stateSpaceModel: stateSpaceModel,
+		sys: System{
+			Ts:                   ts,
+			StartTime:            t0,
+			EndTime:              t1,
+			N:                    int64(length),
+			NumberOfInputs:       stateSpaceModel.InputSpaceOrder(),
+			NumberOfObservations: stateSpaceModel.ObservationSpaceOrder(),
+		},
+		inputNoiseVariance:       1.,
+		measurementNoiseVariance: 1.,
+	}, nil
+}
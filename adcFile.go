@@ -0,0 +1,212 @@
+package adc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/signal"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+// adcFileVersion is the version of the on-disk format written by Save. Bump it
+// whenever adcFile changes.
+const adcFileVersion = 1
+
+// adcFile is the on-disk representation of a simulated adc. It holds everything
+// needed to redo the reconstruction without re-simulating.
+type adcFile struct {
+	Version int
+	// Sample period and starting time
+	Ts float64
+	T0 float64
+	// State space model
+	A matrixFile
+	C matrixFile
+	// Input vectors, one per input
+	B [][]float64
+	// Control vectors, one per control
+	Controls [][]float64
+	// Control decisions, one code word per time sample
+	Decisions []uint
+	// Final state of the simulation, for reference only. The simulation
+	// starts from the zero state, see NewADC, and so does the loaded control.
+	State []float64 `json:",omitempty"`
+}
+
+// matrixFile is a row major representation of a matrix
+type matrixFile struct {
+	Rows    int
+	Columns int
+	Data    []float64
+}
+
+func newMatrixFile(matrix mat.Matrix) matrixFile {
+	rows, columns := matrix.Dims()
+	data := make([]float64, 0, rows*columns)
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			data = append(data, matrix.At(row, column))
+		}
+	}
+	return matrixFile{
+		Rows:    rows,
+		Columns: columns,
+		Data:    data,
+	}
+}
+
+func (mf matrixFile) dense() (*mat.Dense, error) {
+	if mf.Rows <= 0 || mf.Columns <= 0 || len(mf.Data) != mf.Rows*mf.Columns {
+		return nil, errors.New("Matrix data doesn't match its dimensions")
+	}
+	return mat.NewDense(mf.Rows, mf.Columns, mf.Data), nil
+}
+
+func vectorToSlice(vector mat.Vector) []float64 {
+	res := make([]float64, vector.Len())
+	for index := range res {
+		res[index] = vector.AtVec(index)
+	}
+	return res
+}
+
+// Save writes the state space model, controls and control decisions to the
+// file filename. Only analog switch controlled adcs can be saved.
+func (a *adc) Save(filename string) error {
+	cont, ok := a.cont.(*control.AnalogSwitchControl)
+	if !ok {
+		return errors.New("Only analog switch controls can be saved")
+	}
+
+	data := adcFile{
+		Version:   adcFileVersion,
+		Ts:        cont.GetTs(),
+		T0:        cont.GetT0(),
+		A:         newMatrixFile(a.stateSpaceModel.A),
+		C:         newMatrixFile(a.stateSpaceModel.C),
+		B:         make([][]float64, len(a.stateSpaceModel.Input)),
+		Decisions: cont.GetControlDecisions(),
+	}
+	for index, input := range a.stateSpaceModel.Input {
+		data.B[index] = vectorToSlice(input.B)
+	}
+	for _, controlVector := range cont.GetControlVectors() {
+		data.Controls = append(data.Controls, vectorToSlice(controlVector))
+	}
+	if state := cont.GetState(); state != nil {
+		data.State = vectorToSlice(state)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = json.NewEncoder(file).Encode(&data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Load replaces the current instance with the adc stored in the file filename.
+// As input functions can't be stored, the loaded inputs are zero. Therefore,
+// the loaded instance is intended for reconstruction. The loaded control
+// starts from the zero state, as the saved simulation did, and not from the
+// stored final state.
+func (a *adc) Load(filename string) error {
+	var data adcFile
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = json.NewDecoder(file).Decode(&data); err != nil {
+		return err
+	}
+	if data.Version != adcFileVersion {
+		return fmt.Errorf("Unsupported adc file version %v", data.Version)
+	}
+	if data.Ts <= 0 {
+		return errors.New("Not a valid sample period")
+	}
+
+	A, err := data.A.dense()
+	if err != nil {
+		return err
+	}
+	C, err := data.C.dense()
+	if err != nil {
+		return err
+	}
+	order := data.A.Rows
+	if data.A.Columns != order || data.C.Columns != order {
+		return errors.New("System Parameters don't match")
+	}
+	if len(data.B) == 0 {
+		return errors.New("No inputs in adc file")
+	}
+
+	input := make([]signal.VectorFunction, len(data.B))
+	for index, b := range data.B {
+		if len(b) != order {
+			return errors.New("Input vector doesn't match the state space order")
+		}
+		input[index] = signal.NewInput(func(float64) float64 { return 0. }, mat.NewVecDense(order, b))
+	}
+	controls := make([]mat.Vector, len(data.Controls))
+	for index, controlVector := range data.Controls {
+		if len(controlVector) != order {
+			return errors.New("Control vector doesn't match the state space order")
+		}
+		controls[index] = mat.NewVecDense(order, controlVector)
+	}
+	if len(data.State) > 0 && len(data.State) != order {
+		return errors.New("State doesn't match the state space order")
+	}
+
+	stateSpaceModel, err := ssm.NewLinearStateSpaceModelChecked(A, C, input)
+	if err != nil {
+		return err
+	}
+	cont, err := control.NewAnalogSwitchControlChecked(len(data.Decisions), controls, data.Ts, data.T0, nil, stateSpaceModel)
+	if err != nil {
+		return err
+	}
+	if err = cont.SetControlDecisions(data.Decisions); err != nil {
+		return err
+	}
+	// The controls observe the directions they act on, see NewADC
+	if err = cont.SetObservation(control.ObservationFromControls(controls)); err != nil {
+		return err
+	}
+
+	a.cont = cont
+	a.rec = nil
+	a.stateSpaceModel = stateSpaceModel
+	a.sys = System{
+		Ts:                   data.Ts,
+		StartTime:            data.T0,
+		EndTime:              data.T0 + float64(len(data.Decisions))*data.Ts,
+		N:                    int64(len(data.Decisions)),
+		NumberOfInputs:       stateSpaceModel.InputSpaceOrder(),
+		NumberOfObservations: stateSpaceModel.ObservationSpaceOrder(),
+	}
+	return nil
+}
+
+// LoadADC returns a new adc loaded from the file filename, see ADC.Load.
+func LoadADC(filename string) (ADC, error) {
+	a := &adc{
+		inputNoiseVariance:       1.,
+		measurementNoiseVariance: 1.,
+	}
+	if err := a.Load(filename); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
@@ -0,0 +1,118 @@
+package adc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/samplingnetwork"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	network := integratorChain(2)
+	input := []func(float64) float64{
+		func(arg float64) float64 { return 0.5 * math.Sin(2*math.Pi*10.*arg) },
+	}
+	converter := NewADC(network, chainTs, 0., 100*chainTs, input)
+	converter.Simulate()
+	estimates := converter.Reconstruct()
+
+	dir, err := ioutil.TempDir("", "adc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "adc.json")
+
+	if err = converter.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadADC(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stored final state isn't the initial state of the loaded control
+	state := loaded.(*adc).cont.(*control.AnalogSwitchControl).GetState()
+	if mat.Norm(state, 2) != 0 {
+		t.Errorf("Loaded control starts from the state %v instead of zero", mat.Formatted(state.T()))
+	}
+
+	loadedTimeStamps := loaded.GetTimeStamps()
+	for index, timeStamp := range converter.GetTimeStamps() {
+		if loadedTimeStamps[index] != timeStamp {
+			t.Fatalf("Time stamp %v differs after loading", index)
+		}
+	}
+
+	loadedEstimates := loaded.Reconstruct()
+	for index := range estimates {
+		if math.Abs(loadedEstimates[index][0]-estimates[index][0]) > 1e-12 {
+			t.Fatalf("Estimate %v differs after loading, %v != %v", index, loadedEstimates[index][0], estimates[index][0])
+		}
+	}
+
+	// A different noise variance should change the reconstruction
+	loaded.SetNoiseVariances(1e-2, 1.)
+	loadedEstimates = loaded.Reconstruct()
+	if loadedEstimates[len(estimates)/2][0] == estimates[len(estimates)/2][0] {
+		t.Error("Changing the noise variance didn't change the reconstruction")
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := LoadADC("nonExistingFile.json"); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	converter := NewADC(samplingnetwork.IntegratorBlock(chainGain), chainTs, 0., 10*chainTs, []func(float64) float64{math.Sin})
+	converter.Simulate()
+
+	dir, err := ioutil.TempDir("", "adc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "adc.json")
+	if err = converter.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, corrupt := range map[string]func(*adcFile){
+		"no controls": func(data *adcFile) { data.Controls = nil },
+		"too many controls": func(data *adcFile) {
+			for len(data.Controls) <= control.MaxNumberOfControls {
+				data.Controls = append(data.Controls, data.Controls[0])
+			}
+		},
+		"zero sample period":     func(data *adcFile) { data.Ts = 0 },
+		"negative sample period": func(data *adcFile) { data.Ts = -chainTs },
+	} {
+		var data adcFile
+		if err := json.Unmarshal(content, &data); err != nil {
+			t.Fatal(err)
+		}
+		corrupt(&data)
+		corrupted, err := json.Marshal(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, corrupted, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadADC(filename); err == nil {
+			t.Errorf("Expected an error for a file with %v", name)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package adc
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hammal/adc/samplingnetwork"
+	"github.com/hammal/adc/signal"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestNewADC(t *testing.T) {
+	network := integratorChain(3)
+
+	input := []func(float64) float64{
+		func(arg float64) float64 { return 0.5 * math.Sin(2*math.Pi*10.*arg) },
+	}
+
+	t0 := 0.
+	t1 := 100 * chainTs
+	converter := NewADC(network, chainTs, t0, t1, input)
+
+	timeStamps := converter.GetTimeStamps()
+	if len(timeStamps) != 100 {
+		t.Errorf("Expected 100 time stamps but got %v", len(timeStamps))
+	}
+	if timeStamps[1]-timeStamps[0] != chainTs {
+		t.Error("Time stamps are not separated by the sample period")
+	}
+
+	observations := converter.Simulate()
+	if len(observations) != len(timeStamps) {
+		t.Errorf("Number of observations %v doesn't match number of time stamps %v", len(observations), len(timeStamps))
+	}
+	if len(observations[0]) != network.System.OutputSpaceOrder() {
+		t.Error("Observation dimension doesn't match the sampling network")
+	}
+
+	estimates := converter.Reconstruct()
+	if len(estimates) != len(timeStamps) {
+		t.Errorf("Number of estimates %v doesn't match number of time stamps %v", len(estimates), len(timeStamps))
+	}
+	for index := range estimates {
+		if len(estimates[index]) != len(input) {
+			t.Fatal("Estimate dimension doesn't match the number of inputs")
+		}
+		if math.IsNaN(estimates[index][0]) || math.IsInf(estimates[index][0], 0) {
+			t.Fatalf("Estimate at index %v is not a number", index)
+		}
+	}
+}
+
+func TestNewADCChecked(t *testing.T) {
+	network := samplingnetwork.IntegratorBlock(chainGain)
+	input := []func(float64) float64{math.Sin}
+
+	if _, err := NewADCChecked(network, 0, 0, 1, input); err == nil {
+		t.Error("Expected an error for a zero sample period")
+	}
+	if _, err := NewADCChecked(network, chainTs, 1, 0, input); err == nil {
+		t.Error("Expected an error for an empty time span")
+	}
+	if _, err := NewADCChecked(network, chainTs, 0, 10*chainTs, nil); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for missing inputs but got %v", err)
+	}
+	mismatched := network
+	mismatched.Control = []samplingnetwork.Control{&samplingnetwork.AnalogSwitch{}}
+	mismatched.Control[0].(*samplingnetwork.AnalogSwitch).SetVector(mat.NewVecDense(2, []float64{-chainGain, 0}))
+	if _, err := NewADCChecked(mismatched, chainTs, 0, 10*chainTs, input); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for the control vector but got %v", err)
+	}
+	mismatched.Control = nil
+	if _, err := NewADCChecked(mismatched, chainTs, 0, 10*chainTs, input); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch without controls but got %v", err)
+	}
+
+	converter, err := NewADCChecked(network, chainTs, 0, 10*chainTs, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter.Simulate()
+	if _, err := converter.ReconstructChecked(); err != nil {
+		t.Error(err)
+	}
+	// The design of the reconstruction fails without measurement noise
+	converter.SetNoiseVariances(1, 0)
+	if _, err := converter.ReconstructChecked(); err == nil {
+		t.Error("Expected an error for a reconstruction without measurement noise")
+	}
+}
+
+func TestNewADCObservation(t *testing.T) {
+	length := 4000
+	network := observationNetwork()
+
+	frequency := 50.
+	input := []func(float64) float64{
+		func(arg float64) float64 { return 0.5 * math.Sin(2*math.Pi*frequency*arg) },
+	}
+	converter := NewADC(network, chainTs, 0, float64(length)*chainTs, input)
+	converter.SetNoiseVariances(1, 1e-2)
+	converter.Simulate()
+	estimates := converter.Reconstruct()
+
+	// The reconstruction observes what the two controls observe and doesn't
+	// assume the middle state to be bounded
+	values := make([]float64, 0, length)
+	for index := length / 10; index < length-length/10; index++ {
+		values = append(values, estimates[index][0])
+	}
+	snr, _, err := signal.SNR(values, chainTs, frequency, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snr < 40 {
+		t.Errorf("Reconstruction SNR %v dB", snr)
+	}
+}
+
+func TestADCTimeStamps(t *testing.T) {
+	t0 := 1.
+	u := 0.1
+	network := samplingnetwork.IntegratorBlock(chainGain)
+	converter := NewADC(network, chainTs, t0, t0+10*chainTs, []func(float64) float64{func(float64) float64 { return u }})
+
+	// The first decision is made at the first time stamp from the zero initial
+	// state whereas the first observation is the state one sample later.
+	timeStamps := converter.GetTimeStamps()
+	if timeStamps[0] != t0 {
+		t.Errorf("First time stamp %v instead of %v", timeStamps[0], t0)
+	}
+	observations := converter.Simulate()
+	if expected := chainGain * chainTs * (u + 1); math.Abs(observations[0][0]-expected) > 1e-6 {
+		t.Errorf("First observation %v instead of the state %v at %v", observations[0][0], expected, timeStamps[0]+chainTs)
+	}
+}
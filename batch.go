@@ -4,9 +4,64 @@ import (
 	"errors"
 
 	"github.com/hammal/adc/control"
-	"github.com/hammal/stateSpaceModel"
+	"github.com/hammal/adc/ssm"
 )
 
-func NewBatchADC(Ts float64, N int64, stateSpaceModel stateSpaceModel.stateSpaceModel, ct control.Control) *ADC {
-	panic(errors.New("Not yet implemented"))
+// NewBatchADC simulates the N samples of the control ct, with sample period Ts,
+// and thereafter reconstructs all N input estimates in one go. The control
+// decisions are stored in ct. Returns the estimates [time index][input]float64
+// together with the corresponding time stamps.
+//
+// The batch reconstruction requires the state space model to be the
+// *ssm.LinearStateSpaceModel simulated by ct. Errors of the design and of the
+// reconstruction are returned.
+func NewBatchADC(Ts float64, N int64, stateSpaceModel ssm.StateSpaceModel, ct control.Control) ([][]float64, []float64, error) {
+	linearStateSpaceModel, ok := stateSpaceModel.(*ssm.LinearStateSpaceModel)
+	if !ok {
+		return nil, nil, errors.New("Batch reconstruction requires a linear state space model")
+	}
+	if int64(ct.GetLength()) != N {
+		return nil, nil, errors.New("Number of samples doesn't match the length of the control")
+	}
+	if ct.GetTs() != Ts {
+		return nil, nil, errors.New("Sample period doesn't match the sample period of the control")
+	}
+	simulated, ok := ct.(modelledControl)
+	if !ok || simulated.GetStateSpaceModel() != stateSpaceModel {
+		return nil, nil, errors.New("State space model isn't the model simulated by the control")
+	}
+
+	batch := adc{
+		cont:            ct,
+		stateSpaceModel: linearStateSpaceModel,
+		sys: System{
+			Ts:                   Ts,
+			StartTime:            ct.GetT0(),
+			EndTime:              ct.GetT0() + float64(N)*Ts,
+			N:                    N,
+			NumberOfInputs:       linearStateSpaceModel.InputSpaceOrder(),
+			NumberOfObservations: linearStateSpaceModel.ObservationSpaceOrder(),
+		},
+		inputNoiseVariance:       1.,
+		measurementNoiseVariance: 1.,
+	}
+
+	// Simulate and store the control decisions
+	ct.Simulate()
+
+	rec, err := batch.newReconstruction()
+	if err != nil {
+		return nil, nil, err
+	}
+	estimates, err := rec.ReconstructionChecked()
+	if err != nil {
+		return nil, nil, err
+	}
+	return estimates, batch.GetTimeStamps(), nil
+}
+
+// modelledControl is a control exposing the state space model it simulates
+type modelledControl interface {
+	control.Control
+	GetStateSpaceModel() ssm.StateSpaceModel
 }
@@ -0,0 +1,49 @@
+package adc
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/signal"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestNewBatchADC(t *testing.T) {
+	N := 3
+	length := 200
+	t0 := 0.
+
+	b := mat.NewVecDense(N, nil)
+	b.SetVec(0, chainGain)
+	input := make([]signal.VectorFunction, 1)
+	input[0] = signal.NewInput(func(arg1 float64) float64 { return 0.5 * math.Sin(math.Pi*2.*arg1*10.) }, b)
+	sm := ssm.NewIntegratorChain(N, chainGain, input)
+
+	controls := make([]mat.Vector, N)
+	for index := range controls {
+		tmp := mat.NewVecDense(N, nil)
+		tmp.SetVec(index, -chainGain)
+		controls[index] = tmp
+	}
+	ctrl := control.NewAnalogSwitchControl(length, controls, chainTs, t0, nil, sm)
+
+	estimates, timeStamps, err := NewBatchADC(chainTs, int64(length), sm, ctrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(estimates) != length || len(timeStamps) != length {
+		t.Errorf("Expected %v estimates and time stamps but got %v and %v", length, len(estimates), len(timeStamps))
+	}
+	if timeStamps[length-1] != t0+float64(length-1)*chainTs {
+		t.Error("Time stamps don't match the sample period")
+	}
+
+	if _, _, err = NewBatchADC(chainTs, int64(length+1), sm, ctrl); err == nil {
+		t.Error("Expected an error for mismatching number of samples")
+	}
+	if _, _, err = NewBatchADC(chainTs, int64(length), ssm.NewIntegratorChain(N, chainGain, input), ctrl); err == nil {
+		t.Error("Expected an error for a state space model the control doesn't simulate")
+	}
+}
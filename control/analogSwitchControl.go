@@ -2,10 +2,10 @@ package control
 
 import (
 	"errors"
-	"fmt"
-	"math"
+	"math/bits"
 
 	"github.com/hammal/adc/gonumExtensions"
+	"github.com/hammal/adc/logging"
 	"github.com/hammal/adc/ode"
 	"github.com/hammal/adc/signal"
 	"github.com/hammal/adc/ssm"
@@ -34,6 +34,18 @@ type AnalogSwitchControl struct {
 	// precomputed control decision vectors for filtering
 	controlFilterLookUpForward  ControlVector
 	controlFilterLookUpBackward ControlVector
+	// comparators, one per control, nil for ideal comparators
+	comparators []Comparator
+	// observers notified at every sample of the simulation
+	observers observers
+	// exact discretization used by Simulate instead of numerical integration,
+	// see UseExactDiscretization.
+	discretization *ssm.DiscreteLinearStateSpaceModel
+	// observation matrix, one row per control, from which the control
+	// decisions are made. If nil control i observes state i.
+	observation mat.Matrix
+	// noise injected into the simulation, nil for a noiseless simulation
+	noise *Noise
 }
 
 // Simulate the simulation tool for integratorControl
@@ -44,7 +56,7 @@ func (c *AnalogSwitchControl) Simulate() [][]float64 {
 		tmpSimRes mat.Matrix
 	)
 
-	fmt.Println("Starting simulation...")
+	logging.Info(logger, "Starting simulation", logging.F("samples", c.GetLength()), logging.F("controls", c.NumberOfControls))
 
 	res := make([][]float64, c.GetLength())
 
@@ -56,6 +68,9 @@ func (c *AnalogSwitchControl) Simulate() [][]float64 {
 
 	t0 := c.T0
 	t1 := t0 + c.Ts
+	_, linear := c.StateSpaceModel.(*ssm.LinearStateSpaceModel)
+	// The noise was validated and copied by SetNoise
+	noise, _ := newNoiseSource(c.noise, c.StateSpaceModel, c.Ts, c.GetLength())
 	// rk := ode.NewRK4()
 	rk := ode.NewFehlberg45()
 	for index := 0; index < c.GetLength(); index++ {
@@ -64,13 +79,27 @@ func (c *AnalogSwitchControl) Simulate() [][]float64 {
 		// Update control based on current state
 		c.updateControl(tmpState.ColView(0), index)
 		// Simulate the ADC without control
-		// There is a complication here since we now the exact state dynamics
-		// if the state space model was a linear model. Thus this could be realized
-		// using a pre-computed Ad=e^(A Ts) and then using the Runge-Kutta method
-		// with zero initial state.
-		tmpSimRes, _ = rk.AdaptiveCompute(t0, t1, 1e-8, &tmpState, c.StateSpaceModel)
+		if c.discretization != nil {
+			// For linear models the state is advanced using the pre-computed
+			// Ad=e^(A Ts) and the discretized inputs.
+			tmpSimRes = c.discretization.Step(t0, tmpState.ColView(0))
+		} else if !linear {
+			// The control contributions of bilinear and nonlinear models
+			// depend on the state and are therefore integrated together with
+			// the model.
+			tmpSimRes, _ = rk.AdaptiveCompute(t0, t1, 1e-8, &tmpState, controlledSystem{
+				StateSpaceModel: c.StateSpaceModel,
+				control:         c.controlVector(c.bits[index]),
+			})
+		} else {
+			tmpSimRes, _ = rk.AdaptiveCompute(t0, t1, 1e-8, &tmpState, c.StateSpaceModel)
+		}
 		// Get the control contributions
-		tmpCtrl, _ = c.getControlSimulationContribution(index)
+		if !linear {
+			tmpCtrl = mat.NewVecDense(c.StateSpaceModel.StateSpaceOrder(), nil)
+		} else {
+			tmpCtrl, _ = c.getControlSimulationContribution(index)
+		}
 		// Add the control contributions
 		// fmt.Printf("Simulation Contribution \n%v\n", mat.Formatted(tmpSimRes))
 
@@ -78,6 +107,13 @@ func (c *AnalogSwitchControl) Simulate() [][]float64 {
 		tmpState.Add(tmpCtrl, tmpSimRes)
 		// fmt.Printf("Control Contribution\n%v\n", mat.Formatted(tmpCtrl))
 		// tmpState.Add(tmpState, tmpVec)
+		if noise != nil {
+			tmpState.Add(&tmpState, noise.step())
+			// The clock jitter delays the transitions of the controls
+			if noise.jitter > 0 && index > 0 {
+				tmpState.Add(&tmpState, noise.jitterContribution(c.controlVector(c.bits[index-1]), c.controlVector(c.bits[index])))
+			}
+		}
 
 		// fmt.Printf("State After \n%v\n", mat.Formatted(&tmpState))
 
@@ -98,39 +134,23 @@ func (c *AnalogSwitchControl) Simulate() [][]float64 {
 // state, held in the reviver type.
 func (c *AnalogSwitchControl) updateControl(state mat.Vector, index int) {
 	// Set control bits
-	var tmp bool
 	bits := make([]uint, c.NumberOfControls)
+	previous := previousBits(c.bits, index, c.NumberOfControls)
+	// Observe the state
+	observed := state
+	if c.observation != nil {
+		var tmp mat.VecDense
+		tmp.MulVec(c.observation, state)
+		observed = &tmp
+	}
 	// fmt.Printf("Decisions for \n%v\n => ", mat.Formatted(state))
+	values := make([]float64, c.NumberOfControls)
 	for i := 0; i < c.NumberOfControls; i++ {
-		tmp = state.AtVec(i) > 0
-		// fmt.Printf("%v, ", tmp)
-		if tmp {
-			bits[i] = 1
-		} else {
-			bits[i] = 0
-		}
+		values[i] = observed.AtVec(i)
+		bits[i] = decide(c.comparators, i, values[i], previous[i])
 	}
 	c.bits[index] = bitToIndex(bits)
-	// a3 := mat.Inner(c.controls[0].C, I, state)
-	// a4 := mat.Inner(c.controls[1].C, I, state)
-	e1 := math.Pow(state.AtVec(0), 2)
-	e2 := math.Pow(state.AtVec(1), 2)
-	phase := math.Atan2(state.AtVec(1), state.AtVec(0)) / math.Pi * 180
-	// phaseError := math.Atan2(a3, a4) / math.Pi * 180.
-
-	fmt.Printf("\nEnergy: Total = %5.e, PerState = (%5.e, %5.e,) [V^2] and Phase = %+4.f [deg]", e1+e2, e1, e2, phase)
-
-	if index > 0 {
-		if c.bits[index] != c.bits[index-1] {
-			fmt.Print("\tControl Switch!\t")
-			bits1 := indexToBits(c.bits[index-1], len(bits))
-			for index2 := range bits {
-				if bits1[index2] != bits[index2] {
-					fmt.Printf("Nr %d,\t", index2)
-				}
-			}
-		}
-	}
+	c.observers.notify(index, c.T0+float64(index)*c.Ts, state, values, c.bits, c.NumberOfControls)
 }
 
 // GetControlSimulationContribution returns the control decision vector
@@ -138,11 +158,11 @@ func (c *AnalogSwitchControl) updateControl(state mat.Vector, index int) {
 func (c *AnalogSwitchControl) getControlSimulationContribution(index int) (mat.Vector, error) {
 	// Check that index exists
 	if index < 0 || index > c.GetLength()-1 {
-		return nil, errors.New("Index out of range")
+		return nil, ErrIndexOutOfRange
 	}
 
 	if c.controlSimulateLookUp == nil {
-		return nil, errors.New("No pre-computed filter decisions.")
+		return nil, ErrNotPrecomputed
 	}
 
 	// fmt.Printf("Number of controls = %v", c.NumberOfControls)
@@ -163,11 +183,11 @@ func (c *AnalogSwitchControl) getControlSimulationContribution(index int) (mat.V
 func (c AnalogSwitchControl) GetForwardControlFilterContribution(index int) (mat.Vector, error) {
 	// Check that index exists
 	if index < 0 || index > c.GetLength()-1 {
-		return nil, errors.New("index out of range")
+		return nil, ErrIndexOutOfRange
 	}
 	// Check that there are precomputed filter decisions
 	if c.controlFilterLookUpForward == nil {
-		return nil, errors.New("No pre-computed filter decisions.")
+		return nil, ErrNotPrecomputed
 	}
 
 	tmp := c.controlFilterLookUpForward.GetVector(c.bits[index])
@@ -178,17 +198,189 @@ func (c AnalogSwitchControl) GetForwardControlFilterContribution(index int) (mat
 func (c AnalogSwitchControl) GetBackwardControlFilterContribution(index int) (mat.Vector, error) {
 	// Check that index exists
 	if index < 0 || index > c.GetLength()-1 {
-		return nil, errors.New("index out of range")
+		return nil, ErrIndexOutOfRange
 	}
 	// Check that there are precomputed filter decisions
 	if c.controlFilterLookUpBackward == nil {
-		return nil, errors.New("No pre-computed filter decisions.")
+		return nil, ErrNotPrecomputed
 	}
 
 	tmp := c.controlFilterLookUpBackward.GetVector(c.bits[index])
 	return tmp, nil
 }
 
+// UseExactDiscretization makes Simulate advance the state using the exact
+// discretization of the linear state space model instead of the adaptive
+// Runge-Kutta method. The model is discretized once which makes the simulation
+// considerably faster. The hold specifies how the inputs are assumed to
+// behave in between samples.
+func (c *AnalogSwitchControl) UseExactDiscretization(hold ssm.InputHold) error {
+	linearStateSpaceModel, ok := c.StateSpaceModel.(*ssm.LinearStateSpaceModel)
+	if !ok {
+		return errors.New("Exact discretization requires a linear state space model")
+	}
+	c.discretization = linearStateSpaceModel.Discretize(c.Ts, hold)
+
+	// The controls are piecewise constant and therefore exactly described by
+	// a zero-order hold.
+	order := linearStateSpaceModel.StateSpaceOrder()
+	controlMatrix := mat.NewDense(order, c.NumberOfControls, nil)
+	for column := range c.controls {
+		for row := 0; row < order; row++ {
+			controlMatrix.Set(row, column, c.controls[column].B.AtVec(row))
+		}
+	}
+	_, Bd := ssm.ZeroOrderHoldDiscretization(linearStateSpaceModel.A, controlMatrix, c.Ts)
+
+	numberOfPossibleControlCombinations := (1 << uint(c.NumberOfControls))
+	c.controlSimulateLookUp = &lazyCache{
+		aSwitch: discreteSwitch{
+			controlMatrix:    Bd,
+			numberOfControls: c.NumberOfControls,
+		},
+		computed: make([]bool, numberOfPossibleControlCombinations),
+		cache:    make([]mat.Vector, numberOfPossibleControlCombinations),
+	}
+	return nil
+}
+
+// GetControlDecisions returns the control decisions as one code word per
+// time sample. Bit i of a code word corresponds to control i.
+func (c AnalogSwitchControl) GetControlDecisions() []uint {
+	return c.bits
+}
+
+// SetControlDecisions replaces the control decisions with decisions, for
+// instance decisions from an earlier simulation. The number of code words must
+// match the length of the control.
+func (c *AnalogSwitchControl) SetControlDecisions(decisions []uint) error {
+	if len(decisions) != len(c.bits) {
+		return &ssm.DimensionError{What: "Number of control decisions doesn't match the length of the control"}
+	}
+	for _, codeWord := range decisions {
+		if bits.Len(codeWord) > c.NumberOfControls {
+			return errors.New("Control decision doesn't match the number of controls")
+		}
+	}
+	copy(c.bits, decisions)
+	return nil
+}
+
+// GetControlVectors returns the control vectors, i.e., the contribution of each
+// control to the state derivative.
+func (c AnalogSwitchControl) GetControlVectors() []mat.Vector {
+	res := make([]mat.Vector, c.NumberOfControls)
+	for index := range res {
+		res[index] = c.controls[index].B
+	}
+	return res
+}
+
+// controlVector returns the control contribution to the state derivative of
+// the code word, i.e., the sum of the control vectors scaled by the control
+// decisions.
+func (c AnalogSwitchControl) controlVector(codeWord uint) mat.Vector {
+	res := mat.NewVecDense(c.StateSpaceModel.StateSpaceOrder(), nil)
+	for index, bit := range indexToBits(codeWord, c.NumberOfControls) {
+		res.AddScaledVec(res, 2.*float64(bit)-1., c.controls[index].B)
+	}
+	return res
+}
+
+// GetState returns the current state which after a simulation is the final
+// state.
+func (c AnalogSwitchControl) GetState() mat.Vector {
+	return c.state
+}
+
+// SetObservation sets the observation matrix from which the control decisions
+// are made, i.e., control i decides based on row i of observation times the
+// state. This is needed when the controls don't map one-to-one to the states.
+// A nil observation corresponds to control i observing state i.
+func (c *AnalogSwitchControl) SetObservation(observation mat.Matrix) error {
+	if observation == nil {
+		if c.NumberOfControls > c.StateSpaceModel.StateSpaceOrder() {
+			return &ssm.DimensionError{What: "More controls than states requires an observation matrix"}
+		}
+		c.observation = nil
+		return nil
+	}
+	rows, columns := observation.Dims()
+	if rows != c.NumberOfControls || columns != c.StateSpaceModel.StateSpaceOrder() {
+		return &ssm.DimensionError{What: "Observation matrix doesn't match the number of controls and states"}
+	}
+	c.observation = observation
+	return nil
+}
+
+// GetObservation returns the observation matrix from which the control
+// decisions are made, one row per control. Without an observation matrix
+// control i observes state i.
+func (c AnalogSwitchControl) GetObservation() mat.Matrix {
+	if c.observation != nil {
+		return c.observation
+	}
+	res := mat.NewDense(c.NumberOfControls, c.StateSpaceModel.StateSpaceOrder(), nil)
+	for index := 0; index < c.NumberOfControls; index++ {
+		res.Set(index, index, 1)
+	}
+	return res
+}
+
+// GetNumberOfControls returns the number of controls
+func (c AnalogSwitchControl) GetNumberOfControls() int { return c.NumberOfControls }
+
+// GetForwardCodeWordContribution returns the forward filter contribution of
+// the control decision code word, see StreamingControl.
+func (c AnalogSwitchControl) GetForwardCodeWordContribution(codeWord uint) (mat.Vector, error) {
+	if bits.Len(codeWord) > c.NumberOfControls {
+		return nil, ErrIndexOutOfRange
+	}
+	if c.controlFilterLookUpForward == nil {
+		return nil, ErrNotPrecomputed
+	}
+	return c.controlFilterLookUpForward.GetVector(codeWord), nil
+}
+
+// GetBackwardCodeWordContribution returns the backward filter contribution of
+// the control decision code word, see StreamingControl.
+func (c AnalogSwitchControl) GetBackwardCodeWordContribution(codeWord uint) (mat.Vector, error) {
+	if bits.Len(codeWord) > c.NumberOfControls {
+		return nil, ErrIndexOutOfRange
+	}
+	if c.controlFilterLookUpBackward == nil {
+		return nil, ErrNotPrecomputed
+	}
+	return c.controlFilterLookUpBackward.GetVector(codeWord), nil
+}
+
+// AddObserver adds an observer which is notified at every sample of the
+// simulation.
+func (c *AnalogSwitchControl) AddObserver(observer Observer) {
+	c.observers = append(c.observers, observer)
+}
+
+// SetComparators sets the comparators, one per control, used to make the
+// control decisions. A nil slice corresponds to ideal comparators.
+func (c *AnalogSwitchControl) SetComparators(comparators []Comparator) error {
+	if err := checkComparators(comparators, c.NumberOfControls); err != nil {
+		return err
+	}
+	c.comparators = comparators
+	return nil
+}
+
+// SetNoise sets the thermal noise, 1/f noise and clock jitter injected into
+// the simulation, nil for a noiseless simulation. Returns a *ssm.DimensionError
+// if the noise doesn't match the state space order.
+func (c *AnalogSwitchControl) SetNoise(noise *Noise) error {
+	if _, err := newNoiseSource(noise, c.StateSpaceModel, c.Ts, c.GetLength()); err != nil {
+		return err
+	}
+	c.noise = noise.copy()
+	return nil
+}
+
 // GetLength returns the length of control (number of time samples)
 func (c AnalogSwitchControl) GetLength() int {
 	return len(c.bits)
@@ -197,6 +389,12 @@ func (c AnalogSwitchControl) GetLength() int {
 // GetTs returns the sample period
 func (c AnalogSwitchControl) GetTs() float64 { return c.Ts }
 
+// GetT0 returns the starting time
+func (c AnalogSwitchControl) GetT0() float64 { return c.T0 }
+
+// GetStateSpaceModel returns the state space model simulated by the control
+func (c AnalogSwitchControl) GetStateSpaceModel() ssm.StateSpaceModel { return c.StateSpaceModel }
+
 func (c *AnalogSwitchControl) PreComputeFilterContributions(forwardDynamics, backwardDynamics mat.Matrix) {
 	numberOfControlScenarios := (1 << uint(c.NumberOfControls))
 
@@ -251,6 +449,28 @@ func (c *AnalogSwitchControl) PreComputeFilterContributions(forwardDynamics, bac
 
 // Returns an initialized analog switch control
 func NewAnalogSwitchControl(length int, controls []mat.Vector, ts, t0 float64, state mat.Vector, StateSpaceModel *ssm.LinearStateSpaceModel) *AnalogSwitchControl {
+	return newAnalogSwitchControl(length, controls, ts, t0, state, StateSpaceModel, StateSpaceModel.A)
+}
+
+// NewBiLinearAnalogSwitchControl returns an initialized analog switch control
+// of a bilinear state space model. The control is simulated together with the
+// model whereas the filter contributions are computed from the linear part AL,
+// see also reconstruct.NewBiLinearTimeVaryingReconstructor.
+func NewBiLinearAnalogSwitchControl(length int, controls []mat.Vector, ts, t0 float64, state mat.Vector, StateSpaceModel *ssm.BiLinearStateSpaceModel) *AnalogSwitchControl {
+	return newAnalogSwitchControl(length, controls, ts, t0, state, StateSpaceModel, StateSpaceModel.AL)
+}
+
+// NewNonLinearAnalogSwitchControl returns an initialized analog switch control
+// of a nonlinear state space model. The control is simulated together with the
+// model whereas the filter contributions are computed from the nominal linear
+// model.
+func NewNonLinearAnalogSwitchControl(length int, controls []mat.Vector, ts, t0 float64, state mat.Vector, StateSpaceModel *ssm.NonLinearStateSpaceModel) *AnalogSwitchControl {
+	return newAnalogSwitchControl(length, controls, ts, t0, state, StateSpaceModel, StateSpaceModel.A)
+}
+
+// newAnalogSwitchControl returns an analog switch control where the control
+// contributions are computed from the systemDynamics.
+func newAnalogSwitchControl(length int, controls []mat.Vector, ts, t0 float64, state mat.Vector, StateSpaceModel ssm.StateSpaceModel, systemDynamics mat.Matrix) *AnalogSwitchControl {
 	// IDEA Change controls to reflect resistance values
 	order := StateSpaceModel.StateSpaceOrder()
 	numberOfControls := len(controls)
@@ -274,7 +494,7 @@ func NewAnalogSwitchControl(length int, controls []mat.Vector, ts, t0 float64, s
 	bits := make([]uint, length)
 
 	analogswitch := analogSwitch{
-		systemDynamics: StateSpaceModel.A,
+		systemDynamics: systemDynamics,
 		controls:       ctrl,
 		Ts:             ts,
 	}
@@ -301,6 +521,54 @@ func NewAnalogSwitchControl(length int, controls []mat.Vector, ts, t0 float64, s
 
 }
 
+// NewAnalogSwitchControlChecked is NewAnalogSwitchControl returning an error
+// instead of an inconsistent control. A *ssm.DimensionError is returned if the
+// control vectors or the initial state don't match the state space model.
+func NewAnalogSwitchControlChecked(length int, controls []mat.Vector, ts, t0 float64, state mat.Vector, StateSpaceModel *ssm.LinearStateSpaceModel) (*AnalogSwitchControl, error) {
+	if StateSpaceModel == nil {
+		return nil, errors.New("A state space model is required")
+	}
+	if err := checkAnalogSwitchControl(length, controls, ts, state, StateSpaceModel.StateSpaceOrder()); err != nil {
+		return nil, err
+	}
+	return NewAnalogSwitchControl(length, controls, ts, t0, state, StateSpaceModel), nil
+}
+
+// NewBiLinearAnalogSwitchControlChecked is NewBiLinearAnalogSwitchControl
+// returning an error instead of an inconsistent control.
+func NewBiLinearAnalogSwitchControlChecked(length int, controls []mat.Vector, ts, t0 float64, state mat.Vector, StateSpaceModel *ssm.BiLinearStateSpaceModel) (*AnalogSwitchControl, error) {
+	if StateSpaceModel == nil {
+		return nil, errors.New("A state space model is required")
+	}
+	if _, err := ssm.NewBiLinearStateSpaceModelChecked(StateSpaceModel.AL, StateSpaceModel.AB, StateSpaceModel.C, StateSpaceModel.Input); err != nil {
+		return nil, err
+	}
+	if err := checkAnalogSwitchControl(length, controls, ts, state, StateSpaceModel.StateSpaceOrder()); err != nil {
+		return nil, err
+	}
+	return NewBiLinearAnalogSwitchControl(length, controls, ts, t0, state, StateSpaceModel), nil
+}
+
+// checkAnalogSwitchControl validates the parameters of an analog switch control
+// for a state space model of order.
+func checkAnalogSwitchControl(length int, controls []mat.Vector, ts float64, state mat.Vector, order int) error {
+	if length < 0 || ts <= 0 {
+		return errors.New("Not a valid length and sample period")
+	}
+	if err := checkNumberOfControls(len(controls)); err != nil {
+		return err
+	}
+	for _, control := range controls {
+		if control == nil || control.Len() != order {
+			return &ssm.DimensionError{What: "Control vector doesn't match the state space order"}
+		}
+	}
+	if state != nil && state.Len() != order {
+		return &ssm.DimensionError{What: "Initial state doesn't match the state space order"}
+	}
+	return nil
+}
+
 type analogSwitch struct {
 	systemDynamics mat.Matrix
 	controls       []signal.VectorFunction
@@ -333,3 +601,16 @@ func (as analogSwitch) GetVector(controlCode uint) mat.Vector {
 	linearSystemModel := ssm.NewLinearStateSpaceModel(as.systemDynamics, gonumExtensions.Eye(M, M, 0), ctrlFunction)
 	return Solve(linearSystemModel, 0, as.Ts, nil)
 }
+
+// discreteSwitch computes the control contributions from the discretized
+// control matrix.
+type discreteSwitch struct {
+	controlMatrix    mat.Matrix
+	numberOfControls int
+}
+
+func (ds discreteSwitch) GetVector(controlCode uint) mat.Vector {
+	var res mat.VecDense
+	res.MulVec(ds.controlMatrix, indexToVec(controlCode, ds.numberOfControls))
+	return &res
+}
@@ -133,6 +133,59 @@ func TestAnalogSwitchControlSimulateAndGetFilterContributions(t *testing.T) {
 	}
 }
 
+func TestAnalogSwitchControlExactDiscretization(t *testing.T) {
+	order := 3
+	t0 := 0.
+
+	// The zero-order hold is exact for piecewise constant inputs while the
+	// first-order hold is an approximation for smooth inputs.
+	testCases := []struct {
+		hold      ssm.InputHold
+		input     func(float64) float64
+		length    int
+		tolerance float64
+	}{
+		{ssm.ZeroOrderHold, func(arg1 float64) float64 { return 1. / math.Sqrt(7.) }, 200, 1e-6},
+		{ssm.FirstOrderHold, func(arg1 float64) float64 { return 0.5 * math.Sin(2*math.Pi*10*arg1) }, 50, 1e-4},
+	}
+
+	for _, testCase := range testCases {
+		stateSpaceModel, controls := integratorChain(order, testCase.input)
+
+		reference := NewAnalogSwitchControl(testCase.length, controls, chainTs, t0, nil, stateSpaceModel)
+		referenceStates := reference.Simulate()
+
+		ctrl := NewAnalogSwitchControl(testCase.length, controls, chainTs, t0, nil, stateSpaceModel)
+		if err := ctrl.UseExactDiscretization(testCase.hold); err != nil {
+			t.Fatal(err)
+		}
+		states := ctrl.Simulate()
+
+		for index := range states {
+			if ctrl.bits[index] != reference.bits[index] {
+				t.Fatalf("Control decisions differ at index %v for hold %v", index, testCase.hold)
+			}
+			for row := range states[index] {
+				if math.Abs(states[index][row]-referenceStates[index][row]) > testCase.tolerance {
+					t.Fatalf("State %v at index %v differs for hold %v, %v != %v", row, index, testCase.hold, states[index][row], referenceStates[index][row])
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkAnalogSwitchControlExactDiscretization(b *testing.B) {
+	order := 4
+	stateSpaceModel, controls := integratorChain(order, func(arg1 float64) float64 { return 0.5 * math.Sin(2*math.Pi*10*arg1) })
+
+	ctrl := NewAnalogSwitchControl(1000, controls, chainTs, 0., nil, stateSpaceModel)
+	ctrl.UseExactDiscretization(ssm.FirstOrderHold)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctrl.Simulate()
+	}
+}
+
 func TestBitsToIndex(t *testing.T) {
 	indices := []uint{1, 2, 3, 4, 5, 6}
 	bits := [][]uint{{1}, {0, 1}, {1, 1}, {0, 0, 1}, {1, 0, 1}, {0, 1, 1}}
@@ -159,3 +212,201 @@ func TestBitsToIndex(t *testing.T) {
 	// 	}
 	// }
 }
+
+func TestAnalogSwitchControlObservation(t *testing.T) {
+	order := 3
+	length := 2000
+
+	// Only the first and last state are controlled. The middle state is a leaky
+	// integrator in between.
+	controls := make([]mat.Vector, 2)
+	controls[0] = mat.NewVecDense(order, []float64{-chainGain, 0, 0})
+	controls[1] = mat.NewVecDense(order, []float64{0, 0, -chainGain})
+	A := mat.NewDense(order, order, []float64{
+		0, 0, 0,
+		chainGain, -chainGain / 10., 0,
+		0, chainGain, 0,
+	})
+	inp := make([]signal.VectorFunction, 1)
+	inp[0] = signal.NewInput(func(arg1 float64) float64 { return 0.5 * math.Sin(2*math.Pi*50*arg1) }, mat.NewVecDense(order, []float64{chainGain, 0, 0}))
+	stateSpaceModel := ssm.NewLinearStateSpaceModel(A, mat.NewDense(order, order, []float64{1, 0, 0, 0, 1, 0, 0, 0, 1}), inp)
+
+	ctrl := NewAnalogSwitchControl(length, controls, chainTs, 0., nil, stateSpaceModel)
+	if err := ctrl.SetObservation(mat.NewDense(2, 2, nil)); err == nil {
+		t.Error("Expected an error for a mismatching observation")
+	}
+	if err := ctrl.SetObservation(ObservationFromControls(controls)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctrl.UseExactDiscretization(ssm.ZeroOrderHold); err != nil {
+		t.Fatal(err)
+	}
+	ctrl.Simulate()
+	state := ctrl.GetState()
+	for index := 0; index < order; index++ {
+		if math.Abs(state.AtVec(index)) > 10 {
+			t.Errorf("State %v = %v is not bounded", index, state.AtVec(index))
+		}
+	}
+
+	// For a one-to-one mapping the observation is the identity
+	identity := ObservationFromControls([]mat.Vector{
+		mat.NewVecDense(2, []float64{-chainGain, 0}),
+		mat.NewVecDense(2, []float64{0, -chainGain}),
+	})
+	if !mat.Equal(identity, mat.NewDense(2, 2, []float64{1, 0, 0, 1})) {
+		t.Errorf("Expected the identity but got\n%v", mat.Formatted(identity))
+	}
+}
+
+func TestAnalogSwitchControlErrors(t *testing.T) {
+	order := 2
+	controls := []mat.Vector{mat.NewVecDense(order, []float64{-1, 0}), mat.NewVecDense(order, []float64{0, -1})}
+	inp := []signal.VectorFunction{signal.NewInput(math.Sin, mat.NewVecDense(order, []float64{1, 0}))}
+	stateSpaceModel := ssm.NewIntegratorChain(order, 1, inp)
+
+	if _, err := NewAnalogSwitchControlChecked(10, controls[:1], 1e-3, 0, mat.NewVecDense(3, nil), stateSpaceModel); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for the initial state but got %v", err)
+	}
+	if _, err := NewAnalogSwitchControlChecked(10, []mat.Vector{mat.NewVecDense(3, nil)}, 1e-3, 0, nil, stateSpaceModel); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for the control vector but got %v", err)
+	}
+	ctrl, err := NewAnalogSwitchControlChecked(10, controls, 1e-3, 0, nil, stateSpaceModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctrl.GetForwardControlFilterContribution(0); err != ErrNotPrecomputed {
+		t.Errorf("Expected ErrNotPrecomputed but got %v", err)
+	}
+	ctrl.PreComputeFilterContributions(stateSpaceModel.A, stateSpaceModel.A)
+	if _, err = ctrl.GetBackwardControlFilterContribution(10); err != ErrIndexOutOfRange {
+		t.Errorf("Expected ErrIndexOutOfRange but got %v", err)
+	}
+	if err = ctrl.SetControlDecisions(make([]uint, 3)); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for the control decisions but got %v", err)
+	}
+	decisions := make([]uint, 10)
+	decisions[0] = 3
+	if err = ctrl.SetControlDecisions(decisions); err != nil {
+		t.Errorf("Valid control decisions rejected with %v", err)
+	}
+	decisions[0] = 4
+	if err = ctrl.SetControlDecisions(decisions); err == nil {
+		t.Error("Expected an error for a code word with too many controls")
+	}
+
+	// The number of controls is limited by the code word tables
+	many := make([]mat.Vector, MaxNumberOfControls+1)
+	for index := range many {
+		many[index] = controls[index%order]
+	}
+	if _, err := NewAnalogSwitchControlChecked(10, many, 1e-3, 0, nil, stateSpaceModel); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for too many controls but got %v", err)
+	}
+}
+
+func TestBiLinearControls(t *testing.T) {
+	N := 3
+	length := 100
+	linear, controls := integratorChain(N, func(arg1 float64) float64 { return 0.5 * math.Sin(math.Pi*2.*arg1*100.) })
+	// Without bilinear state dynamics the models are equivalent
+	biLinear := ssm.NewBiLinearStateSpaceModel(linear.A, mat.NewDense(N, N, nil), linear.C, linear.Input)
+
+	switchedCapacitors := make([]SwitchedCapacitor, N)
+	for index := range switchedCapacitors {
+		switchedCapacitors[index] = SwitchedCapacitor{R: R, C: C, B: controls[index]}
+	}
+
+	linearCtrl := NewAnalogSwitchControl(length, controls, chainTs, 0, nil, linear)
+	biLinearCtrl, err := NewBiLinearAnalogSwitchControlChecked(length, controls, chainTs, 0, nil, biLinear)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linearStates, biLinearStates := linearCtrl.Simulate(), biLinearCtrl.Simulate()
+	for index := range linearStates {
+		for row := range linearStates[index] {
+			if math.Abs(linearStates[index][row]-biLinearStates[index][row]) > 1e-6 {
+				t.Fatalf("Bilinear state %v doesn't match the linear state %v at index %v", biLinearStates[index], linearStates[index], index)
+			}
+		}
+	}
+
+	linearSC := NewSwitchedCapacitorControl(length, switchedCapacitors, chainTs, 0, nil, linear)
+	biLinearSC := NewBiLinearSwitchedCapacitorControl(length, switchedCapacitors, chainTs, 0, nil, biLinear)
+	linearStates, biLinearStates = linearSC.Simulate(), biLinearSC.Simulate()
+	for index := range linearStates {
+		for row := range linearStates[index] {
+			if math.Abs(linearStates[index][row]-biLinearStates[index][row]) > 1e-5 {
+				t.Fatalf("Bilinear state %v doesn't match the linear state %v at index %v", biLinearStates[index], linearStates[index], index)
+			}
+		}
+	}
+
+	// For a constant input u the bilinear model is the linear model with the
+	// state dynamics AL + u AB, the switched capacitors included.
+	u := 0.5
+	AB := mat.NewDense(N, N, []float64{-chainGain / 10, 0, 0, 0, -chainGain / 20, 0, 0, 0, 0})
+	constant := []signal.VectorFunction{signal.NewInput(func(float64) float64 { return u }, linear.Input[0].B)}
+	var A mat.Dense
+	A.Scale(u, AB)
+	A.Add(linear.A, &A)
+	linearSC = NewSwitchedCapacitorControl(length, switchedCapacitors, chainTs, 0, nil, ssm.NewLinearStateSpaceModel(&A, linear.C, constant))
+	biLinearSC = NewBiLinearSwitchedCapacitorControl(length, switchedCapacitors, chainTs, 0, nil, ssm.NewBiLinearStateSpaceModel(linear.A, AB, linear.C, constant))
+	linearStates, biLinearStates = linearSC.Simulate(), biLinearSC.Simulate()
+	for index := range linearStates {
+		for row := range linearStates[index] {
+			if math.Abs(linearStates[index][row]-biLinearStates[index][row]) > 1e-5 {
+				t.Fatalf("Bilinear state %v doesn't match the linear state %v at index %v", biLinearStates[index], linearStates[index], index)
+			}
+		}
+	}
+
+	oscillators := []signal.VectorFunction{signal.NewInput(func(arg1 float64) float64 { return math.Sin(math.Pi * 2. * arg1 * 4000.) }, controls[0])}
+	// The oscillator control is stiff and therefore slow to simulate
+	linearOscillator := NewAnalogOscillatorControl(10, oscillators, chainTs, 0, nil, linear)
+	biLinearOscillator := NewBiLinearOscillatorControl(10, oscillators, chainTs, 0, nil, biLinear)
+	linearOscillator.Simulate()
+	biLinearOscillator.Simulate()
+	for index := range linearOscillator.bits {
+		if linearOscillator.bits[index] != biLinearOscillator.bits[index] {
+			t.Fatalf("Bilinear control decisions don't match the linear control decisions at index %v", index)
+		}
+	}
+
+	if _, err := NewBiLinearAnalogSwitchControlChecked(length, controls, chainTs, 0, nil, &ssm.BiLinearStateSpaceModel{AL: linear.A, AB: mat.NewDense(N, 2*N, nil), C: linear.C, Input: linear.Input}); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch but got %v", err)
+	}
+}
+
+func TestNonLinearAnalogSwitchControl(t *testing.T) {
+	N := 3
+	length := 200
+	linear, controls := integratorChain(N, func(arg1 float64) float64 { return 0.9 * math.Sin(math.Pi*2.*arg1*100.) })
+
+	// Ideal integrators simulate as the linear model
+	linearStates := NewAnalogSwitchControl(length, controls, chainTs, 0, nil, linear).Simulate()
+	ideal := ssm.NewNonLinearStateSpaceModel(linear, make([]ssm.Integrator, N))
+	idealStates := NewNonLinearAnalogSwitchControl(length, controls, chainTs, 0, nil, ideal).Simulate()
+	for index := range linearStates {
+		for row := range linearStates[index] {
+			if math.Abs(linearStates[index][row]-idealStates[index][row]) > 1e-6 {
+				t.Fatalf("Nonlinear state %v doesn't match the linear state %v at index %v", idealStates[index], linearStates[index], index)
+			}
+		}
+	}
+
+	// Saturating integrators bound the states
+	saturation := 0.5
+	integrators := make([]ssm.Integrator, N)
+	for index := range integrators {
+		integrators[index] = ssm.Integrator{Saturation: saturation, DCGain: 1e3, SlewRate: 4 * chainGain}
+	}
+	states := NewNonLinearAnalogSwitchControl(length, controls, chainTs, 0, nil, ssm.NewNonLinearStateSpaceModel(linear, integrators)).Simulate()
+	for index := range states {
+		for row := range states[index] {
+			if math.Abs(states[index][row]) > saturation+1e-3 {
+				t.Fatalf("State %v exceeds the saturation at index %v", states[index], index)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package control
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Comparator models the quantizer that turns the observed value of a control
+// into a decision bit.
+type Comparator interface {
+	// Compare returns the decision bit for the observed value given the
+	// previous decision bit of the same comparator.
+	Compare(value float64, previous uint) uint
+}
+
+// IdealComparator decides 1 for positive values and 0 otherwise.
+type IdealComparator struct{}
+
+// Compare returns the ideal decision
+func (ic IdealComparator) Compare(value float64, previous uint) uint {
+	if value > 0 {
+		return 1
+	}
+	return 0
+}
+
+// NonIdealComparator models a comparator with the most common non-idealities.
+// The zero value is an ideal comparator.
+type NonIdealComparator struct {
+	// Decision threshold, i.e., the offset of the comparator
+	Threshold float64
+	// Width of the hysteresis around the threshold. The threshold is moved
+	// Hysteresis / 2 towards the previous decision.
+	Hysteresis float64
+	// The decision is random when the value is closer than
+	// MetastabilityWindow to the threshold.
+	MetastabilityWindow float64
+	// Standard deviation of the additive Gaussian comparator noise
+	NoiseStandardDeviation float64
+	// Source of randomness, for reproducibility. If nil the math/rand default
+	// source is used.
+	Rand *rand.Rand
+}
+
+// Compare returns the non-ideal decision
+func (nc NonIdealComparator) Compare(value float64, previous uint) uint {
+	threshold := nc.Threshold
+	if previous > 0 {
+		threshold -= nc.Hysteresis / 2.
+	} else {
+		threshold += nc.Hysteresis / 2.
+	}
+	if nc.NoiseStandardDeviation > 0 {
+		value += nc.NoiseStandardDeviation * nc.normFloat64()
+	}
+	if math.Abs(value-threshold) < nc.MetastabilityWindow {
+		return uint(nc.int63() & 1)
+	}
+	if value > threshold {
+		return 1
+	}
+	return 0
+}
+
+func (nc NonIdealComparator) normFloat64() float64 {
+	if nc.Rand != nil {
+		return nc.Rand.NormFloat64()
+	}
+	return rand.NormFloat64()
+}
+
+func (nc NonIdealComparator) int63() int64 {
+	if nc.Rand != nil {
+		return nc.Rand.Int63()
+	}
+	return rand.Int63()
+}
+
+// ThermometerQuantizer returns a multi-level quantizer realised as levels - 1
+// binary comparators with thresholds evenly spread between -fullScale and
+// fullScale, together with the matching unit element control vectors. The sum of
+// the unit elements results in levels evenly spaced contributions between
+// -vector and vector. The comparators and control vectors are to be appended to
+// those of the control.
+func ThermometerQuantizer(vector mat.Vector, levels int, fullScale float64) ([]mat.Vector, []Comparator, error) {
+	if levels < 2 {
+		return nil, nil, errors.New("A quantizer needs at least two levels")
+	}
+	numberOfUnitElements := levels - 1
+	controls := make([]mat.Vector, numberOfUnitElements)
+	comparators := make([]Comparator, numberOfUnitElements)
+	for index := range controls {
+		var tmpVec mat.VecDense
+		tmpVec.ScaleVec(1./float64(numberOfUnitElements), vector)
+		controls[index] = &tmpVec
+		// Thresholds are placed in between the output levels
+		comparators[index] = NonIdealComparator{
+			Threshold: fullScale * (-1. + float64(2*index+1)/float64(numberOfUnitElements)),
+		}
+	}
+	return controls, comparators, nil
+}
+
+// checkComparators checks that there is one comparator per control
+func checkComparators(comparators []Comparator, numberOfControls int) error {
+	if comparators != nil && len(comparators) != numberOfControls {
+		return &ssm.DimensionError{What: "Number of comparators doesn't match the number of controls"}
+	}
+	return nil
+}
+
+// decide returns the decision bit of control for the observed value. Controls
+// without a comparator use an ideal comparator.
+func decide(comparators []Comparator, control int, value float64, previous uint) uint {
+	if comparators == nil || comparators[control] == nil {
+		return IdealComparator{}.Compare(value, previous)
+	}
+	return comparators[control].Compare(value, previous)
+}
+
+// previousBits returns the decision bits of the sample before index.
+func previousBits(bits []uint, index, numberOfControls int) []uint {
+	if index > 0 {
+		return indexToBits(bits[index-1], numberOfControls)
+	}
+	return make([]uint, numberOfControls)
+}
@@ -0,0 +1,110 @@
+package control
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestNonIdealComparator(t *testing.T) {
+	// Zero value is ideal
+	ideal := NonIdealComparator{}
+	if ideal.Compare(1e-12, 0) != 1 || ideal.Compare(-1e-12, 1) != 0 || ideal.Compare(0, 1) != 0 {
+		t.Error("Zero value comparator is not ideal")
+	}
+
+	offset := NonIdealComparator{Threshold: 0.1}
+	if offset.Compare(0.05, 0) != 0 || offset.Compare(0.15, 0) != 1 {
+		t.Error("Threshold not respected")
+	}
+
+	hysteresis := NonIdealComparator{Hysteresis: 0.2}
+	if hysteresis.Compare(0.05, 0) != 0 || hysteresis.Compare(-0.05, 1) != 1 {
+		t.Error("Hysteresis doesn't hold the previous decision")
+	}
+	if hysteresis.Compare(0.15, 0) != 1 || hysteresis.Compare(-0.15, 1) != 0 {
+		t.Error("Hysteresis prevents decisions outside the hysteresis")
+	}
+
+	// Metastable decisions are random but reproducible with a seeded source
+	metastable1 := NonIdealComparator{MetastabilityWindow: 1e-3, Rand: rand.New(rand.NewSource(1))}
+	metastable2 := NonIdealComparator{MetastabilityWindow: 1e-3, Rand: rand.New(rand.NewSource(1))}
+	ones := 0
+	for index := 0; index < 1000; index++ {
+		decision := metastable1.Compare(1e-4, 0)
+		if decision != metastable2.Compare(1e-4, 0) {
+			t.Fatal("Seeded comparators are not reproducible")
+		}
+		ones += int(decision)
+	}
+	if ones < 400 || ones > 600 {
+		t.Errorf("Metastable decisions are not random, %v ones out of 1000", ones)
+	}
+	if metastable1.Compare(1e-2, 0) != 1 {
+		t.Error("Decision outside of metastability window should be deterministic")
+	}
+
+	noisy := NonIdealComparator{NoiseStandardDeviation: 1., Rand: rand.New(rand.NewSource(2))}
+	ones = 0
+	for index := 0; index < 1000; index++ {
+		ones += int(noisy.Compare(0., 0))
+	}
+	if ones < 400 || ones > 600 {
+		t.Errorf("Comparator noise doesn't randomise decisions, %v ones out of 1000", ones)
+	}
+}
+
+func TestThermometerQuantizer(t *testing.T) {
+	levels := 5
+	vector := mat.NewVecDense(2, []float64{-4., 0})
+	controls, comparators, err := ThermometerQuantizer(vector, levels, 1.)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(controls) != levels-1 || len(comparators) != levels-1 {
+		t.Fatal("Wrong number of unit elements")
+	}
+
+	// The quantizer should map the input to the nearest of the levels
+	for _, value := range []float64{-1., -0.6, -0.2, 0.1, 0.4, 0.9} {
+		sum := 0.
+		for index := range controls {
+			decision := comparators[index].Compare(value, 0)
+			sum += (2*float64(decision) - 1) * controls[index].AtVec(0)
+		}
+		expected := (math.Floor(2*value+2.5)/2 - 1) * vector.AtVec(0)
+		if math.Abs(sum-expected) > 1e-12 {
+			t.Errorf("Quantized %v to %v instead of %v", value, sum, expected)
+		}
+	}
+
+	if _, _, err = ThermometerQuantizer(vector, 1, 1.); err == nil {
+		t.Error("Expected an error for a single level quantizer")
+	}
+}
+
+func TestAnalogSwitchControlComparators(t *testing.T) {
+	order := 2
+	length := 50
+	stateSpaceModel, controls := integratorChain(order, func(arg1 float64) float64 { return 0.5 })
+
+	ctrl := NewAnalogSwitchControl(length, controls, chainTs, 0., nil, stateSpaceModel)
+	if err := ctrl.SetComparators([]Comparator{IdealComparator{}}); err == nil {
+		t.Error("Expected an error for a mismatching number of comparators")
+	}
+
+	// A comparator that never switches
+	if err := ctrl.SetComparators([]Comparator{NonIdealComparator{Threshold: math.Inf(1)}, nil}); err != nil {
+		t.Fatal(err)
+	}
+	ctrl.UseExactDiscretization(ssm.ZeroOrderHold)
+	ctrl.Simulate()
+	for index, codeWord := range ctrl.GetControlDecisions() {
+		if codeWord&1 != 0 {
+			t.Fatalf("Control 0 switched at index %v despite an infinite threshold", index)
+		}
+	}
+}
@@ -1,9 +1,24 @@
 package control
 
 import (
+	"github.com/hammal/adc/ssm"
 	"gonum.org/v1/gonum/mat"
 )
 
+// MaxNumberOfControls is the largest number of controls. The control
+// contributions are tabulated for each of the 2^NumberOfControls code words
+// which limits the number of controls.
+const MaxNumberOfControls = 20
+
+// checkNumberOfControls returns a *ssm.DimensionError unless the number of
+// controls is between 1 and MaxNumberOfControls.
+func checkNumberOfControls(numberOfControls int) error {
+	if numberOfControls < 1 || numberOfControls > MaxNumberOfControls {
+		return &ssm.DimensionError{What: "Number of controls must be between 1 and MaxNumberOfControls"}
+	}
+	return nil
+}
+
 // Control interface holds the Control instance which is capable of simulating
 // the system as well as providing the filter contributions of the controls at a given index.
 type Control interface {
@@ -20,6 +35,22 @@ type Control interface {
 	GetLength() int
 	// get the sample period
 	GetTs() float64
+	// get the starting time
+	GetT0() float64
+}
+
+// StreamingControl is a control whose filter contributions only depend on the
+// control decision code word and not on the time index. Such controls can be
+// reconstructed from a stream of control decisions.
+type StreamingControl interface {
+	Control
+	// Get the control contribution for filtering of the code word
+	GetForwardCodeWordContribution(codeWord uint) (mat.Vector, error)
+	GetBackwardCodeWordContribution(codeWord uint) (mat.Vector, error)
+	// Number of controls, i.e., bits of a code word
+	GetNumberOfControls() int
+	// Control decision code words of the simulation
+	GetControlDecisions() []uint
 }
 
 // Cache interface is an abstraction that is heavily used when precomputing
@@ -27,3 +58,9 @@ type Control interface {
 type ControlVector interface {
 	GetVector(uint) mat.Vector
 }
+
+// Compile-time checks of the controls that support streaming
+var (
+	_ StreamingControl = (*AnalogSwitchControl)(nil)
+	_ StreamingControl = (*SwitchedCapacitorControl)(nil)
+)
@@ -0,0 +1,248 @@
+package control
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// The control bit stream is a compact binary format for control decisions.
+// It consists of
+//
+// - a header with the magic "CBIT", the format version, the number of controls,
+// the sample period Ts and the starting time T0 (little endian),
+//
+// - the control decisions packed as bits. Sample k of control i is stored at
+// bit position k * numberOfControls + i, least significant bit first,
+//
+// - a trailing byte holding the number of valid bits in the last data byte.
+//
+// As the number of samples is not part of the header the stream can be written
+// incrementally.
+
+// controlBitStreamVersion is the current version of the control bit stream.
+const controlBitStreamVersion = 1
+
+var controlBitStreamMagic = [4]byte{'C', 'B', 'I', 'T'}
+
+type controlBitStreamHeader struct {
+	Magic            [4]byte
+	Version          uint16
+	NumberOfControls uint16
+	Ts               float64
+	T0               float64
+}
+
+// ControlBitWriter writes control decisions as a packed control bit stream.
+type ControlBitWriter struct {
+	writer           *bufio.Writer
+	numberOfControls int
+	// Partially filled byte and number of bits used
+	current   byte
+	usedBits  uint
+	completed bool
+}
+
+// NewControlBitWriter writes the control bit stream header to w and returns a
+// writer for the control decisions. Close must be called when all decisions
+// have been written.
+func NewControlBitWriter(w io.Writer, numberOfControls int, ts, t0 float64) (*ControlBitWriter, error) {
+	if err := checkNumberOfControls(numberOfControls); err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(w)
+	header := controlBitStreamHeader{
+		Magic:            controlBitStreamMagic,
+		Version:          controlBitStreamVersion,
+		NumberOfControls: uint16(numberOfControls),
+		Ts:               ts,
+		T0:               t0,
+	}
+	if err := binary.Write(writer, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	return &ControlBitWriter{
+		writer:           writer,
+		numberOfControls: numberOfControls,
+	}, nil
+}
+
+// Write appends the control decision code word of one sample to the stream.
+func (cw *ControlBitWriter) Write(codeWord uint) error {
+	if cw.completed {
+		return errors.New("Control bit stream is closed")
+	}
+	for control := 0; control < cw.numberOfControls; control++ {
+		if (codeWord>>uint(control))&1 > 0 {
+			cw.current |= 1 << cw.usedBits
+		}
+		cw.usedBits++
+		if cw.usedBits == 8 {
+			if err := cw.writer.WriteByte(cw.current); err != nil {
+				return err
+			}
+			cw.current = 0
+			cw.usedBits = 0
+		}
+	}
+	return nil
+}
+
+// Close writes the last partially filled byte and the trailer and flushes the
+// stream. It doesn't close the underlying writer.
+func (cw *ControlBitWriter) Close() error {
+	if cw.completed {
+		return nil
+	}
+	cw.completed = true
+	validBits := byte(8)
+	if cw.usedBits > 0 {
+		if err := cw.writer.WriteByte(cw.current); err != nil {
+			return err
+		}
+		validBits = byte(cw.usedBits)
+	}
+	if err := cw.writer.WriteByte(validBits); err != nil {
+		return err
+	}
+	return cw.writer.Flush()
+}
+
+// ControlBitReader reads control decisions from a control bit stream.
+type ControlBitReader struct {
+	reader *bufio.Reader
+	header controlBitStreamHeader
+	// Current data byte and remaining number of valid bits
+	current       byte
+	position      uint
+	availableBits uint
+}
+
+// NewControlBitReader reads the control bit stream header from r and returns
+// a reader for the control decisions.
+func NewControlBitReader(r io.Reader) (*ControlBitReader, error) {
+	cr := ControlBitReader{
+		reader: bufio.NewReader(r),
+	}
+	if err := binary.Read(cr.reader, binary.LittleEndian, &cr.header); err != nil {
+		return nil, err
+	}
+	if cr.header.Magic != controlBitStreamMagic {
+		return nil, errors.New("Not a control bit stream")
+	}
+	if cr.header.Version != controlBitStreamVersion {
+		return nil, errors.New("Unsupported control bit stream version")
+	}
+	if err := checkNumberOfControls(int(cr.header.NumberOfControls)); err != nil {
+		return nil, err
+	}
+	return &cr, nil
+}
+
+// NumberOfControls returns the number of controls of the stream
+func (cr *ControlBitReader) NumberOfControls() int { return int(cr.header.NumberOfControls) }
+
+// GetTs returns the sample period of the stream
+func (cr *ControlBitReader) GetTs() float64 { return cr.header.Ts }
+
+// GetT0 returns the starting time of the stream
+func (cr *ControlBitReader) GetT0() float64 { return cr.header.T0 }
+
+// nextBit returns the next decision bit of the stream
+func (cr *ControlBitReader) nextBit() (uint, error) {
+	if cr.availableBits == 0 {
+		// At least a data byte and the trailer must remain
+		next, err := cr.reader.Peek(2)
+		if len(next) < 2 {
+			if err == io.EOF && len(next) == 1 {
+				return 0, io.EOF
+			}
+			return 0, io.ErrUnexpectedEOF
+		}
+		cr.availableBits = 8
+		// Check if this is the last data byte
+		if last, _ := cr.reader.Peek(3); len(last) == 2 {
+			cr.availableBits = uint(last[1])
+			if cr.availableBits == 0 || cr.availableBits > 8 {
+				return 0, errors.New("Corrupt control bit stream trailer")
+			}
+		}
+		cr.current, _ = cr.reader.ReadByte()
+		cr.position = 0
+	}
+	bit := uint(cr.current>>cr.position) & 1
+	cr.position++
+	cr.availableBits--
+	return bit, nil
+}
+
+// Read returns the control decision code word of the next sample. Returns
+// io.EOF when there are no more samples.
+func (cr *ControlBitReader) Read() (uint, error) {
+	var codeWord uint
+	for control := 0; control < cr.NumberOfControls(); control++ {
+		bit, err := cr.nextBit()
+		if err != nil {
+			if err == io.EOF && control > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		codeWord |= bit << uint(control)
+	}
+	return codeWord, nil
+}
+
+// ReadAll returns all remaining control decision code words of the stream.
+func (cr *ControlBitReader) ReadAll() ([]uint, error) {
+	var res []uint
+	for {
+		codeWord, err := cr.Read()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
+		res = append(res, codeWord)
+	}
+}
+
+// WriteControlDecisions writes the control decisions to w as a control bit
+// stream.
+func (c AnalogSwitchControl) WriteControlDecisions(w io.Writer) error {
+	cw, err := NewControlBitWriter(w, c.NumberOfControls, c.Ts, c.T0)
+	if err != nil {
+		return err
+	}
+	for _, codeWord := range c.bits {
+		if err = cw.Write(codeWord); err != nil {
+			return err
+		}
+	}
+	return cw.Close()
+}
+
+// ReadControlDecisions replaces the control decisions with those of the control
+// bit stream r. The length of the control is set to the number of samples in
+// the stream and the starting time to that of the stream.
+func (c *AnalogSwitchControl) ReadControlDecisions(r io.Reader) error {
+	cr, err := NewControlBitReader(r)
+	if err != nil {
+		return err
+	}
+	if cr.NumberOfControls() != c.NumberOfControls {
+		return errors.New("Number of controls in stream doesn't match the control")
+	}
+	if cr.GetTs() != c.Ts {
+		return errors.New("Sample period in stream doesn't match the control")
+	}
+	bits, err := cr.ReadAll()
+	if err != nil {
+		return err
+	}
+	c.bits = bits
+	c.T0 = cr.GetT0()
+	return nil
+}
@@ -0,0 +1,110 @@
+package control
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestControlBitStream(t *testing.T) {
+	for _, numberOfControls := range []int{1, 3, 8, 11} {
+		for _, length := range []int{0, 1, 7, 8, 1001} {
+			decisions := make([]uint, length)
+			for index := range decisions {
+				decisions[index] = uint(rand.Intn(1 << uint(numberOfControls)))
+			}
+
+			var buffer bytes.Buffer
+			cw, err := NewControlBitWriter(&buffer, numberOfControls, 1e-3, 0.5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, codeWord := range decisions {
+				if err = cw.Write(codeWord); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = cw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			cr, err := NewControlBitReader(&buffer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cr.NumberOfControls() != numberOfControls || cr.GetTs() != 1e-3 || cr.GetT0() != 0.5 {
+				t.Error("Header doesn't match")
+			}
+			res, err := cr.ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != length {
+				t.Fatalf("Read %v decisions but wrote %v for %v controls", len(res), length, numberOfControls)
+			}
+			for index := range res {
+				if res[index] != decisions[index] {
+					t.Fatalf("Decision %v is %v but should be %v", index, res[index], decisions[index])
+				}
+			}
+		}
+	}
+}
+
+func TestControlBitStreamCorrupt(t *testing.T) {
+	if _, err := NewControlBitReader(bytes.NewReader([]byte("Not a stream at all, but long enough"))); err == nil {
+		t.Error("Expected an error for a missing magic")
+	}
+
+	var buffer bytes.Buffer
+	cw, _ := NewControlBitWriter(&buffer, 4, 1e-3, 0)
+	cw.Write(5)
+	cw.Write(10)
+	cw.Close()
+	// Remove the trailer
+	truncated := buffer.Bytes()[:buffer.Len()-2]
+	cr, err := NewControlBitReader(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cr.ReadAll(); err == nil {
+		t.Error("Expected an error for a truncated stream")
+	}
+
+	if _, err := NewControlBitWriter(&buffer, MaxNumberOfControls+1, 1e-3, 0); err == nil {
+		t.Error("Expected an error for too many controls")
+	}
+}
+
+func TestAnalogSwitchControlDecisionStream(t *testing.T) {
+	order := 3
+	length := 50
+	stateSpaceModel, controls := integratorChain(order, func(arg1 float64) float64 { return 0. })
+
+	ctrl := NewAnalogSwitchControl(length, controls, chainTs, 0., nil, stateSpaceModel)
+	decisions := make([]uint, length)
+	for index := range decisions {
+		decisions[index] = uint(rand.Intn(1 << uint(order)))
+	}
+	if err := ctrl.SetControlDecisions(decisions); err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	if err := ctrl.WriteControlDecisions(&buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewAnalogSwitchControl(1, controls, chainTs, 0., nil, stateSpaceModel)
+	if err := other.ReadControlDecisions(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	if other.GetLength() != length {
+		t.Fatalf("Length %v doesn't match %v", other.GetLength(), length)
+	}
+	for index, codeWord := range other.GetControlDecisions() {
+		if codeWord != decisions[index] {
+			t.Fatalf("Decision %v differs", index)
+		}
+	}
+}
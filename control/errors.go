@@ -0,0 +1,12 @@
+package control
+
+import "errors"
+
+var (
+	// ErrNotPrecomputed is returned when filter contributions are requested
+	// before PreComputeFilterContributions.
+	ErrNotPrecomputed = errors.New("No pre-computed filter decisions")
+	// ErrIndexOutOfRange is returned for indices outside of the control
+	// length.
+	ErrIndexOutOfRange = errors.New("Index out of range")
+)
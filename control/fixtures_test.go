@@ -0,0 +1,30 @@
+package control
+
+import (
+	"github.com/hammal/adc/signal"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// chainGain is the stage gain of the integrator chain fixture
+	chainGain = 6250.
+	// chainTs is the sampling period of the integrator chain fixture
+	chainTs = 1. / 16000.
+)
+
+// integratorChain returns an integrator chain of the given order where sig
+// enters the first state, together with a control per state.
+func integratorChain(order int, sig func(float64) float64) (*ssm.LinearStateSpaceModel, []mat.Vector) {
+	b := mat.NewVecDense(order, nil)
+	b.SetVec(0, chainGain)
+	stateSpaceModel := ssm.NewIntegratorChain(order, chainGain, []signal.VectorFunction{signal.NewInput(sig, b)})
+
+	controls := make([]mat.Vector, order)
+	for index := range controls {
+		tmp := mat.NewVecDense(order, nil)
+		tmp.SetVec(index, -chainGain)
+		controls[index] = tmp
+	}
+	return stateSpaceModel, controls
+}
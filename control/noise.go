@@ -0,0 +1,213 @@
+package control
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+// flickerPolesPerDecade is the number of first order processes per decade
+// approximating 1/f noise.
+const flickerPolesPerDecade = 2
+
+// Noise describes the noise injected into a simulation. Zero values are
+// noiseless and the same seed reproduces the same noise.
+type Noise struct {
+	// Per state white (thermal) noise intensities q_i of the state
+	// derivatives, i.e., E[w_i(t) w_i(s)] = q_i delta(t - s).
+	Thermal []float64
+	// Per state 1/f noise of the state derivatives with the power spectral
+	// density K_i / |f| from the inverse duration of the simulation. The
+	// density rolls off close to half the sample rate.
+	Flicker []float64
+	// Standard deviation [s] of the clock jitter of the control transitions
+	Jitter float64
+	// Seed of the random number generator
+	Seed int64
+}
+
+// copy returns a deep copy of the noise, nil if noise is nil.
+func (n *Noise) copy() *Noise {
+	if n == nil {
+		return nil
+	}
+	res := *n
+	res.Thermal = append([]float64(nil), n.Thermal...)
+	res.Flicker = append([]float64(nil), n.Flicker...)
+	return &res
+}
+
+// noiseSource generates the noise contributions of each sample. For linear
+// models the thermal noise is sampled from the exact discrete noise covariance,
+// otherwise it's integrated with the Euler-Maruyama method.
+type noiseSource struct {
+	rng *rand.Rand
+	// square root of the per sample thermal noise covariance
+	thermal *mat.Dense
+	// flicker noise input matrix, the contribution of a constant input over a
+	// sample, and per state first order processes
+	flickerInput *mat.Dense
+	flicker      []flickerProcess
+	jitter       float64
+}
+
+// flickerProcess is a sum of first order processes x[k+1] = a x[k] + w[k]
+// with log-spaced poles whose sum has a 1/f power spectral density.
+type flickerProcess struct {
+	poles, deviations, states []float64
+}
+
+// newNoiseSource returns the noise source of a simulation of the state space
+// model, nil if noise is nil.
+func newNoiseSource(noise *Noise, model ssm.StateSpaceModel, ts float64, length int) (*noiseSource, error) {
+	if noise == nil {
+		return nil, nil
+	}
+	order := model.StateSpaceOrder()
+	if noise.Thermal != nil && len(noise.Thermal) != order {
+		return nil, &ssm.DimensionError{What: "Thermal noise doesn't match the state space order"}
+	}
+	if noise.Flicker != nil && len(noise.Flicker) != order {
+		return nil, &ssm.DimensionError{What: "Flicker noise doesn't match the state space order"}
+	}
+	if noise.Jitter < 0 {
+		return nil, errors.New("Noise parameters must be non-negative")
+	}
+
+	intensities := make([]float64, order)
+	for index := range intensities {
+		if noise.Thermal != nil {
+			intensities[index] = noise.Thermal[index]
+		}
+		if intensities[index] < 0 || (noise.Flicker != nil && noise.Flicker[index] < 0) {
+			return nil, errors.New("Noise parameters must be non-negative")
+		}
+	}
+	Q := mat.NewDiagonal(order, intensities)
+	identity := mat.NewDiagonal(order, ones(order))
+
+	source := &noiseSource{
+		rng:    rand.New(rand.NewSource(noise.Seed)),
+		jitter: noise.Jitter,
+	}
+	var covariance *mat.Dense
+	if linear, ok := model.(*ssm.LinearStateSpaceModel); ok {
+		_, covariance = ssm.NoiseCovarianceDiscretization(linear.A, Q, ts)
+		_, source.flickerInput = ssm.ZeroOrderHoldDiscretization(linear.A, identity, ts)
+	} else {
+		covariance = mat.NewDense(order, order, nil)
+		covariance.Scale(ts, Q)
+		source.flickerInput = mat.NewDense(order, order, nil)
+		source.flickerInput.Scale(ts, identity)
+	}
+	var err error
+	if source.thermal, err = squareRoot(covariance); err != nil {
+		return nil, err
+	}
+
+	if noise.Flicker != nil {
+		// Poles from the inverse duration to half the sample rate where
+		// equally weighted log-spaced first order processes sum to
+		// sigma^2 / (2 Delta |f|) with Delta the logarithmic pole spacing.
+		spacing := math.Ln10 / flickerPolesPerDecade
+		var frequencies []float64
+		for f := 1. / (float64(length+1) * ts); f <= 1./(2*ts); f *= math.Exp(spacing) {
+			frequencies = append(frequencies, f)
+		}
+		source.flicker = make([]flickerProcess, order)
+		for index, K := range noise.Flicker {
+			process := flickerProcess{
+				poles:      make([]float64, len(frequencies)),
+				deviations: make([]float64, len(frequencies)),
+				states:     make([]float64, len(frequencies)),
+			}
+			variance := 2 * K * spacing
+			for pole, f := range frequencies {
+				process.poles[pole] = math.Exp(-2 * math.Pi * f * ts)
+				process.deviations[pole] = math.Sqrt(variance * (1 - process.poles[pole]*process.poles[pole]))
+				// Start in the stationary distribution
+				process.states[pole] = math.Sqrt(variance) * source.rng.NormFloat64()
+			}
+			source.flicker[index] = process
+		}
+	}
+	return source, nil
+}
+
+// step returns the thermal and flicker noise contribution to the state of the
+// next sample.
+func (n *noiseSource) step() *mat.VecDense {
+	order, _ := n.thermal.Dims()
+	white := mat.NewVecDense(order, nil)
+	for index := 0; index < order; index++ {
+		white.SetVec(index, n.rng.NormFloat64())
+	}
+	res := mat.NewVecDense(order, nil)
+	res.MulVec(n.thermal, white)
+	if n.flicker != nil {
+		input := mat.NewVecDense(order, nil)
+		for index := range n.flicker {
+			input.SetVec(index, n.flicker[index].step(n.rng))
+		}
+		var tmp mat.VecDense
+		tmp.MulVec(n.flickerInput, input)
+		res.AddVec(res, &tmp)
+	}
+	return res
+}
+
+// jitterContribution returns the first order state error when the transition
+// from the previous to the current control contribution is delayed by the
+// clock jitter.
+func (n *noiseSource) jitterContribution(previous, current mat.Vector) *mat.VecDense {
+	var res mat.VecDense
+	res.SubVec(previous, current)
+	res.ScaleVec(n.jitter*n.rng.NormFloat64(), &res)
+	return &res
+}
+
+// step returns the sum of the processes and advances them one sample.
+func (p *flickerProcess) step(rng *rand.Rand) float64 {
+	res := 0.
+	for pole := range p.states {
+		res += p.states[pole]
+		p.states[pole] = p.poles[pole]*p.states[pole] + p.deviations[pole]*rng.NormFloat64()
+	}
+	return res
+}
+
+// squareRoot returns L = V D^(1/2) such that L L^T = covariance for a
+// symmetric positive semi-definite covariance.
+func squareRoot(covariance mat.Matrix) (*mat.Dense, error) {
+	order, _ := covariance.Dims()
+	symmetric := mat.NewSymDense(order, nil)
+	for row := 0; row < order; row++ {
+		for column := row; column < order; column++ {
+			symmetric.SetSym(row, column, (covariance.At(row, column)+covariance.At(column, row))/2)
+		}
+	}
+	var eigen mat.EigenSym
+	if !eigen.Factorize(symmetric, true) {
+		return nil, errors.New("Eigenvalue decomposition of the noise covariance failed")
+	}
+	var res mat.Dense
+	res.EigenvectorsSym(&eigen)
+	for column, value := range eigen.Values(nil) {
+		scale := math.Sqrt(math.Max(value, 0))
+		for row := 0; row < order; row++ {
+			res.Set(row, column, res.At(row, column)*scale)
+		}
+	}
+	return &res, nil
+}
+
+func ones(n int) []float64 {
+	res := make([]float64, n)
+	for index := range res {
+		res[index] = 1
+	}
+	return res
+}
@@ -0,0 +1,148 @@
+package control
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestNoiseSource(t *testing.T) {
+	ts := 1e-3
+	A := mat.NewDense(2, 2, []float64{-100, 0, 50, -200})
+	model := ssm.NewLinearStateSpaceModel(A, mat.NewDense(2, 2, []float64{1, 0, 0, 1}), nil)
+	thermal := []float64{1, 4}
+
+	// The thermal noise of linear models follows the exact noise covariance
+	source, err := newNoiseSource(&Noise{Thermal: thermal, Seed: 1}, model, ts, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, Qd := ssm.NoiseCovarianceDiscretization(A, mat.NewDiagonal(2, thermal), ts)
+	samples := 20000
+	covariance := mat.NewDense(2, 2, nil)
+	for index := 0; index < samples; index++ {
+		var outer mat.Dense
+		sample := source.step()
+		outer.Outer(1./float64(samples), sample, sample)
+		covariance.Add(covariance, &outer)
+	}
+	for row := 0; row < 2; row++ {
+		for column := 0; column < 2; column++ {
+			if math.Abs(covariance.At(row, column)-Qd.At(row, column)) > 0.05*math.Sqrt(Qd.At(row, row)*Qd.At(column, column)) {
+				t.Errorf("Noise covariance\n%v\ninstead of\n%v", mat.Formatted(covariance), mat.Formatted(Qd))
+			}
+		}
+	}
+
+	// The flicker noise has the power spectral density K / |f| up to an eighth
+	// of the sample rate
+	K, segment, segments := 2., 256, 64
+	source, err = newNoiseSource(&Noise{Flicker: []float64{K, 0}, Seed: 2}, model, 1, segment*segments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	psd := make([]float64, segment/2)
+	window := make([]float64, segment)
+	power := 0.
+	for index := range window {
+		window[index] = math.Pow(math.Sin(math.Pi*float64(index)/float64(segment)), 2)
+		power += window[index] * window[index]
+	}
+	for s := 0; s < segments; s++ {
+		values := make([]float64, segment)
+		for index := range values {
+			values[index] = window[index] * source.flicker[0].step(source.rng)
+		}
+		for frequency := range psd {
+			var dft complex128
+			for index, value := range values {
+				dft += complex(value, 0) * cmplx.Exp(complex(0, -2*math.Pi*float64(frequency*index)/float64(segment)))
+			}
+			psd[frequency] += math.Pow(cmplx.Abs(dft), 2) / power / float64(segments)
+		}
+	}
+	estimate := 0.
+	for frequency := 2; frequency <= segment/8; frequency++ {
+		estimate += psd[frequency] * float64(frequency) / float64(segment) / float64(segment/8-1)
+	}
+	if math.Abs(estimate-K) > 0.2*K {
+		t.Errorf("Flicker noise f S(f) = %v instead of %v", estimate, K)
+	}
+
+	for _, noise := range []Noise{{Thermal: []float64{1}}, {Flicker: []float64{1, 2, 3}}} {
+		if _, err := newNoiseSource(&noise, model, ts, 1); !ssm.IsDimensionMismatch(err) {
+			t.Errorf("Expected a dimension mismatch but got %v", err)
+		}
+	}
+	for _, noise := range []Noise{{Thermal: []float64{1, -1}}, {Flicker: []float64{-1, 0}}, {Jitter: -1}} {
+		if _, err := newNoiseSource(&noise, model, ts, 1); err == nil {
+			t.Errorf("Expected an error for the negative noise %v", noise)
+		}
+	}
+}
+
+func TestSimulationNoise(t *testing.T) {
+	order := 3
+	length := 500
+	model, controls := integratorChain(order, func(arg1 float64) float64 { return 0.5 * math.Sin(2*math.Pi*100*arg1) })
+
+	simulate := func(noise *Noise) [][]float64 {
+		ctrl := NewAnalogSwitchControl(length, controls, chainTs, 0, nil, model)
+		ctrl.UseExactDiscretization(ssm.ZeroOrderHold)
+		if err := ctrl.SetNoise(noise); err != nil {
+			t.Fatal(err)
+		}
+		return ctrl.Simulate()
+	}
+	difference := func(a, b [][]float64) float64 {
+		res := 0.
+		for index := range a {
+			for row := range a[index] {
+				res = math.Max(res, math.Abs(a[index][row]-b[index][row]))
+			}
+		}
+		return res
+	}
+
+	noiseless := simulate(nil)
+	if difference(noiseless, simulate(&Noise{Seed: 3})) != 0 {
+		t.Error("Zero noise changes the simulation")
+	}
+	noise := &Noise{Thermal: []float64{1e-2, 1e-2, 1e-2}, Flicker: []float64{1, 0, 0}, Jitter: 1e-9, Seed: 3}
+	noisy := simulate(noise)
+	if difference(noisy, simulate(noise)) != 0 {
+		t.Error("Seeded noise is not reproducible")
+	}
+	if difference(noisy, noiseless) == 0 {
+		t.Error("Noise doesn't change the simulation")
+	}
+	noise.Seed = 4
+	if difference(noisy, simulate(noise)) == 0 {
+		t.Error("Different seeds give the same noise")
+	}
+	noise.Seed = 3
+
+	// Changing the noise after setting it doesn't change the simulation
+	ctrl := NewAnalogSwitchControl(length, controls, chainTs, 0, nil, model)
+	if err := ctrl.UseExactDiscretization(ssm.ZeroOrderHold); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctrl.SetNoise(noise); err != nil {
+		t.Fatal(err)
+	}
+	noise.Thermal, noise.Flicker[0] = noise.Thermal[:1], 0
+	if difference(noisy, ctrl.Simulate()) != 0 {
+		t.Error("Changing the noise after SetNoise changes the simulation")
+	}
+	if difference(noiseless, simulate(&Noise{Jitter: 1e-9})) == 0 {
+		t.Error("Jitter doesn't change the simulation")
+	}
+
+	ctrl = NewAnalogSwitchControl(length, controls, chainTs, 0, nil, model)
+	if err := ctrl.SetNoise(&Noise{Thermal: []float64{1}}); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch but got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// SimulationEvent describes one sample of a simulation.
+type SimulationEvent struct {
+	// Sample index and time of the control decision
+	Index int
+	Time  float64
+	// State at the time of the control decision
+	State mat.Vector
+	// Values the comparators decided from, one per control
+	Observed []float64
+	// Control decision code word, bit i corresponds to control i
+	Decision uint
+	// Controls whose decision changed compared to the previous sample
+	Switched []int
+}
+
+// Observer is notified at every sample of a simulation. Observers are added to
+// a control with AddObserver.
+type Observer interface {
+	Observe(event SimulationEvent)
+}
+
+// ObserverFunc lets an ordinary function be used as an Observer.
+type ObserverFunc func(event SimulationEvent)
+
+// Observe calls f(event)
+func (f ObserverFunc) Observe(event SimulationEvent) {
+	f(event)
+}
+
+// observers is the list of observers of a control
+type observers []Observer
+
+// notify creates the simulation event for index and passes it to all
+// observers.
+func (o observers) notify(index int, t float64, state mat.Vector, observed []float64, bits []uint, numberOfControls int) {
+	if len(o) == 0 {
+		return
+	}
+	event := SimulationEvent{
+		Index:    index,
+		Time:     t,
+		State:    mat.VecDenseCopyOf(state),
+		Observed: observed,
+		Decision: bits[index],
+	}
+	if index > 0 && bits[index] != bits[index-1] {
+		current := indexToBits(bits[index], numberOfControls)
+		previous := indexToBits(bits[index-1], numberOfControls)
+		for control := range current {
+			if current[control] != previous[control] {
+				event.Switched = append(event.Switched, control)
+			}
+		}
+	}
+	for _, observer := range o {
+		observer.Observe(event)
+	}
+}
+
+// EnergyPhaseObserver tracks the energy of the state and the phase between two
+// of its states, e.g., the two states of an oscillator.
+type EnergyPhaseObserver struct {
+	// Indices of the states used for the phase
+	First, Second int
+	// Each sample is printed to Writer unless nil
+	Writer io.Writer
+	// Energy of the state [V^2] per sample
+	Energy []float64
+	// Phase [deg] per sample, NaN if the state has too few states
+	Phase []float64
+}
+
+// NewEnergyPhaseObserver returns an energy and phase observer where the phase is
+// computed between state first and second.
+func NewEnergyPhaseObserver(first, second int, w io.Writer) *EnergyPhaseObserver {
+	return &EnergyPhaseObserver{
+		First:  first,
+		Second: second,
+		Writer: w,
+	}
+}
+
+// Observe records the energy and phase of the event
+func (ep *EnergyPhaseObserver) Observe(event SimulationEvent) {
+	energy := math.Pow(mat.Norm(event.State, 2), 2)
+	phase := math.NaN()
+	if ep.First < event.State.Len() && ep.Second < event.State.Len() {
+		phase = math.Atan2(event.State.AtVec(ep.Second), event.State.AtVec(ep.First)) / math.Pi * 180
+	}
+	ep.Energy = append(ep.Energy, energy)
+	ep.Phase = append(ep.Phase, phase)
+
+	if ep.Writer == nil {
+		return
+	}
+	fmt.Fprintf(ep.Writer, "Energy: Total = %5.e [V^2] and Phase = %+4.f [deg]", energy, phase)
+	if len(event.Switched) > 0 {
+		fmt.Fprint(ep.Writer, "\tControl Switch!\t")
+		for _, control := range event.Switched {
+			fmt.Fprintf(ep.Writer, "Nr %d,\t", control)
+		}
+	}
+	fmt.Fprintln(ep.Writer)
+}
+
+// SwitchCounter counts the number of times each control switches.
+type SwitchCounter struct {
+	// Number of switches per control
+	Switches []int
+	// Total number of switches
+	Total int
+}
+
+// Observe counts the switches of the event
+func (sc *SwitchCounter) Observe(event SimulationEvent) {
+	if len(sc.Switches) < len(event.Observed) {
+		sc.Switches = append(sc.Switches, make([]int, len(event.Observed)-len(sc.Switches))...)
+	}
+	for _, control := range event.Switched {
+		sc.Switches[control]++
+		sc.Total++
+	}
+}
+
+// ProgressObserver reports the progress of a simulation in steps of ten
+// percent.
+type ProgressObserver struct {
+	writer      io.Writer
+	length      int
+	nextPercent int
+}
+
+// NewProgressObserver returns an observer reporting the progress of a
+// simulation of length samples to w. The length must be positive.
+func NewProgressObserver(w io.Writer, length int) (*ProgressObserver, error) {
+	if length < 1 {
+		return nil, errors.New("Not a valid length")
+	}
+	return &ProgressObserver{
+		writer:      w,
+		length:      length,
+		nextPercent: 10,
+	}, nil
+}
+
+// Observe reports the progress when passing a multiple of ten percent
+func (po *ProgressObserver) Observe(event SimulationEvent) {
+	percent := 100 * (event.Index + 1) / po.length
+	if percent < po.nextPercent {
+		return
+	}
+	fmt.Fprintf(po.writer, "Simulated %d%% (%d of %d samples)\n", percent, event.Index+1, po.length)
+	po.nextPercent = (percent/10 + 1) * 10
+}
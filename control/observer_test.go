@@ -0,0 +1,66 @@
+package control
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/hammal/adc/ssm"
+)
+
+func TestObservers(t *testing.T) {
+	// A first-order system
+	order := 1
+	length := 100
+	t0 := 0.5
+
+	stateSpaceModel, controls := integratorChain(order, func(arg1 float64) float64 { return 0.3 })
+
+	ctrl := NewAnalogSwitchControl(length, controls, chainTs, t0, nil, stateSpaceModel)
+
+	var progress bytes.Buffer
+	energyPhase := NewEnergyPhaseObserver(0, 1, nil)
+	switchCounter := &SwitchCounter{}
+	var times []float64
+	progressObserver, err := NewProgressObserver(&progress, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl.AddObserver(energyPhase)
+	ctrl.AddObserver(switchCounter)
+	ctrl.AddObserver(progressObserver)
+	ctrl.AddObserver(ObserverFunc(func(event SimulationEvent) {
+		times = append(times, event.Time)
+	}))
+	ctrl.UseExactDiscretization(ssm.ZeroOrderHold)
+	ctrl.Simulate()
+
+	if len(energyPhase.Energy) != length || len(times) != length {
+		t.Fatal("Observers were not notified at every sample")
+	}
+	if !math.IsNaN(energyPhase.Phase[0]) {
+		t.Error("Phase of a first-order system should be NaN")
+	}
+	if math.Abs(times[length-1]-(t0+float64(length-1)*chainTs)) > 1e-12 {
+		t.Errorf("Wrong time %v of last sample", times[length-1])
+	}
+
+	switches := 0
+	decisions := ctrl.GetControlDecisions()
+	for index := 1; index < length; index++ {
+		if decisions[index] != decisions[index-1] {
+			switches++
+		}
+	}
+	if switchCounter.Total != switches || switchCounter.Switches[0] != switches {
+		t.Errorf("Counted %v switches but there are %v", switchCounter.Total, switches)
+	}
+
+	if strings.Count(progress.String(), "\n") != 10 || !strings.Contains(progress.String(), "100%") {
+		t.Errorf("Unexpected progress report\n%v", progress.String())
+	}
+	if _, err := NewProgressObserver(&progress, 0); err == nil {
+		t.Error("Expected an error for a zero length")
+	}
+}
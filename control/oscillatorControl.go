@@ -2,10 +2,9 @@ package control
 
 import (
 	"errors"
-	"fmt"
-	"math"
 
 	"github.com/hammal/adc/gonumExtensions"
+	"github.com/hammal/adc/logging"
 	"github.com/hammal/adc/ode"
 	"github.com/hammal/adc/signal"
 	"github.com/hammal/adc/ssm"
@@ -40,6 +39,12 @@ type OscillatingControl struct {
 	// FilterContributions
 	controlFilterLookUpForward  oscillatorSwitch
 	controlFilterLookUpBackward oscillatorSwitch
+	// comparators, one per control, nil for ideal comparators
+	comparators []Comparator
+	// observers notified at every sample of the simulation
+	observers observers
+	// noise injected into the simulation, nil for a noiseless simulation
+	noise *Noise
 }
 
 // Simulate the simulation tool for integratorControl
@@ -49,7 +54,7 @@ func (c *OscillatingControl) Simulate() [][]float64 {
 		tmpSimRes mat.Matrix
 	)
 
-	fmt.Println("Starting simulation...")
+	logging.Info(logger, "Starting simulation", logging.F("samples", c.GetLength()), logging.F("controls", c.NumberOfControls))
 
 	res := make([][]float64, c.GetLength())
 
@@ -62,6 +67,8 @@ func (c *OscillatingControl) Simulate() [][]float64 {
 	t0 := c.T0
 	t1 := t0 + c.Ts
 	var err error
+	// The noise was validated and copied by SetNoise
+	noise, _ := newNoiseSource(c.noise, c.StateSpaceModel, c.Ts, c.GetLength())
 	// rk := ode.NewRK4()
 	rk := ode.NewFehlberg45()
 	for index := 0; index < c.GetLength(); index++ {
@@ -89,13 +96,16 @@ func (c *OscillatingControl) Simulate() [][]float64 {
 				tmpState.Set(row, column, tmpSimRes.At(row, column))
 			}
 		}
+		if noise != nil {
+			tmpState.Add(&tmpState, noise.step())
+		}
 
 		t0 += c.Ts
 		t1 += c.Ts
 
 		res[index] = make([]float64, c.StateSpaceModel.StateSpaceOrder())
 		for row := 0; row < c.StateSpaceModel.StateSpaceOrder(); row++ {
-			res[index][row] = tmpSimRes.At(row, 0)
+			res[index][row] = tmpState.At(row, 0)
 		}
 	}
 
@@ -107,48 +117,26 @@ func (c *OscillatingControl) Simulate() [][]float64 {
 // state, held in the reviver type.
 func (c *OscillatingControl) updateControl(state mat.Vector, index int) {
 	// Set control bits
-	var tmp bool
 	var tmpFloat float64
 	I := gonumExtensions.Eye(c.StateSpaceModel.Order(), c.StateSpaceModel.Order(), 0)
 	bits := make([]uint, c.NumberOfControls)
+	previous := previousBits(c.bits, index, c.NumberOfControls)
+
+	values := make([]float64, c.NumberOfControls)
 
 	// fmt.Println()
 	for i := 0; i < c.NumberOfControls; i++ {
 		tmpFloat = mat.Inner(c.controls[i].C, I, state)
-		tmp = tmpFloat > 0.
-		// fmt.Printf("%v, %v ", tmpFloat, tmp)
-		if tmp {
-			bits[i] = 1
-		} else {
-			bits[i] = 0
-		}
+		values[i] = tmpFloat
+		bits[i] = decide(c.comparators, i, tmpFloat, previous[i])
 	}
 	// fmt.Print("\n")
 	// fmt.Println(bits[0], bits[1])
 	c.bits[index] = bitToIndex(bits)
 
-	// These are all for monitoring controllability.
-
-	a3 := mat.Inner(c.controls[0].C, I, state)
-	a4 := mat.Inner(c.controls[1].C, I, state)
-	e1 := math.Pow(state.AtVec(0), 2)
-	e2 := math.Pow(state.AtVec(1), 2)
-	phase := math.Atan2(state.AtVec(1), state.AtVec(0)) / math.Pi * 180
-	phaseError := math.Atan2(a3, a4) / math.Pi * 180.
-
-	fmt.Printf("\nEnergy: Total = %5.e, PerState = (%5.e, %5.e,) [V^2], Amplitude of Buffers = (%+2.3e, %+2.3e) [V] and Phase / PhaseError = %+4.f / %+4.f [deg]", e1+e2, e1, e2, a3, a4, phase, phaseError)
-
-	if index > 0 {
-		if c.bits[index] != c.bits[index-1] {
-			fmt.Print("\tControl Switch!\t")
-			bits1 := indexToBits(c.bits[index-1], len(bits))
-			for index2 := range bits {
-				if bits1[index2] != bits[index2] {
-					fmt.Printf("Nr %d,\t", index2)
-				}
-			}
-		}
-	}
+	// The observed values are the amplitudes of the buffers which, together
+	// with the state, are used for monitoring controllability.
+	c.observers.notify(index, c.T0+float64(index)*c.Ts, state, values, c.bits, c.NumberOfControls)
 }
 
 // getControlSimulationContribution returns the control decision vector
@@ -156,7 +144,7 @@ func (c *OscillatingControl) updateControl(state mat.Vector, index int) {
 func (c *OscillatingControl) getControlSimulationContribution(index int) ([]signal.VectorFunction, error) {
 	// Check that index exists
 	if index < 0 || index > c.GetLength()-1 {
-		return nil, errors.New("Index out of range")
+		return nil, ErrIndexOutOfRange
 	}
 
 	ctrlBits := indexToBits(c.bits[index], len(c.controls))
@@ -183,7 +171,11 @@ func (c *OscillatingControl) getControlSimulationContribution(index int) ([]sign
 func (c OscillatingControl) GetForwardControlFilterContribution(index int) (mat.Vector, error) {
 	// Check that index exists
 	if index < 0 || index > c.GetLength()-1 {
-		return nil, errors.New("index out of range")
+		return nil, ErrIndexOutOfRange
+	}
+	// Check that there are precomputed filter decisions
+	if c.controlFilterLookUpForward.systemDynamics == nil {
+		return nil, ErrNotPrecomputed
 	}
 
 	// tmp := c.controlFilterLookUpForward.GetVector(c.bits[index])
@@ -196,14 +188,50 @@ func (c OscillatingControl) GetForwardControlFilterContribution(index int) (mat.
 func (c OscillatingControl) GetBackwardControlFilterContribution(index int) (mat.Vector, error) {
 	// Check that index exists
 	if index < 0 || index > c.GetLength()-1 {
-		return nil, errors.New("index out of range")
+		return nil, ErrIndexOutOfRange
+	}
+	// Check that there are precomputed filter decisions
+	if c.controlFilterLookUpBackward.systemDynamics == nil {
+		return nil, ErrNotPrecomputed
 	}
 
 	t0 := c.Ts*(float64(index)) + c.T0
-	tmp := c.controlFilterLookUpForward.GetVector(c.bits[index], t0, t0+c.Ts)
+	tmp := c.controlFilterLookUpBackward.GetVector(c.bits[index], t0, t0+c.Ts)
 	return tmp, nil
 }
 
+// AddObserver adds an observer which is notified at every sample of the
+// simulation.
+func (c *OscillatingControl) AddObserver(observer Observer) {
+	c.observers = append(c.observers, observer)
+}
+
+// SetComparators sets the comparators, one per control, used to make the
+// control decisions. A nil slice corresponds to ideal comparators.
+func (c *OscillatingControl) SetComparators(comparators []Comparator) error {
+	if err := checkComparators(comparators, c.NumberOfControls); err != nil {
+		return err
+	}
+	c.comparators = comparators
+	return nil
+}
+
+// SetNoise sets the thermal and 1/f noise, one per state of the state space
+// model including the control states, injected into the simulation, nil for a
+// noiseless simulation. The clock jitter is not supported by the oscillating
+// controls. Returns a *ssm.DimensionError if the noise doesn't match the state
+// space order.
+func (c *OscillatingControl) SetNoise(noise *Noise) error {
+	if noise != nil && noise.Jitter != 0 {
+		return errors.New("Clock jitter isn't supported by the oscillating control")
+	}
+	if _, err := newNoiseSource(noise, c.StateSpaceModel, c.Ts, c.GetLength()); err != nil {
+		return err
+	}
+	c.noise = noise.copy()
+	return nil
+}
+
 // GetLength returns the length of control (number of time samples)
 func (c OscillatingControl) GetLength() int {
 	return len(c.bits)
@@ -212,6 +240,12 @@ func (c OscillatingControl) GetLength() int {
 // GetTs returns the sample period
 func (c OscillatingControl) GetTs() float64 { return c.Ts }
 
+// GetT0 returns the starting time
+func (c OscillatingControl) GetT0() float64 { return c.T0 }
+
+// GetStateSpaceModel returns the state space model simulated by the control
+func (c *OscillatingControl) GetStateSpaceModel() ssm.StateSpaceModel { return &c.StateSpaceModel }
+
 func (c *OscillatingControl) PreComputeFilterContributions(forwardDynamics, backwardDynamics mat.Matrix) {
 
 	oscillatorSwitchForward := oscillatorSwitch{
@@ -247,6 +281,21 @@ func (c *OscillatingControl) PreComputeFilterContributions(forwardDynamics, back
 
 // Returns an initialized analog switch control
 func NewAnalogOscillatorControl(length int, controls []signal.VectorFunction, ts, t0 float64, state mat.Vector, StateSpaceModel *ssm.LinearStateSpaceModel) *OscillatingControl {
+	biLinearModel := &ssm.BiLinearStateSpaceModel{
+		AL:    StateSpaceModel.A,
+		C:     StateSpaceModel.C,
+		Input: StateSpaceModel.Input,
+	}
+	if order := StateSpaceModel.StateSpaceOrder(); len(StateSpaceModel.Input) > 0 {
+		biLinearModel.AB = mat.NewDense(order, order*len(StateSpaceModel.Input), nil)
+	}
+	return NewBiLinearOscillatorControl(length, controls, ts, t0, state, biLinearModel)
+}
+
+// NewBiLinearOscillatorControl returns an initialized oscillator control of a
+// bilinear state space model. The bilinear state dynamics of the model are kept
+// and extended with those of the oscillating controls.
+func NewBiLinearOscillatorControl(length int, controls []signal.VectorFunction, ts, t0 float64, state mat.Vector, StateSpaceModel *ssm.BiLinearStateSpaceModel) *OscillatingControl {
 	if state != nil {
 		panic("This is not implemented. Pass a nil value instead")
 	}
@@ -273,7 +322,7 @@ func NewAnalogOscillatorControl(length int, controls []signal.VectorFunction, ts
 
 	// New state space models
 	var tmp1, tmp2, ALnew mat.Dense
-	tmp1.Augment(StateSpaceModel.A, mat.NewDense(order, numberOfControls, nil))
+	tmp1.Augment(StateSpaceModel.AL, mat.NewDense(order, numberOfControls, nil))
 	// ALnew.Stack(&tmp1, mat.NewDense(numberOfControls, numberOfControls+order, nil))
 
 	data := make([]float64, numberOfControls)
@@ -287,6 +336,15 @@ func NewAnalogOscillatorControl(length int, controls []signal.VectorFunction, ts
 
 	ABnew := mat.NewDense(order+numberOfControls, (order+numberOfControls)*(numberOfControls+len(StateSpaceModel.Input)), nil)
 
+	// Keep the bilinear state dynamics of the inputs
+	for inputIndex := range StateSpaceModel.Input {
+		for row := 0; row < order; row++ {
+			for column := 0; column < order; column++ {
+				ABnew.Set(row, (order+numberOfControls)*inputIndex+column, StateSpaceModel.AB.At(row, order*inputIndex+column))
+			}
+		}
+	}
+
 	for controlIndex := range controls {
 		for candidateState := 0; candidateState < order+numberOfControls; candidateState++ {
 			// Find all states where the control is added
@@ -298,7 +356,7 @@ func NewAnalogOscillatorControl(length int, controls []signal.VectorFunction, ts
 		}
 	}
 
-	fmt.Printf("New oscillator with additional states AL, AB \n%v\n%v\n", mat.Formatted(&ALnew), mat.Formatted(ABnew))
+	logging.Debug(logger, "New oscillator with additional states", logging.F("AL", &ALnew), logging.F("AB", ABnew))
 
 	// Adjust inputs
 	tmpInput := make([]signal.VectorFunction, len(StateSpaceModel.Input))
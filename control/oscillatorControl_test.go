@@ -6,6 +6,7 @@ import (
 
 	"github.com/hammal/adc/samplingnetwork"
 	"github.com/hammal/adc/signal"
+	"gonum.org/v1/gonum/mat"
 )
 
 func TestOscillatorStability(t *testing.T) {
@@ -39,3 +40,33 @@ func TestOscillatorStability(t *testing.T) {
 	// 	fmt.Printf("%v\n", mat.Formatted(vec))
 	// }
 }
+
+func TestOscillatingControlNotPrecomputed(t *testing.T) {
+	gain := 1e4
+	resonanceFrequency := 2e5
+	oscillator := samplingnetwork.OscillatorBlock(gain, resonanceFrequency)
+	input := []func(float64) float64{
+		func(arg float64) float64 { return 0. },
+		func(arg float64) float64 { return 0. },
+	}
+	ctrl := make([]signal.VectorFunction, len(oscillator.Control))
+	for index := range oscillator.Control {
+		ctrl[index] = oscillator.Control[index].GetResponse()
+	}
+	model := samplingnetwork.LinearSystemToLinearStateSpaceModel(oscillator.System, input)
+	oscillatorCtrl := NewAnalogOscillatorControl(10, ctrl, 1e-3, 0., nil, model)
+	order := oscillatorCtrl.StateSpaceModel.StateSpaceOrder()
+
+	// Only the forward lookup is precomputed
+	oscillatorCtrl.controlFilterLookUpForward = oscillatorSwitch{
+		systemDynamics: mat.NewDense(order, order, nil),
+		controls:       oscillatorSwitchToAnalogSwitch(oscillatorCtrl.controls),
+		Ts:             oscillatorCtrl.Ts,
+	}
+	if _, err := oscillatorCtrl.GetForwardControlFilterContribution(0); err != nil {
+		t.Errorf("Forward contribution failed with %v", err)
+	}
+	if _, err := oscillatorCtrl.GetBackwardControlFilterContribution(0); err != ErrNotPrecomputed {
+		t.Errorf("Expected ErrNotPrecomputed but got %v", err)
+	}
+}
@@ -1,10 +1,10 @@
 package control
 
 import (
-	"errors"
-	"fmt"
+	"math/bits"
 
 	"github.com/hammal/adc/gonumExtensions"
+	"github.com/hammal/adc/logging"
 	"github.com/hammal/adc/ode"
 	"github.com/hammal/adc/signal"
 	"github.com/hammal/adc/ssm"
@@ -29,13 +29,21 @@ type SwitchedCapacitorControl struct {
 	bits []uint
 	// Initial state
 	state mat.Vector
-	// State space model
+	// State space model, for bilinear models the linear part
 	StateSpaceModel *ssm.LinearStateSpaceModel
+	// bilinear state space model simulated instead of StateSpaceModel
+	biLinearModel *ssm.BiLinearStateSpaceModel
 	// precomputed control decision vectors for simulation
 	controlSimulateLookUp ControlVector
 	// precomputed control decision vectors for filtering
 	controlFilterLookUpForward  ControlVector
 	controlFilterLookUpBackward ControlVector
+	// comparators, one per control, nil for ideal comparators
+	comparators []Comparator
+	// observers notified at every sample of the simulation
+	observers observers
+	// noise injected into the simulation, nil for a noiseless simulation
+	noise *Noise
 }
 
 // Simulate the simulation tool for integratorControl
@@ -46,13 +54,13 @@ func (c *SwitchedCapacitorControl) Simulate() [][]float64 {
 		tmpSimRes mat.Matrix
 	)
 
-	fmt.Println("Starting simulation...")
+	logging.Info(logger, "Starting simulation", logging.F("samples", c.GetLength()), logging.F("controls", c.NumberOfControls))
 
 	res := make([][]float64, c.GetLength())
 
-	fmt.Println(c.StateSpaceModel.StateSpaceOrder())
-	fmt.Println(mat.Formatted(c.StateSpaceModel.A))
-	tmpState = *mat.NewDense(c.StateSpaceModel.StateSpaceOrder(), 1, nil)
+	logging.Debug(logger, "State space model", logging.F("order", c.StateSpaceModel.StateSpaceOrder()), logging.F("A", c.StateSpaceModel.A))
+	order := c.StateSpaceModel.StateSpaceOrder()
+	tmpState = *mat.NewDense(order, 1, nil)
 
 	for row := 0; row < c.StateSpaceModel.StateSpaceOrder(); row++ {
 		tmpState.Set(row, 0, c.state.AtVec(row))
@@ -60,7 +68,10 @@ func (c *SwitchedCapacitorControl) Simulate() [][]float64 {
 
 	t0 := c.T0
 	t1 := t0 + c.Ts
+	// The noise was validated and copied by SetNoise
+	noise, _ := newNoiseSource(c.noise, c.simulatedModel(), c.Ts, c.GetLength())
 	rk := ode.NewRK4()
+	adaptive := ode.NewFehlberg45()
 	for index := 0; index < c.GetLength(); index++ {
 		// fmt.Printf("State Before \n%v\n", mat.Formatted(tmpState))
 		// fmt.Printf("Current state = \n%v\n", mat.Formatted(tmpState))
@@ -71,9 +82,23 @@ func (c *SwitchedCapacitorControl) Simulate() [][]float64 {
 		// if the state space model was a linear model. Thus this could be realized
 		// using a pre-computed Ad=e^(A Ts) and then using the Runge-Kutta method
 		// with zero initial state.
-		tmpSimRes, _ = rk.Compute(t0, t1, &tmpState, c.StateSpaceModel)
-		// Get the control contributions
-		tmpCtrl, _ = c.getControlSimulationContribution(index)
+		if c.biLinearModel != nil {
+			// The state dynamics of bilinear models depend on the input which
+			// is why the capacitors are integrated together with the model.
+			system := switchedCapacitorSystem{
+				StateSpaceModel: c.biLinearModel,
+				connection:      capacitorConnection(c.controls, order),
+			}
+			augmented := capacitorCharges(c.controls, order, c.bits[index])
+			augmented.Slice(0, order, 0, 1).(*mat.Dense).Copy(&tmpState)
+			tmpAugmented, _ := adaptive.AdaptiveCompute(t0, t1, 1e-8, augmented, system)
+			tmpSimRes = tmpAugmented.(*mat.Dense).Slice(0, order, 0, 1)
+			tmpCtrl = mat.NewVecDense(order, nil)
+		} else {
+			tmpSimRes, _ = rk.Compute(t0, t1, &tmpState, c.StateSpaceModel)
+			// Get the control contributions
+			tmpCtrl, _ = c.getControlSimulationContribution(index)
+		}
 		// Add the control contributions
 		// fmt.Printf("Simulation Contribution \n%v\n", mat.Formatted(tmpSimRes))
 
@@ -81,8 +106,11 @@ func (c *SwitchedCapacitorControl) Simulate() [][]float64 {
 		tmpState.Add(tmpCtrl, tmpSimRes)
 		// fmt.Printf("Control Contribution\n%v\n", mat.Formatted(tmpCtrl))
 		// tmpState.Add(tmpState, tmpVec)
+		if noise != nil {
+			tmpState.Add(&tmpState, noise.step())
+		}
 
-		fmt.Printf("State After \n%v\n", mat.Formatted(&tmpState))
+		// fmt.Printf("State After \n%v\n", mat.Formatted(&tmpState))
 
 		// Move increment to new time step
 		t0 += c.Ts
@@ -101,19 +129,16 @@ func (c *SwitchedCapacitorControl) Simulate() [][]float64 {
 // state, held in the reviver type.
 func (c *SwitchedCapacitorControl) updateControl(state mat.Vector, index int) {
 	// Set control bits
-	var tmp bool
 	bits := make([]uint, c.NumberOfControls)
+	previous := previousBits(c.bits, index, c.NumberOfControls)
 	// fmt.Printf("Decisions for \n%v\n => ", mat.Formatted(state))
+	values := make([]float64, c.NumberOfControls)
 	for i := 0; i < c.NumberOfControls; i++ {
-		tmp = state.AtVec(i) > 0
-		// fmt.Printf("%v, ", tmp)
-		if tmp {
-			bits[i] = 1
-		} else {
-			bits[i] = 0
-		}
+		values[i] = state.AtVec(i)
+		bits[i] = decide(c.comparators, i, values[i], previous[i])
 	}
 	c.bits[index] = bitToIndex(bits)
+	c.observers.notify(index, c.T0+float64(index)*c.Ts, state, values, c.bits, c.NumberOfControls)
 }
 
 // GetControlSimulationContribution returns the control decision vector
@@ -121,11 +146,11 @@ func (c *SwitchedCapacitorControl) updateControl(state mat.Vector, index int) {
 func (c *SwitchedCapacitorControl) getControlSimulationContribution(index int) (mat.Vector, error) {
 	// Check that index exists
 	if index < 0 || index > c.GetLength()-1 {
-		return nil, errors.New("Index out of range")
+		return nil, ErrIndexOutOfRange
 	}
 
 	if c.controlSimulateLookUp == nil {
-		return nil, errors.New("No pre-computed filter decisions.")
+		return nil, ErrNotPrecomputed
 	}
 
 	// fmt.Printf("Number of controls = %v", c.NumberOfControls)
@@ -146,11 +171,11 @@ func (c *SwitchedCapacitorControl) getControlSimulationContribution(index int) (
 func (c SwitchedCapacitorControl) GetForwardControlFilterContribution(index int) (mat.Vector, error) {
 	// Check that index exists
 	if index < 0 || index > c.GetLength()-1 {
-		return nil, errors.New("index out of range")
+		return nil, ErrIndexOutOfRange
 	}
 	// Check that there are precomputed filter decisions
 	if c.controlFilterLookUpForward == nil {
-		return nil, errors.New("No pre-computed filter decisions.")
+		return nil, ErrNotPrecomputed
 	}
 
 	tmp := c.controlFilterLookUpForward.GetVector(c.bits[index])
@@ -161,17 +186,87 @@ func (c SwitchedCapacitorControl) GetForwardControlFilterContribution(index int)
 func (c SwitchedCapacitorControl) GetBackwardControlFilterContribution(index int) (mat.Vector, error) {
 	// Check that index exists
 	if index < 0 || index > c.GetLength()-1 {
-		return nil, errors.New("index out of range")
+		return nil, ErrIndexOutOfRange
 	}
 	// Check that there are precomputed filter decisions
 	if c.controlFilterLookUpBackward == nil {
-		return nil, errors.New("No pre-computed filter decisions.")
+		return nil, ErrNotPrecomputed
 	}
 
 	tmp := c.controlFilterLookUpBackward.GetVector(c.bits[index])
 	return tmp, nil
 }
 
+// GetControlDecisions returns the control decisions as one code word per
+// time sample. Bit i of a code word corresponds to control i.
+func (c SwitchedCapacitorControl) GetControlDecisions() []uint {
+	return c.bits
+}
+
+// GetNumberOfControls returns the number of controls
+func (c SwitchedCapacitorControl) GetNumberOfControls() int { return c.NumberOfControls }
+
+// GetForwardCodeWordContribution returns the forward filter contribution of
+// the control decision code word, see StreamingControl.
+func (c SwitchedCapacitorControl) GetForwardCodeWordContribution(codeWord uint) (mat.Vector, error) {
+	if bits.Len(codeWord) > c.NumberOfControls {
+		return nil, ErrIndexOutOfRange
+	}
+	if c.controlFilterLookUpForward == nil {
+		return nil, ErrNotPrecomputed
+	}
+	return c.controlFilterLookUpForward.GetVector(codeWord), nil
+}
+
+// GetBackwardCodeWordContribution returns the backward filter contribution of
+// the control decision code word, see StreamingControl.
+func (c SwitchedCapacitorControl) GetBackwardCodeWordContribution(codeWord uint) (mat.Vector, error) {
+	if bits.Len(codeWord) > c.NumberOfControls {
+		return nil, ErrIndexOutOfRange
+	}
+	if c.controlFilterLookUpBackward == nil {
+		return nil, ErrNotPrecomputed
+	}
+	return c.controlFilterLookUpBackward.GetVector(codeWord), nil
+}
+
+// AddObserver adds an observer which is notified at every sample of the
+// simulation.
+func (c *SwitchedCapacitorControl) AddObserver(observer Observer) {
+	c.observers = append(c.observers, observer)
+}
+
+// SetComparators sets the comparators, one per control, used to make the
+// control decisions. A nil slice corresponds to ideal comparators.
+func (c *SwitchedCapacitorControl) SetComparators(comparators []Comparator) error {
+	if err := checkComparators(comparators, c.NumberOfControls); err != nil {
+		return err
+	}
+	c.comparators = comparators
+	return nil
+}
+
+// SetNoise sets the thermal and 1/f noise injected into the simulation, nil for
+// a noiseless simulation. The charge of the switched capacitors doesn't depend
+// on the timing of the switches and therefore the clock jitter has no effect.
+// Returns a *ssm.DimensionError if the noise doesn't match the state space
+// order.
+func (c *SwitchedCapacitorControl) SetNoise(noise *Noise) error {
+	if _, err := newNoiseSource(noise, c.simulatedModel(), c.Ts, c.GetLength()); err != nil {
+		return err
+	}
+	c.noise = noise.copy()
+	return nil
+}
+
+// simulatedModel returns the state space model integrated by Simulate.
+func (c SwitchedCapacitorControl) simulatedModel() ssm.StateSpaceModel {
+	if c.biLinearModel != nil {
+		return c.biLinearModel
+	}
+	return c.StateSpaceModel
+}
+
 // GetLength returns the length of control (number of time samples)
 func (c SwitchedCapacitorControl) GetLength() int {
 	return len(c.bits)
@@ -180,6 +275,12 @@ func (c SwitchedCapacitorControl) GetLength() int {
 // GetTs returns the sample period
 func (c SwitchedCapacitorControl) GetTs() float64 { return c.Ts }
 
+// GetT0 returns the starting time
+func (c SwitchedCapacitorControl) GetT0() float64 { return c.T0 }
+
+// GetStateSpaceModel returns the state space model simulated by the control
+func (c SwitchedCapacitorControl) GetStateSpaceModel() ssm.StateSpaceModel { return c.simulatedModel() }
+
 func (c *SwitchedCapacitorControl) PreComputeFilterContributions(forwardDynamics, backwardDynamics mat.Matrix) {
 	numberOfControlScenarios := (1 << uint(c.NumberOfControls))
 
@@ -286,6 +387,21 @@ func NewSwitchedCapacitorControl(length int, controls []SwitchedCapacitor, ts, t
 
 }
 
+// NewBiLinearSwitchedCapacitorControl returns an initialized switched
+// capacitor control of a bilinear state space model. The capacitors are
+// simulated together with the model whereas the filter contributions are
+// computed from the linear part AL. Panics if the linear part of the model is
+// inconsistent.
+func NewBiLinearSwitchedCapacitorControl(length int, controls []SwitchedCapacitor, ts, t0 float64, state mat.Vector, StateSpaceModel *ssm.BiLinearStateSpaceModel) *SwitchedCapacitorControl {
+	linearPart, err := StateSpaceModel.Linearize(make([]float64, StateSpaceModel.InputSpaceOrder()))
+	if err != nil {
+		panic(err)
+	}
+	c := NewSwitchedCapacitorControl(length, controls, ts, t0, state, linearPart)
+	c.biLinearModel = StateSpaceModel
+	return c
+}
+
 // SwitchedCapacitor describes the SC circuit
 // associated with each control. The R and C value
 // Sets the decay rate i.e.
@@ -305,8 +421,6 @@ type capacativeSwitch struct {
 
 func (as capacativeSwitch) GetVector(controlCode uint) mat.Vector {
 
-	ctrlBits := indexToBits(controlCode, len(as.controls))
-
 	order, _ := as.systemDynamics.Dims()
 	numberOfControls := len(as.controls)
 
@@ -314,14 +428,10 @@ func (as capacativeSwitch) GetVector(controlCode uint) mat.Vector {
 
 	// Construct additional states for the SC memory
 	scValues := make([]float64, numberOfControls)
-	scControlConnection := mat.NewDense(order, numberOfControls, nil)
-
 	for index := range scValues {
 		scValues[index] = -1. / (C * R)
-		for row := 0; row < order; row++ {
-			scControlConnection.Set(row, index, as.controls[index].B.AtVec(row))
-		}
 	}
+	scControlConnection := capacitorConnection(as.controls, order)
 	scStates := mat.NewDiagonal(numberOfControls, scValues)
 
 	var Anew, tmpMat1, tmpMat2 mat.Dense
@@ -340,11 +450,7 @@ func (as capacativeSwitch) GetVector(controlCode uint) mat.Vector {
 
 	// Construct default controls
 	for index := range as.controls {
-		tmpVec := mat.NewVecDense(order+numberOfControls, nil)
-		for row := 0; row < numberOfControls; row++ {
-			tmpVec.SetVec(row+order, as.controls[index].B.AtVec(row))
-		}
-		ctrl[index] = signal.NewInput(func(arg1 float64) float64 { return 0. }, tmpVec)
+		ctrl[index] = signal.NewInput(func(arg1 float64) float64 { return 0. }, capacitorVector(as.controls[index], order, numberOfControls))
 	}
 
 	// Adjust inputs
@@ -361,19 +467,7 @@ func (as capacativeSwitch) GetVector(controlCode uint) mat.Vector {
 
 	// NewSSM := ssm.NewLinearStateSpaceModel(&Anew, Cnew, inputs)
 
-	controlState := mat.NewDense(order+numberOfControls, 1, nil)
-
-	for controlIndex, controlFunction := range ctrl {
-		ctrlDecision := (2.*float64(ctrlBits[controlIndex]) - 1.)
-
-		// This is a ugly solution since I can't redefine controlFunction.U
-		// Because if so they overlap in memory instead I ended up scaling the whole
-		// B vector with the control decision.
-		var tmpVec mat.VecDense
-		tmpVec.ScaleVec(ctrlDecision, unityVector(controlFunction.B))
-
-		controlState.Add(controlState, &tmpVec)
-	}
+	controlState := capacitorCharges(as.controls, order, controlCode)
 	dummyInput := make([]signal.VectorFunction, 1)
 	dummyInput[0] = signal.VectorFunction{
 		B: ctrl[0].B,
@@ -392,6 +486,79 @@ func (as capacativeSwitch) GetVector(controlCode uint) mat.Vector {
 	return res.SliceVec(0, order)
 }
 
+// capacitorConnection returns the matrix connecting the capacitors of the
+// controls to the state, one column per control.
+func capacitorConnection(controls []signal.VectorFunction, order int) *mat.Dense {
+	res := mat.NewDense(order, len(controls), nil)
+	for index := range controls {
+		for row := 0; row < order; row++ {
+			res.Set(row, index, controls[index].B.AtVec(row))
+		}
+	}
+	return res
+}
+
+// capacitorVector returns the control vector in the state space augmented with
+// the capacitor states.
+func capacitorVector(control signal.VectorFunction, order, numberOfControls int) *mat.VecDense {
+	res := mat.NewVecDense(order+numberOfControls, nil)
+	for row := 0; row < numberOfControls; row++ {
+		res.SetVec(row+order, control.B.AtVec(row))
+	}
+	return res
+}
+
+// capacitorCharges returns the augmented state with the capacitors charged
+// according to the code word at the start of a sample as a column.
+func capacitorCharges(controls []signal.VectorFunction, order int, codeWord uint) *mat.Dense {
+	res := mat.NewDense(order+len(controls), 1, nil)
+	for controlIndex, ctrlBit := range indexToBits(codeWord, len(controls)) {
+		ctrlDecision := (2.*float64(ctrlBit) - 1.)
+		var tmpVec mat.VecDense
+		tmpVec.ScaleVec(ctrlDecision, unityVector(capacitorVector(controls[controlIndex], order, len(controls))))
+		res.Add(res, &tmpVec)
+	}
+	return res
+}
+
+// switchedCapacitorSystem is a state space model augmented with the
+// capacitors of the controls which discharge into the state, i.e.,
+//
+//	x'(t) = f(t, x(t)) + Gamma v(t),  v'(t) = -v(t) / (R C)
+type switchedCapacitorSystem struct {
+	ssm.StateSpaceModel
+	// Gamma, one column per capacitor
+	connection *mat.Dense
+}
+
+func (sys switchedCapacitorSystem) Derivative(t float64, state mat.Vector) mat.Vector {
+	order, numberOfControls := sys.connection.Dims()
+	x := mat.NewVecDense(order, nil)
+	v := mat.NewVecDense(numberOfControls, nil)
+	for index := 0; index < order; index++ {
+		x.SetVec(index, state.AtVec(index))
+	}
+	for index := 0; index < numberOfControls; index++ {
+		v.SetVec(index, state.AtVec(order+index))
+	}
+	var derivative mat.VecDense
+	derivative.MulVec(sys.connection, v)
+	derivative.AddVec(&derivative, sys.StateSpaceModel.Derivative(t, x))
+	res := mat.NewVecDense(order+numberOfControls, nil)
+	for index := 0; index < order; index++ {
+		res.SetVec(index, derivative.AtVec(index))
+	}
+	for index := 0; index < numberOfControls; index++ {
+		res.SetVec(order+index, -v.AtVec(index)/(C*R))
+	}
+	return res
+}
+
+func (sys switchedCapacitorSystem) Order() int {
+	order, numberOfControls := sys.connection.Dims()
+	return order + numberOfControls
+}
+
 func unityVector(vector mat.Vector) mat.Vector {
 	var tmpVec mat.VecDense
 	tmpVec.MulElemVec(vector, vector)
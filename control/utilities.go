@@ -1,7 +1,10 @@
 package control
 
 import (
+	"math"
+
 	"github.com/hammal/adc/ode"
+	"github.com/hammal/adc/ssm"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -29,11 +32,55 @@ func indexToVec(index uint, length int) mat.Vector {
 	bits := indexToBits(index, length)
 	res := make([]float64, length)
 	for bit := range bits {
-		res[bit] = float64(bits[bit]*2 - 1)
+		res[bit] = float64(bits[bit])*2 - 1
 	}
 	return mat.NewVecDense(length, res)
 }
 
+// ObservationFromControls returns an observation matrix where each control
+// observes the direction it acts on, i.e., row i is -controls[i] normalised by
+// its largest absolute entry. For controls acting on a single state this
+// results in control i observing that state.
+func ObservationFromControls(controls []mat.Vector) mat.Matrix {
+	if len(controls) == 0 {
+		return nil
+	}
+	order := controls[0].Len()
+	res := mat.NewDense(len(controls), order, nil)
+	for row, control := range controls {
+		norm := mat.Norm(control, math.Inf(1))
+		if norm == 0 {
+			continue
+		}
+		for column := 0; column < order; column++ {
+			res.Set(row, column, -control.AtVec(column)/norm)
+		}
+	}
+	return res
+}
+
+// controlledSystem adds a constant control contribution to the state
+// derivative of a state space model.
+type controlledSystem struct {
+	ssm.StateSpaceModel
+	control mat.Vector
+}
+
+// controlledModel is a model where the control contribution is part of the
+// state derivative, e.g., subject to non-idealities.
+type controlledModel interface {
+	ControlledDerivative(t float64, state, control mat.Vector) mat.Vector
+}
+
+func (sys controlledSystem) Derivative(t float64, state mat.Vector) mat.Vector {
+	if model, ok := sys.StateSpaceModel.(controlledModel); ok {
+		return model.ControlledDerivative(t, state, sys.control)
+	}
+	var res mat.VecDense
+	res.AddVec(sys.StateSpaceModel.Derivative(t, state), sys.control)
+	return &res
+}
+
 type lazyCache struct {
 	cache    []mat.Vector
 	computed []bool
@@ -0,0 +1,44 @@
+package adc
+
+import (
+	"github.com/hammal/adc/samplingnetwork"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// chainGain is the integrator gain of the test networks
+	chainGain = 6250.
+	// chainTs is the sampling period of the test networks
+	chainTs = 1. / 16000.
+)
+
+// integratorChain returns a series of N controlled integrators.
+func integratorChain(N int) samplingnetwork.SamplingNetwork {
+	var integrators []samplingnetwork.SamplingNetwork
+	for index := 0; index < N; index++ {
+		integrators = append(integrators, samplingnetwork.IntegratorBlock(chainGain))
+	}
+	return samplingnetwork.SeriesBlock(integrators)
+}
+
+// observationNetwork returns a network of three states where only the first
+// and last are controlled. The middle state is a leaky integrator in between
+// which swings well beyond the control bounds.
+func observationNetwork() samplingnetwork.SamplingNetwork {
+	network := samplingnetwork.SamplingNetwork{
+		System: samplingnetwork.LinearSystem{
+			A: mat.NewDense(3, 3, []float64{0, 0, 0, 10 * chainGain, -chainGain, 0, 0, chainGain / 10., 0}),
+			B: mat.NewDense(3, 1, []float64{chainGain, 0, 0}),
+			C: mat.NewDense(3, 3, []float64{1, 0, 0, 0, 1, 0, 0, 0, 1}),
+		},
+	}
+	for _, vector := range []mat.Vector{
+		mat.NewVecDense(3, []float64{-chainGain, 0, 0}),
+		mat.NewVecDense(3, []float64{0, 0, -chainGain}),
+	} {
+		analogSwitch := &samplingnetwork.AnalogSwitch{}
+		analogSwitch.SetVector(vector)
+		network.Control = append(network.Control, analogSwitch)
+	}
+	return network
+}
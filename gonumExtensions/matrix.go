@@ -39,7 +39,6 @@ func Eye(m, n, k int) mat.Matrix {
 		}
 	}
 	return tmp
-	panic("Not yet implemented")
 }
 
 // NANORIF checks if there are any NAN or INF in matrix
@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Hook is the logger of a package, silent until a logger is set. A package
+// holds its hook in a single variable, e.g.,
+//
+//	var logger = logging.Package("control")
+//
+// and logs through it like through any other Logger.
+type Hook struct {
+	mutex  sync.RWMutex
+	logger Logger
+}
+
+var (
+	hooksMutex sync.Mutex
+	hooks      = map[string]*Hook{}
+)
+
+// Package registers the hook of the package name and returns it. The same hook
+// is returned for the same name.
+func Package(name string) *Hook {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	hook, ok := hooks[name]
+	if !ok {
+		hook = &Hook{}
+		hooks[name] = hook
+	}
+	return hook
+}
+
+// SetLogger sets the logger used for diagnostics of the package name, e.g.,
+// "control", "ode" or "reconstruct". A nil logger silences the package. An
+// error is returned if no package registered the name, see Package.
+func SetLogger(name string, logger Logger) error {
+	hooksMutex.Lock()
+	hook, ok := hooks[name]
+	hooksMutex.Unlock()
+	if !ok {
+		return fmt.Errorf("No package registered the logger %q", name)
+	}
+	hook.Set(logger)
+	return nil
+}
+
+// Set sets the logger of the hook, nil silences it.
+func (h *Hook) Set(logger Logger) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.logger = logger
+}
+
+func (h *Hook) get() Logger {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if h.logger == nil {
+		return Discard
+	}
+	return h.logger
+}
+
+// Enabled reports if the logger of the hook logs messages of level
+func (h *Hook) Enabled(level Level) bool {
+	return h.get().Enabled(level)
+}
+
+// Log logs the message with the logger of the hook
+func (h *Hook) Log(level Level, message string, fields ...Field) {
+	h.get().Log(level, message, fields...)
+}
@@ -0,0 +1,188 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+const (
+	// DebugLevel is for detailed diagnostics such as intermediate matrices.
+	DebugLevel Level = iota
+	// InfoLevel is for progress information.
+	InfoLevel
+	// WarnLevel is for unexpected but recoverable situations.
+	WarnLevel
+	// ErrorLevel is for failures.
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "DEBUG"
+	case InfoLevel:
+		return "INFO"
+	case WarnLevel:
+		return "WARN"
+	case ErrorLevel:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// Field is a key value pair attached to a log message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns the field key = value
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger is the logging interface used throughout the library. Implementations
+// must be safe for concurrent use.
+type Logger interface {
+	// Enabled reports if messages of level are logged. Used to avoid
+	// computing expensive fields.
+	Enabled(level Level) bool
+	// Log logs the message with its fields.
+	Log(level Level, message string, fields ...Field)
+}
+
+// Discard is the silent logger, the default of all packages.
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Enabled(level Level) bool                         { return false }
+func (discard) Log(level Level, message string, fields ...Field) {}
+
+// writerLogger writes messages of at least level to a writer.
+type writerLogger struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	level  Level
+}
+
+// NewWriterLogger returns a logger writing all messages of at least level to w
+// as
+//
+// LEVEL message key1=value1 key2=value2
+//
+// Matrix valued fields are written formatted on the following lines.
+func NewWriterLogger(w io.Writer, level Level) Logger {
+	return &writerLogger{
+		writer: w,
+		level:  level,
+	}
+}
+
+func (wl *writerLogger) Enabled(level Level) bool {
+	return level >= wl.level
+}
+
+func (wl *writerLogger) Log(level Level, message string, fields ...Field) {
+	if !wl.Enabled(level) {
+		return
+	}
+	var line strings.Builder
+	var matrices []Field
+	fmt.Fprintf(&line, "%v %v", level, message)
+	for _, field := range fields {
+		if _, ok := field.Value.(mat.Matrix); ok {
+			matrices = append(matrices, field)
+			continue
+		}
+		fmt.Fprintf(&line, " %v=%v", field.Key, field.Value)
+	}
+	line.WriteString("\n")
+	for _, field := range matrices {
+		fmt.Fprintf(&line, "%v =\n%v\n", field.Key, mat.Formatted(field.Value.(mat.Matrix)))
+	}
+
+	wl.mutex.Lock()
+	defer wl.mutex.Unlock()
+	io.WriteString(wl.writer, line.String())
+}
+
+// Entry is a log message recorded by a Recorder.
+type Entry struct {
+	Level   Level
+	Message string
+	Fields  map[string]interface{}
+}
+
+// Recorder is a logger that keeps all messages of at least Level in memory, for
+// instance to capture matrices for debugging. Matrix valued fields are copied
+// when recorded.
+type Recorder struct {
+	Level   Level
+	mutex   sync.Mutex
+	entries []Entry
+}
+
+// Enabled reports if messages of level are recorded
+func (r *Recorder) Enabled(level Level) bool {
+	return level >= r.Level
+}
+
+// Log records the message
+func (r *Recorder) Log(level Level, message string, fields ...Field) {
+	if !r.Enabled(level) {
+		return
+	}
+	entry := Entry{
+		Level:   level,
+		Message: message,
+		Fields:  make(map[string]interface{}, len(fields)),
+	}
+	for _, field := range fields {
+		if matrix, ok := field.Value.(mat.Matrix); ok {
+			entry.Fields[field.Key] = mat.DenseCopyOf(matrix)
+			continue
+		}
+		entry.Fields[field.Key] = field.Value
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+// Entries returns the recorded messages in order
+func (r *Recorder) Entries() []Entry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	res := make([]Entry, len(r.entries))
+	copy(res, r.entries)
+	return res
+}
+
+// Debug logs message at DebugLevel
+func Debug(logger Logger, message string, fields ...Field) {
+	logger.Log(DebugLevel, message, fields...)
+}
+
+// Info logs message at InfoLevel
+func Info(logger Logger, message string, fields ...Field) {
+	logger.Log(InfoLevel, message, fields...)
+}
+
+// Warn logs message at WarnLevel
+func Warn(logger Logger, message string, fields ...Field) {
+	logger.Log(WarnLevel, message, fields...)
+}
+
+// Error logs message at ErrorLevel
+func Error(logger Logger, message string, fields ...Field) {
+	logger.Log(ErrorLevel, message, fields...)
+}
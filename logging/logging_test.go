@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestWriterLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewWriterLogger(&buffer, InfoLevel)
+
+	Debug(logger, "Hidden")
+	if buffer.Len() > 0 {
+		t.Error("Debug message logged at info level")
+	}
+	if logger.Enabled(DebugLevel) || !logger.Enabled(WarnLevel) {
+		t.Error("Wrong levels enabled")
+	}
+
+	Info(logger, "Starting simulation", F("samples", 100))
+	if buffer.String() != "INFO Starting simulation samples=100\n" {
+		t.Errorf("Unexpected message %q", buffer.String())
+	}
+
+	buffer.Reset()
+	Warn(logger, "Matrix", F("A", mat.NewDense(2, 2, []float64{1, 2, 3, 4})))
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 4 || lines[0] != "WARN Matrix" || lines[1] != "A =" {
+		t.Errorf("Unexpected matrix message\n%v", buffer.String())
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	recorder := &Recorder{Level: DebugLevel}
+	A := mat.NewDense(2, 2, []float64{1, 2, 3, 4})
+	Debug(recorder, "Matrix", F("A", A), F("norm", 1.))
+	A.Set(0, 0, 5)
+
+	entries := recorder.Entries()
+	if len(entries) != 1 || entries[0].Level != DebugLevel || entries[0].Message != "Matrix" {
+		t.Fatalf("Unexpected entries %v", entries)
+	}
+	if entries[0].Fields["A"].(mat.Matrix).At(0, 0) != 1 {
+		t.Error("Recorded matrix was not copied")
+	}
+	if entries[0].Fields["norm"].(float64) != 1. {
+		t.Error("Field not recorded")
+	}
+
+	// Discard is silent
+	if Discard.Enabled(ErrorLevel) {
+		t.Error("Discard should never be enabled")
+	}
+}
+
+func TestHook(t *testing.T) {
+	hook := Package("test")
+	if hook != Package("test") {
+		t.Error("Different hooks for the same package")
+	}
+	Info(hook, "Silent")
+	if hook.Enabled(ErrorLevel) {
+		t.Error("Hook is not silent by default")
+	}
+
+	recorder := &Recorder{Level: InfoLevel}
+	if err := SetLogger("test", recorder); err != nil {
+		t.Fatal(err)
+	}
+	Info(hook, "Recorded")
+	if err := SetLogger("test", nil); err != nil {
+		t.Fatal(err)
+	}
+	Info(hook, "Silenced")
+	if entries := recorder.Entries(); len(entries) != 1 || entries[0].Message != "Recorded" {
+		t.Errorf("Unexpected entries %v", entries)
+	}
+	if err := SetLogger("tset", recorder); err == nil {
+		t.Error("Expected an error for a package that didn't register a logger")
+	}
+}
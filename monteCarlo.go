@@ -0,0 +1,225 @@
+package adc
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/samplingnetwork"
+	"github.com/hammal/adc/signal"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Tolerances are the relative standard deviations of the component mismatch.
+// Each non-zero entry x is perturbed into x (1 + tolerance n) where n is
+// standard normal distributed.
+type Tolerances struct {
+	// Tolerance of the state transition matrix A
+	A float64
+	// Tolerance of the input matrix B
+	B float64
+	// Tolerance of the control vectors
+	Control float64
+}
+
+// MonteCarlo describes a Monte Carlo analysis of the component mismatch of a
+// sampling network. Every trial simulates a perturbed sampling network and
+// reconstructs the input with the nominal one.
+type MonteCarlo struct {
+	// Nominal sampling network
+	Network samplingnetwork.SamplingNetwork
+	// Component tolerances
+	Tolerances Tolerances
+	// Sample period and number of samples of each trial
+	Ts     float64
+	Length int
+	// Amplitude and frequency of the sinusoidal test signal which is applied
+	// to the first input, the remaining inputs are zero.
+	Amplitude, Frequency float64
+	// Bandwidth of the signal band in which SNR and SNDR are evaluated
+	Bandwidth float64
+	// Noise variances used to design the reconstruction, zero values default
+	// to one.
+	InputNoiseVariance, MeasurementNoiseVariance float64
+	// Number of trials
+	Trials int
+	// Seed of the first trial, trial i uses the seed Seed + i
+	Seed int64
+}
+
+// Statistics summarizes the outcomes of the trials.
+type Statistics struct {
+	Mean, StandardDeviation, Min, Median, Max float64
+}
+
+// MonteCarloResult holds the SNR and SNDR [dB], one per trial, and their
+// statistics.
+type MonteCarloResult struct {
+	SNR, SNDR                     []float64
+	SNRStatistics, SNDRStatistics Statistics
+}
+
+// Run runs the trials in parallel. The result only depends on the seed and
+// not on the scheduling of the trials.
+func (mc MonteCarlo) Run() (*MonteCarloResult, error) {
+	if err := mc.Network.Validate(); err != nil {
+		return nil, err
+	}
+	if mc.Trials < 1 || mc.Length < 1 || mc.Ts <= 0 {
+		return nil, errors.New("Not a valid number of trials, length and sample period")
+	}
+	if mc.Network.System.InputSpaceOrder() < 1 || len(mc.Network.Control) < 1 {
+		return nil, errors.New("The sampling network requires an input and a control")
+	}
+	if mc.Tolerances.A < 0 || mc.Tolerances.B < 0 || mc.Tolerances.Control < 0 {
+		return nil, errors.New("Tolerances must be non-negative")
+	}
+
+	res := &MonteCarloResult{
+		SNR:  make([]float64, mc.Trials),
+		SNDR: make([]float64, mc.Trials),
+	}
+	errs := make([]error, mc.Trials)
+
+	var wg sync.WaitGroup
+	workers := runtime.NumCPU()
+	if workers > mc.Trials {
+		workers = mc.Trials
+	}
+	indices := make(chan int)
+	wg.Add(workers)
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			defer wg.Done()
+			for trial := range indices {
+				res.SNR[trial], res.SNDR[trial], errs[trial] = mc.trial(rand.New(rand.NewSource(mc.Seed + int64(trial))))
+			}
+		}()
+	}
+	for trial := 0; trial < mc.Trials; trial++ {
+		indices <- trial
+	}
+	close(indices)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	res.SNRStatistics = newStatistics(res.SNR)
+	res.SNDRStatistics = newStatistics(res.SNDR)
+	return res, nil
+}
+
+// trial simulates a perturbed sampling network, reconstructs the input with
+// the nominal one and returns the SNR and SNDR of the estimate where the first
+// and last tenth of the estimate are discarded.
+func (mc MonteCarlo) trial(rng *rand.Rand) (snr, sndr float64, err error) {
+	input := make([]func(float64) float64, mc.Network.System.InputSpaceOrder())
+	for index := range input {
+		input[index] = func(float64) float64 { return 0 }
+	}
+	input[0] = func(t float64) float64 { return mc.Amplitude * math.Sin(2*math.Pi*mc.Frequency*t) }
+
+	nominalControls := make([]mat.Vector, len(mc.Network.Control))
+	controls := make([]mat.Vector, len(mc.Network.Control))
+	for index := range mc.Network.Control {
+		nominalControls[index] = mc.Network.Control[index].GetVector()
+		controls[index] = perturb(nominalControls[index], mc.Tolerances.Control, rng).ColView(0)
+	}
+	system := samplingnetwork.LinearSystem{
+		A: perturb(mc.Network.System.A, mc.Tolerances.A, rng),
+		B: perturb(mc.Network.System.B, mc.Tolerances.B, rng),
+		C: mc.Network.System.C,
+	}
+	// The comparators observe the nominal control directions
+	observation := control.ObservationFromControls(nominalControls)
+
+	model, err := samplingnetwork.LinearSystemToLinearStateSpaceModelChecked(system, input)
+	if err != nil {
+		return 0, 0, err
+	}
+	simulation := control.NewAnalogSwitchControl(mc.Length, controls, mc.Ts, 0, nil, model)
+	if err := simulation.SetObservation(observation); err != nil {
+		return 0, 0, err
+	}
+	if err := simulation.UseExactDiscretization(ssm.ZeroOrderHold); err != nil {
+		return 0, 0, err
+	}
+	simulation.Simulate()
+
+	nominalModel, err := samplingnetwork.LinearSystemToLinearStateSpaceModelChecked(mc.Network.System, input)
+	if err != nil {
+		return 0, 0, err
+	}
+	nominal := control.NewAnalogSwitchControl(mc.Length, nominalControls, mc.Ts, 0, nil, nominalModel)
+	if err := nominal.SetControlDecisions(simulation.GetControlDecisions()); err != nil {
+		return 0, 0, err
+	}
+	inputNoiseVariance, measurementNoiseVariance := mc.InputNoiseVariance, mc.MeasurementNoiseVariance
+	if inputNoiseVariance == 0 {
+		inputNoiseVariance = 1
+	}
+	if measurementNoiseVariance == 0 {
+		measurementNoiseVariance = 1
+	}
+	rec, err := newSteadyStateReconstruction(nominal, nominalModel, observation, inputNoiseVariance, measurementNoiseVariance)
+	if err != nil {
+		return 0, 0, err
+	}
+	estimate, err := rec.ReconstructionChecked()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	values := make([]float64, 0, mc.Length)
+	for index := mc.Length / 10; index < mc.Length-mc.Length/10; index++ {
+		values = append(values, estimate[index][0])
+	}
+	return signal.SNR(values, mc.Ts, mc.Frequency, mc.Bandwidth)
+}
+
+// perturb returns a copy of the matrix where each non-zero entry x is replaced
+// by x (1 + tolerance n) for a standard normal n.
+func perturb(matrix mat.Matrix, tolerance float64, rng *rand.Rand) *mat.Dense {
+	res := mat.DenseCopyOf(matrix)
+	m, n := res.Dims()
+	for row := 0; row < m; row++ {
+		for column := 0; column < n; column++ {
+			if value := res.At(row, column); value != 0 {
+				res.Set(row, column, value*(1+tolerance*rng.NormFloat64()))
+			}
+		}
+	}
+	return res
+}
+
+// newStatistics returns the statistics of the values.
+func newStatistics(values []float64) Statistics {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var mean, variance float64
+	for _, value := range sorted {
+		mean += value / float64(len(sorted))
+	}
+	for _, value := range sorted {
+		variance += (value - mean) * (value - mean) / float64(len(sorted))
+	}
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + median) / 2
+	}
+	return Statistics{
+		Mean:              mean,
+		StandardDeviation: math.Sqrt(variance),
+		Min:               sorted[0],
+		Median:            median,
+		Max:               sorted[len(sorted)-1],
+	}
+}
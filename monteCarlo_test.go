@@ -0,0 +1,89 @@
+package adc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMonteCarlo(t *testing.T) {
+	mc := MonteCarlo{
+		Network:                  integratorChain(3),
+		Ts:                       chainTs,
+		Length:                   2000,
+		Amplitude:                0.5,
+		Frequency:                100,
+		Bandwidth:                500,
+		MeasurementNoiseVariance: 1e2,
+		Trials:                   16,
+		Seed:                     1,
+	}
+
+	// Without mismatch every trial is the nominal system
+	nominal, err := mc.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nominal.SNRStatistics.StandardDeviation > 1e-9 || nominal.SNRStatistics.Min != nominal.SNRStatistics.Max {
+		t.Errorf("Trials without mismatch differ %+v", nominal.SNRStatistics)
+	}
+	if nominal.SNRStatistics.Mean < 40 || nominal.SNDRStatistics.Mean > nominal.SNRStatistics.Mean {
+		t.Errorf("Nominal SNR %v and SNDR %v dB", nominal.SNRStatistics.Mean, nominal.SNDRStatistics.Mean)
+	}
+
+	// Large mismatch degrades the performance reproducibly
+	mc.Tolerances = Tolerances{A: 0.5, B: 0.5, Control: 0.5}
+	mismatched, err := mc.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatched.SNDRStatistics.Median > nominal.SNDRStatistics.Median-6 || mismatched.SNDRStatistics.StandardDeviation == 0 {
+		t.Errorf("Mismatch doesn't degrade the SNDR %+v compared to %+v", mismatched.SNDRStatistics, nominal.SNDRStatistics)
+	}
+	repeated, err := mc.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mismatched, repeated) {
+		t.Error("Seeded trials are not reproducible")
+	}
+
+	mc.Trials = 0
+	if _, err := mc.Run(); err == nil {
+		t.Error("Expected an error without trials")
+	}
+	mc.Trials, mc.Tolerances.A = 1, -1
+	if _, err := mc.Run(); err == nil {
+		t.Error("Expected an error for a negative tolerance")
+	}
+}
+
+func TestMonteCarloObservation(t *testing.T) {
+	// Three states but only the first and last are controlled and observed
+	mc := MonteCarlo{
+		Network:                  observationNetwork(),
+		Ts:                       chainTs,
+		Length:                   4000,
+		Amplitude:                0.5,
+		Frequency:                50,
+		Bandwidth:                500,
+		MeasurementNoiseVariance: 1e-2,
+		Tolerances:               Tolerances{A: 0.01, B: 0.01, Control: 0.01},
+		Trials:                   4,
+		Seed:                     1,
+	}
+
+	res, err := mc.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SNRStatistics.Min < 40 {
+		t.Errorf("SNR %+v of the reconstruction from two controls", res.SNRStatistics)
+	}
+	repeated, err := mc.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, repeated) {
+		t.Error("Seeded trials are not reproducible")
+	}
+}
@@ -0,0 +1,7 @@
+package ode
+
+import "github.com/hammal/adc/logging"
+
+// logger is used for all diagnostics of the package, silent by default. Set it
+// with logging.SetLogger("ode", l).
+var logger = logging.Package("ode")
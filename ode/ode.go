@@ -11,6 +11,7 @@ import (
 	"runtime"
 	"sync"
 
+	"github.com/hammal/adc/logging"
 	"github.com/hammal/adc/ssm"
 	"gonum.org/v1/gonum/mat"
 )
@@ -337,6 +338,7 @@ func (rk RungeKutta) adaptiveComputeVec(from, to, err float64, value mat.Vector,
 			count++
 			if count >= maxNumberOfIterations {
 				errorString := fmt.Sprintf("Maximum number of iterations reached adaptive Runge-Kutta doesn't converge\n last error was = %v, and only %2.f percent of time was computed", currentError, tnext[0]/to*100)
+				logging.Warn(logger, "Adaptive Runge-Kutta doesn't converge", logging.F("from", from), logging.F("to", to), logging.F("time", tnow), logging.F("error", currentError))
 				return nil, errors.New(errorString)
 			}
 			// Half the next integration interval and try again
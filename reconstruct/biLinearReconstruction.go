@@ -0,0 +1,186 @@
+package reconstruct
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/ode"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+// switchedControl is a control with constant control vectors over each sample
+// such as the control.AnalogSwitchControl.
+type switchedControl interface {
+	control.Control
+	GetControlVectors() []mat.Vector
+	GetControlDecisions() []uint
+}
+
+// NewBiLinearTimeVaryingReconstructor returns a time-varying Kalman smoother
+// reconstructor of a bilinear state space model, see
+// NewTimeVaryingReconstructor. The state dynamics
+//
+//	A(t) = AL + u_0(t) AB_0 + ... + u_N(t) AB_N
+//
+// are evaluated for the input functions of the model which therefore must be
+// known for the inputs with bilinear state dynamics. The model is discretized
+// per sample, which requires a control with constant control vectors, e.g.,
+// control.NewBiLinearAnalogSwitchControl.
+//
+// For a linearized estimator use any reconstructor with
+// ssm.BiLinearStateSpaceModel.Linearize instead.
+func NewBiLinearTimeVaryingReconstructor(cont control.Control, measurementNoiseCovariance mat.Matrix, inputNoiseVariances []float64, biLinearStateSpaceModel ssm.BiLinearStateSpaceModel, initialState mat.Vector, initialCovariance mat.Matrix) (*TimeVaryingReconstruction, error) {
+	if _, err := ssm.NewBiLinearStateSpaceModelChecked(biLinearStateSpaceModel.AL, biLinearStateSpaceModel.AB, biLinearStateSpaceModel.C, biLinearStateSpaceModel.Input); err != nil {
+		return nil, err
+	}
+	switched, ok := cont.(switchedControl)
+	if !ok {
+		return nil, errors.New("Bilinear reconstruction requires a control with constant control vectors")
+	}
+	linearPart, err := biLinearStateSpaceModel.Linearize(make([]float64, biLinearStateSpaceModel.InputSpaceOrder()))
+	if err != nil {
+		return nil, err
+	}
+	rec, err := NewTimeVaryingReconstructor(cont, measurementNoiseCovariance, inputNoiseVariances, *linearPart, initialState, initialCovariance)
+	if err != nil {
+		return nil, err
+	}
+
+	order := biLinearStateSpaceModel.StateSpaceOrder()
+	controlVectors := switched.GetControlVectors()
+	numberOfInputs := biLinearStateSpaceModel.InputSpaceOrder()
+	inputs := mat.NewDense(order, numberOfInputs+len(controlVectors), nil)
+	for column, input := range biLinearStateSpaceModel.Input {
+		inputs.SetCol(column, mat.Col(nil, 0, input.B))
+	}
+	for column, vector := range controlVectors {
+		if vector.Len() != order {
+			return nil, &ssm.DimensionError{What: "Control vector doesn't match the state space order"}
+		}
+		inputs.SetCol(numberOfInputs+column, mat.Col(nil, 0, vector))
+	}
+	rec.biLinear = &biLinearDiscretization{
+		model:          biLinearStateSpaceModel,
+		inputs:         inputs,
+		numberOfInputs: numberOfInputs,
+		control:        switched,
+	}
+	return rec, nil
+}
+
+// biLinearDiscretization discretizes a bilinear state space model per sample
+// by integrating the augmented system
+//
+//	x'(t) = A(t) x(t) + [B, Gamma] e,  e' = 0
+//
+// from [I, 0; 0, I] over each sample which results in
+// [Ad, Bd, Gammad; 0, I, 0; 0, 0, I].
+type biLinearDiscretization struct {
+	model ssm.BiLinearStateSpaceModel
+	// [B, Gamma], the input vectors followed by the control vectors
+	inputs         *mat.Dense
+	numberOfInputs int
+	control        switchedControl
+}
+
+// discretization returns the discrete state dynamics, input matrices and
+// control contributions of the first n samples. The samples are discretized
+// by at most runtime.NumCPU() workers.
+func (d *biLinearDiscretization) discretization(n int) (Ad, Bd []*mat.Dense, controlContributions []mat.Vector, err error) {
+	decisions := d.control.GetControlDecisions()
+	if n > len(decisions) {
+		return nil, nil, nil, control.ErrIndexOutOfRange
+	}
+	order, columns := d.inputs.Dims()
+	numberOfControls := columns - d.numberOfInputs
+	Ad = make([]*mat.Dense, n)
+	Bd = make([]*mat.Dense, n)
+	controlContributions = make([]mat.Vector, n)
+	errs := make([]error, n)
+
+	system := augmentedSystem{model: d.model, inputs: d.inputs}
+	initial := mat.NewDense(order+columns, order+columns, nil)
+	for index := 0; index < order+columns; index++ {
+		initial.Set(index, index, 1.)
+	}
+
+	var wg sync.WaitGroup
+	workers := runtime.NumCPU()
+	if workers > n {
+		workers = n
+	}
+	indices := make(chan int)
+	wg.Add(workers)
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			defer wg.Done()
+			rk := ode.NewFehlberg45()
+			for index := range indices {
+				from := d.control.GetT0() + float64(index)*d.control.GetTs()
+				res, err := rk.AdaptiveCompute(from, from+d.control.GetTs(), 1e-8, initial, system)
+				if err != nil {
+					errs[index] = err
+					continue
+				}
+				discrete := mat.DenseCopyOf(res)
+				Ad[index] = mat.DenseCopyOf(discrete.Slice(0, order, 0, order))
+				Bd[index] = mat.DenseCopyOf(discrete.Slice(0, order, order, order+d.numberOfInputs))
+				contribution := mat.NewVecDense(order, nil)
+				for controlIndex := 0; controlIndex < numberOfControls; controlIndex++ {
+					decision := 2.*float64((decisions[index]>>uint(controlIndex))&1) - 1.
+					for row := 0; row < order; row++ {
+						contribution.SetVec(row, contribution.AtVec(row)+decision*discrete.At(row, order+d.numberOfInputs+controlIndex))
+					}
+				}
+				controlContributions[index] = contribution
+			}
+		}()
+	}
+	for index := 0; index < n; index++ {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return Ad, Bd, controlContributions, nil
+}
+
+// augmentedSystem is the system x'(t) = A(t) x(t) + inputs e, e' = 0 with the
+// state [x; e].
+type augmentedSystem struct {
+	model  ssm.BiLinearStateSpaceModel
+	inputs *mat.Dense
+}
+
+func (sys augmentedSystem) Derivative(t float64, state mat.Vector) mat.Vector {
+	order, columns := sys.inputs.Dims()
+	x := mat.NewVecDense(order, nil)
+	e := mat.NewVecDense(columns, nil)
+	for index := 0; index < order; index++ {
+		x.SetVec(index, state.AtVec(index))
+	}
+	for index := 0; index < columns; index++ {
+		e.SetVec(index, state.AtVec(order+index))
+	}
+	var derivative, tmp mat.VecDense
+	derivative.MulVec(sys.model.StateDynamics(t), x)
+	tmp.MulVec(sys.inputs, e)
+	derivative.AddVec(&derivative, &tmp)
+	res := mat.NewVecDense(order+columns, nil)
+	for index := 0; index < order; index++ {
+		res.SetVec(index, derivative.AtVec(index))
+	}
+	return res
+}
+
+func (sys augmentedSystem) Order() int {
+	order, columns := sys.inputs.Dims()
+	return order + columns
+}
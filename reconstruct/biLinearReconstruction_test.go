@@ -0,0 +1,111 @@
+package reconstruct
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/gonumExtensions"
+	"github.com/hammal/adc/signal"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestBiLinearTimeVaryingReconstruction(t *testing.T) {
+	N := 3
+	length := 400
+	sig := func(arg1 float64) float64 { return 0.5 * math.Sin(math.Pi*2.*arg1*100.) }
+	// The gain of the second integrator is modulated by a known oscillation
+	modulation := func(arg1 float64) float64 { return math.Cos(math.Pi * 2. * arg1 * 1000.) }
+	controls := chainControls(N)
+	var measurementNoiseCovariance mat.Dense
+	measurementNoiseCovariance.Scale(1e2, gonumExtensions.Eye(N, N, 0))
+
+	// simulate returns the model and control of a simulation where the
+	// modulation has strength gamma.
+	simulate := func(input func(float64) float64, gamma float64) (*ssm.BiLinearStateSpaceModel, *control.AnalogSwitchControl, [][]float64) {
+		b := mat.NewVecDense(N, nil)
+		b.SetVec(0, chainGain)
+		AL := mat.NewDense(N, N, nil)
+		for row := 1; row < N; row++ {
+			AL.Set(row, row-1, chainGain)
+		}
+		AB := mat.NewDense(N, 2*N, nil)
+		AB.Set(1, N, gamma*chainGain)
+		sm := ssm.NewBiLinearStateSpaceModel(AL, AB, gonumExtensions.Eye(N, N, 0), []signal.VectorFunction{
+			signal.NewInput(input, b),
+			signal.NewInput(modulation, mat.NewVecDense(N, nil)),
+		})
+		ctrl := control.NewBiLinearAnalogSwitchControl(length, controls, chainTs, 0, nil, sm)
+		return sm, ctrl, ctrl.Simulate()
+	}
+
+	// Without input the per sample discretization reproduces the simulation
+	sm, ctrl, states := simulate(func(float64) float64 { return 0 }, 0.9)
+	rec, err := NewBiLinearTimeVaryingReconstructor(ctrl, &measurementNoiseCovariance, []float64{1., 1.}, *sm, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ad, _, controlContributions, err := rec.discretization(length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := mat.NewVecDense(N, nil)
+	for index := 0; index < length; index++ {
+		state.MulVec(Ad[index], state)
+		state.AddVec(state, controlContributions[index])
+		for row := 0; row < N; row++ {
+			if math.Abs(state.AtVec(row)-states[index][row]) > 1e-6 {
+				t.Fatalf("Discretized state %v doesn't match the simulated state %v at index %v", mat.Formatted(state.T()), states[index], index)
+			}
+		}
+		state = mat.NewVecDense(N, states[index])
+	}
+
+	rmse := func(res [][]float64) float64 {
+		sum := 0.
+		for index := 100; index < 300; index++ {
+			sum += math.Pow(res[index][0]-sig(float64(index)*chainTs), 2)
+		}
+		return math.Sqrt(sum / 200.)
+	}
+	for _, gamma := range []float64{0, 0.9} {
+		sm, ctrl, _ := simulate(sig, gamma)
+		rec, err := NewBiLinearTimeVaryingReconstructor(ctrl, &measurementNoiseCovariance, []float64{1., 1.}, *sm, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := rec.ReconstructionChecked()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := rmse(res); e > 0.05 {
+			t.Errorf("Root mean square error %v is too large for gamma = %v", e, gamma)
+		}
+		if gamma != 0 {
+			continue
+		}
+		// Without modulation the model is linear
+		linearPart, err := sm.Linearize([]float64{0, 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		linearRec, err := NewTimeVaryingReconstructor(ctrl, &measurementNoiseCovariance, []float64{1., 1.}, *linearPart, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		linearRes, err := linearRec.ReconstructionChecked()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for index := range res {
+			if math.Abs(res[index][0]-linearRes[index][0]) > 1e-6 {
+				t.Fatalf("Bilinear estimate %v doesn't match the linear estimate %v at index %v", res[index][0], linearRes[index][0], index)
+			}
+		}
+	}
+
+	if _, err := NewBiLinearTimeVaryingReconstructor(control.NewSwitchedCapacitorControl(length, nil, chainTs, 0, nil, ssm.NewIntegratorChain(N, chainGain, nil)), &measurementNoiseCovariance, []float64{1., 1.}, *sm, nil, nil); err == nil {
+		t.Error("Expected an error for a control without control vectors")
+	}
+}
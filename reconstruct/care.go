@@ -0,0 +1,322 @@
+package reconstruct
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/lapack"
+	"gonum.org/v1/gonum/lapack/gonum"
+	"gonum.org/v1/gonum/mat"
+)
+
+var (
+	// ErrNoStabilizingSolution is returned when the continuous algebraic
+	// Riccati equation has no stabilizing solution, e.g., when the Hamiltonian
+	// has eigenvalues on the imaginary axis.
+	ErrNoStabilizingSolution = errors.New("No stabilizing solution of the algebraic Riccati equation")
+	// ErrNotPositiveDefinite is returned when the solution of the algebraic
+	// Riccati equation is not symmetric positive definite.
+	ErrNotPositiveDefinite = errors.New("Solution of the algebraic Riccati equation is not positive definite")
+	// ErrLargeResidual is returned when the solution doesn't satisfy the
+	// algebraic Riccati equation to the required tolerance.
+	ErrLargeResidual = errors.New("Residual of the algebraic Riccati equation exceeds the tolerance")
+)
+
+// CARETolerance is the relative residual accepted by SolveCARE
+const CARETolerance = 1e-8
+
+// SolveCARE returns the stabilizing solution X of the continuous algebraic
+// Riccati equation
+//
+//	A^T X + X A - X R X + Q = 0
+//
+// The solution is computed with the ordered real Schur method, refined by
+// Newton-Kleinman iterations and validated with CheckCARESolution.
+func SolveCARE(A, R, Q mat.Matrix) (*mat.Dense, error) {
+	X, err := CARESchur(A, R, Q)
+	if err != nil {
+		return nil, err
+	}
+	if refined, err := CARENewtonKleinman(A, R, Q, X, 5, 1e-14); err == nil && CAREResidual(A, R, Q, refined) <= CAREResidual(A, R, Q, X) {
+		X = refined
+	}
+	if err := CheckCARESolution(A, R, Q, X, CARETolerance); err != nil {
+		return nil, err
+	}
+	return X, nil
+}
+
+// CARESchur solves the continuous algebraic Riccati equation, see SolveCARE,
+// with Laub's ordered real Schur method. The real Schur form of the Hamiltonian
+//
+//	H = [A, -R; -Q, -A^T]
+//
+// is ordered such that the stable eigenvalues come first. The first n Schur
+// vectors [U1; U2] span the stable invariant subspace and X = U2 U1^(-1).
+func CARESchur(A, R, Q mat.Matrix) (*mat.Dense, error) {
+	n, err := checkCAREDims(A, R, Q)
+	if err != nil {
+		return nil, err
+	}
+	N := 2 * n
+
+	// H = [A, -R; -Q, -A^T]
+	hamiltonian := mat.NewDense(N, N, nil)
+	for row := 0; row < n; row++ {
+		for column := 0; column < n; column++ {
+			hamiltonian.Set(row, column, A.At(row, column))
+			hamiltonian.Set(row, column+n, -R.At(row, column))
+			hamiltonian.Set(row+n, column, -Q.At(row, column))
+			hamiltonian.Set(row+n, column+n, -A.At(column, row))
+		}
+	}
+	norm := mat.Norm(hamiltonian, math.Inf(1))
+
+	T, Z, err := orderedSchur(hamiltonian)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exactly n eigenvalues must be strictly stable. Eigenvalues on the
+	// imaginary axis are typically defective and therefore perturbed by the
+	// square root of the machine precision.
+	for index := 0; index < N; index++ {
+		if math.Abs(T.At(index, index)) <= math.Sqrt(2.220446049250313e-16)*norm {
+			return nil, ErrNoStabilizingSolution
+		}
+		if (T.At(index, index) < 0) != (index < n) {
+			return nil, ErrNoStabilizingSolution
+		}
+	}
+
+	// X = U2 U1^(-1) <=> U1^T X^T = U2^T
+	U1 := Z.Slice(0, n, 0, n)
+	U2 := Z.Slice(n, N, 0, n)
+	var Xt mat.Dense
+	if err := Xt.Solve(U1.T(), U2.T()); err != nil {
+		return nil, ErrNoStabilizingSolution
+	}
+	X := mat.DenseCopyOf(Xt.T())
+	symmetrize(X)
+	return X, nil
+}
+
+// CARENewtonKleinman refines an initial stabilizing solution X0 of the
+// continuous algebraic Riccati equation, see SolveCARE, with at most iterations
+// Newton-Kleinman steps
+//
+//	(A - R Xk)^T X(k+1) + X(k+1) (A - R Xk) = -Q - Xk R Xk
+//
+// until the relative change is below tolerance. ErrNoStabilizingSolution is
+// returned if A - R X0 isn't stable.
+func CARENewtonKleinman(A, R, Q, X0 mat.Matrix, iterations int, tolerance float64) (*mat.Dense, error) {
+	n, err := checkCAREDims(A, R, Q)
+	if err != nil {
+		return nil, err
+	}
+	if m, k := X0.Dims(); m != n || k != n {
+		return nil, errors.New("Initial solution doesn't match A")
+	}
+	X := mat.DenseCopyOf(X0)
+	if !closedLoopStable(A, R, X) {
+		return nil, ErrNoStabilizingSolution
+	}
+	for iteration := 0; iteration < iterations; iteration++ {
+		var RX, closedLoop, XRX, right mat.Dense
+		RX.Mul(R, X)
+		closedLoop.Sub(A, &RX)
+		XRX.Mul(X, &RX)
+		right.Add(Q, &XRX)
+		right.Scale(-1, &right)
+
+		next, err := lyapunov(&closedLoop, &right)
+		if err != nil {
+			return nil, ErrNoStabilizingSolution
+		}
+		symmetrize(next)
+
+		var difference mat.Dense
+		difference.Sub(next, X)
+		X = next
+		if mat.Norm(&difference, 2) <= tolerance*mat.Norm(X, 2) {
+			break
+		}
+	}
+	return X, nil
+}
+
+// CAREResidual returns the relative residual of X
+//
+//	||A^T X + X A - X R X + Q|| / (||A^T X|| + ||X A|| + ||X R X|| + ||Q||)
+//
+// in the Frobenius norm.
+func CAREResidual(A, R, Q, X mat.Matrix) float64 {
+	var AtX, XA, XRX, residual mat.Dense
+	AtX.Mul(A.T(), X)
+	XA.Mul(X, A)
+	XRX.Mul(X, R)
+	XRX.Mul(&XRX, X)
+	residual.Add(&AtX, &XA)
+	residual.Sub(&residual, &XRX)
+	residual.Add(&residual, Q)
+	scale := mat.Norm(&AtX, 2) + mat.Norm(&XA, 2) + mat.Norm(&XRX, 2) + mat.Norm(Q, 2)
+	if scale == 0 {
+		return 0
+	}
+	return mat.Norm(&residual, 2) / scale
+}
+
+// CheckCARESolution validates a solution X of the continuous algebraic Riccati
+// equation. ErrLargeResidual is returned if the relative residual exceeds
+// tolerance, ErrNotPositiveDefinite if X isn't symmetric positive definite and
+// ErrNoStabilizingSolution if A - R X isn't stable.
+func CheckCARESolution(A, R, Q, X mat.Matrix, tolerance float64) error {
+	n, err := checkCAREDims(A, R, Q)
+	if err != nil {
+		return err
+	}
+	if m, k := X.Dims(); m != n || k != n {
+		return errors.New("Solution doesn't match A")
+	}
+	if CAREResidual(A, R, Q, X) > tolerance {
+		return ErrLargeResidual
+	}
+	if !positiveDefinite(X, tolerance) {
+		return ErrNotPositiveDefinite
+	}
+	if !closedLoopStable(A, R, X) {
+		return ErrNoStabilizingSolution
+	}
+	return nil
+}
+
+// checkCAREDims checks that A, R and Q are square and of the same size
+func checkCAREDims(A, R, Q mat.Matrix) (int, error) {
+	n, m := A.Dims()
+	if n != m {
+		return 0, errors.New("A is not square")
+	}
+	for _, matrix := range []mat.Matrix{R, Q} {
+		if rows, columns := matrix.Dims(); rows != n || columns != n {
+			return 0, errors.New("R and Q must be of the same size as A")
+		}
+	}
+	return n, nil
+}
+
+// positiveDefinite reports if X is symmetric, up to tolerance, and positive
+// definite.
+func positiveDefinite(X mat.Matrix, tolerance float64) bool {
+	n, _ := X.Dims()
+	symmetric := mat.NewSymDense(n, nil)
+	for row := 0; row < n; row++ {
+		for column := row; column < n; column++ {
+			if math.Abs(X.At(row, column)-X.At(column, row)) > tolerance*mat.Norm(X, math.Inf(1)) {
+				return false
+			}
+			symmetric.SetSym(row, column, X.At(row, column))
+		}
+	}
+	var cholesky mat.Cholesky
+	return cholesky.Factorize(symmetric)
+}
+
+// closedLoopStable reports if all eigenvalues of A - R X have negative real
+// parts.
+func closedLoopStable(A, R, X mat.Matrix) bool {
+	var closedLoop mat.Dense
+	closedLoop.Mul(R, X)
+	closedLoop.Sub(A, &closedLoop)
+	var eigen mat.Eigen
+	if !eigen.Factorize(&closedLoop, false, false) {
+		return false
+	}
+	for _, value := range eigen.Values(nil) {
+		if real(value) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// lyapunov solves A^T X + X A = M by writing it as the linear system
+// (I kron A^T + A^T kron I) vec(X) = vec(M).
+func lyapunov(A, M mat.Matrix) (*mat.Dense, error) {
+	n, _ := A.Dims()
+	system := mat.NewDense(n*n, n*n, nil)
+	right := mat.NewVecDense(n*n, nil)
+	for row := 0; row < n; row++ {
+		for column := 0; column < n; column++ {
+			equation := row*n + column
+			right.SetVec(equation, M.At(row, column))
+			for k := 0; k < n; k++ {
+				// (A^T X)[row][column] = sum_k A[k][row] X[k][column]
+				system.Set(equation, k*n+column, system.At(equation, k*n+column)+A.At(k, row))
+				// (X A)[row][column] = sum_k X[row][k] A[k][column]
+				system.Set(equation, row*n+k, system.At(equation, row*n+k)+A.At(k, column))
+			}
+		}
+	}
+	var solution mat.VecDense
+	if err := solution.SolveVec(system, right); err != nil {
+		return nil, err
+	}
+	return mat.NewDense(n, n, solution.RawVector().Data), nil
+}
+
+// orderedSchur returns the real Schur decomposition H = Z T Z^T where the
+// eigenvalues with negative real parts come first on the diagonal of T.
+func orderedSchur(H *mat.Dense) (T, Z *mat.Dense, err error) {
+	impl := gonum.Implementation{}
+	N, _ := H.Dims()
+	T = mat.DenseCopyOf(H)
+	t := T.RawMatrix()
+
+	// Hessenberg reduction H = Q Hh Q^T
+	tau := make([]float64, N-1)
+	work := make([]float64, 1)
+	impl.Dgehrd(N, 0, N-1, t.Data, t.Stride, tau, work, -1)
+	work = make([]float64, int(work[0]))
+	impl.Dgehrd(N, 0, N-1, t.Data, t.Stride, tau, work, len(work))
+
+	Z = mat.DenseCopyOf(T)
+	z := Z.RawMatrix()
+	impl.Dorghr(N, 0, N-1, z.Data, z.Stride, tau, work, -1)
+	work = make([]float64, int(work[0]))
+	impl.Dorghr(N, 0, N-1, z.Data, z.Stride, tau, work, len(work))
+
+	// Remove the reflectors below the subdiagonal
+	for row := 2; row < N; row++ {
+		for column := 0; column < row-1; column++ {
+			T.Set(row, column, 0)
+		}
+	}
+
+	// Real Schur form
+	wr := make([]float64, N)
+	wi := make([]float64, N)
+	impl.Dhseqr(lapack.EigenvaluesAndSchur, lapack.SchurOrig, N, 0, N-1, t.Data, t.Stride, wr, wi, z.Data, z.Stride, work, -1)
+	work = make([]float64, int(math.Max(work[0], float64(N))))
+	if unconverged := impl.Dhseqr(lapack.EigenvaluesAndSchur, lapack.SchurOrig, N, 0, N-1, t.Data, t.Stride, wr, wi, z.Data, z.Stride, work, len(work)); unconverged > 0 {
+		return nil, nil, errors.New("Schur decomposition failed to converge")
+	}
+
+	// Move the stable blocks to the top
+	stable := 0
+	for index := 0; index < N; {
+		size := 1
+		if index < N-1 && T.At(index+1, index) != 0 {
+			size = 2
+		}
+		if T.At(index, index) < 0 {
+			if index != stable {
+				if _, _, ok := impl.Dtrexc(lapack.UpdateSchur, N, t.Data, t.Stride, z.Data, z.Stride, index, stable, work); !ok {
+					return nil, nil, errors.New("Reordering of the Schur form failed")
+				}
+			}
+			stable += size
+		}
+		index += size
+	}
+	return T, Z, nil
+}
@@ -0,0 +1,74 @@
+package reconstruct
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestSolveCAREScalar(t *testing.T) {
+	a, r, q := 2., 3., 5.
+	X, err := SolveCARE(mat.NewDense(1, 1, []float64{a}), mat.NewDense(1, 1, []float64{r}), mat.NewDense(1, 1, []float64{q}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 a x - r x^2 + q = 0
+	if expected := (a + math.Sqrt(a*a+r*q)) / r; math.Abs(X.At(0, 0)-expected) > 1e-12 {
+		t.Errorf("X = %v instead of %v", X.At(0, 0), expected)
+	}
+}
+
+func TestSolveCAREOscillator(t *testing.T) {
+	// A lossless oscillator where the Hamiltonian has complex eigenvalues
+	omega := 2 * math.Pi * 100.
+	A := mat.NewDense(2, 2, []float64{0, omega, -omega, 0})
+	R := mat.NewDense(2, 2, []float64{1, 0, 0, 0})
+	Q := mat.NewDense(2, 2, []float64{1e3, 0, 0, 1e3})
+
+	X, err := SolveCARE(A, R, Q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if residual := CAREResidual(A, R, Q, X); residual > 1e-12 {
+		t.Errorf("Residual %v is too large", residual)
+	}
+
+	// The Newton-Kleinman iterations keep the solution
+	refined, err := CARENewtonKleinman(A, R, Q, X, 10, 1e-15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var difference mat.Dense
+	difference.Sub(refined, X)
+	if mat.Norm(&difference, 2) > 1e-9*mat.Norm(X, 2) {
+		t.Errorf("Newton-Kleinman moved the solution\n%v\n%v", mat.Formatted(X), mat.Formatted(refined))
+	}
+
+	// Without observations the oscillation can't be stabilized
+	if _, err := SolveCARE(A, mat.NewDense(2, 2, nil), Q); err != ErrNoStabilizingSolution {
+		t.Errorf("Expected ErrNoStabilizingSolution but got %v", err)
+	}
+}
+
+func TestCheckCARESolution(t *testing.T) {
+	A := mat.NewDense(1, 1, []float64{1})
+	R := mat.NewDense(1, 1, []float64{1})
+	Q := mat.NewDense(1, 1, []float64{3})
+	// x^2 - 2 x - 3 = 0 has the stabilizing solution 3 and -1
+	if err := CheckCARESolution(A, R, Q, mat.NewDense(1, 1, []float64{3}), CARETolerance); err != nil {
+		t.Error(err)
+	}
+	if err := CheckCARESolution(A, R, Q, mat.NewDense(1, 1, []float64{-1}), CARETolerance); err != ErrNotPositiveDefinite {
+		t.Errorf("Expected ErrNotPositiveDefinite but got %v", err)
+	}
+	if err := CheckCARESolution(A, R, Q, mat.NewDense(1, 1, []float64{2}), CARETolerance); err != ErrLargeResidual {
+		t.Errorf("Expected ErrLargeResidual but got %v", err)
+	}
+	if _, err := CARENewtonKleinman(A, R, Q, mat.NewDense(1, 1, []float64{0}), 10, 1e-12); err != ErrNoStabilizingSolution {
+		t.Errorf("Expected ErrNoStabilizingSolution for an unstable initial solution but got %v", err)
+	}
+	if _, err := SolveCARE(A, mat.NewDense(2, 2, nil), Q); err == nil {
+		t.Error("Expected an error for mismatching dimensions")
+	}
+}
@@ -0,0 +1,182 @@
+package reconstruct
+
+import (
+	"errors"
+	"math"
+
+	"github.com/hammal/adc/gonumExtensions"
+	"gonum.org/v1/gonum/mat"
+)
+
+// DARETolerance is the relative residual accepted by SolveDARE
+const DARETolerance = 1e-8
+
+// SolveDARE returns the stabilizing solution X of the discrete algebraic
+// Riccati equation
+//
+//	X = A^T X A - A^T X B (R + B^T X B)^(-1) B^T X A + Q
+//
+// computed with the structure-preserving doubling algorithm and validated with
+// CheckDARESolution. The errors are those of the continuous algebraic Riccati
+// equation, e.g., ErrNoStabilizingSolution.
+func SolveDARE(A, B, Q, R mat.Matrix) (*mat.Dense, error) {
+	n, err := checkDAREDims(A, B, Q, R)
+	if err != nil {
+		return nil, err
+	}
+	identity := eye(n)
+
+	// G = B R^(-1) B^T
+	var RinvBt, G mat.Dense
+	if err := RinvBt.Solve(R, B.T()); err != nil {
+		return nil, err
+	}
+	G.Mul(B, &RinvBt)
+	symmetrize(&G)
+
+	Ak := mat.DenseCopyOf(A)
+	Gk := mat.DenseCopyOf(&G)
+	Hk := mat.DenseCopyOf(Q)
+	converged := false
+	for iteration := 0; iteration < 100; iteration++ {
+		// W = I + G H
+		var W, WinvA, WinvG, tmp mat.Dense
+		W.Mul(Gk, Hk)
+		W.Add(identity, &W)
+		if err := WinvA.Solve(&W, Ak); err != nil {
+			return nil, ErrNoStabilizingSolution
+		}
+		if err := WinvG.Solve(&W, Gk); err != nil {
+			return nil, ErrNoStabilizingSolution
+		}
+
+		// H(k+1) = H + A^T H W^(-1) A
+		var nextH mat.Dense
+		tmp.Mul(Hk, &WinvA)
+		nextH.Mul(Ak.T(), &tmp)
+		nextH.Add(Hk, &nextH)
+		symmetrize(&nextH)
+
+		// G(k+1) = G + A W^(-1) G A^T
+		var nextG mat.Dense
+		tmp.Mul(&WinvG, Ak.T())
+		nextG.Mul(Ak, &tmp)
+		nextG.Add(Gk, &nextG)
+		symmetrize(&nextG)
+
+		// A(k+1) = A W^(-1) A
+		var nextA mat.Dense
+		nextA.Mul(Ak, &WinvA)
+
+		var difference mat.Dense
+		difference.Sub(&nextH, Hk)
+		Ak, Gk, Hk = &nextA, &nextG, &nextH
+		if gonumExtensions.NANORINF(Hk) {
+			return nil, ErrNoStabilizingSolution
+		}
+		if mat.Norm(&difference, 2) <= 1e-15*mat.Norm(Hk, 2) {
+			converged = true
+			break
+		}
+	}
+	if !converged {
+		return nil, ErrNoStabilizingSolution
+	}
+	if err := CheckDARESolution(A, B, Q, R, Hk, DARETolerance); err != nil {
+		return nil, err
+	}
+	return Hk, nil
+}
+
+// DAREResidual returns the relative residual of X
+//
+//	||A^T X A - X - A^T X B (R + B^T X B)^(-1) B^T X A + Q|| / (||A^T X A|| + ||X|| + ||Q||)
+//
+// in the Frobenius norm.
+func DAREResidual(A, B, Q, R, X mat.Matrix) float64 {
+	var AtXA, gain, residual, correction mat.Dense
+	AtXA.Mul(X, A)
+	AtXA.Mul(A.T(), &AtXA)
+	if err := dareGain(A, B, R, X, &gain); err != nil {
+		return math.Inf(1)
+	}
+	// A^T X B K with K = (R + B^T X B)^(-1) B^T X A
+	var XB, AtXB mat.Dense
+	XB.Mul(X, B)
+	AtXB.Mul(A.T(), &XB)
+	correction.Mul(&AtXB, &gain)
+	residual.Sub(&AtXA, X)
+	residual.Sub(&residual, &correction)
+	residual.Add(&residual, Q)
+	scale := mat.Norm(&AtXA, 2) + mat.Norm(X, 2) + mat.Norm(Q, 2)
+	if scale == 0 {
+		return 0
+	}
+	return mat.Norm(&residual, 2) / scale
+}
+
+// CheckDARESolution validates a solution X of the discrete algebraic Riccati
+// equation. ErrLargeResidual is returned if the relative residual exceeds
+// tolerance, ErrNotPositiveDefinite if X isn't symmetric positive definite and
+// ErrNoStabilizingSolution if A - B K, K = (R + B^T X B)^(-1) B^T X A, has
+// eigenvalues outside the unit circle.
+func CheckDARESolution(A, B, Q, R, X mat.Matrix, tolerance float64) error {
+	n, err := checkDAREDims(A, B, Q, R)
+	if err != nil {
+		return err
+	}
+	if m, k := X.Dims(); m != n || k != n {
+		return errors.New("Solution doesn't match A")
+	}
+	if DAREResidual(A, B, Q, R, X) > tolerance {
+		return ErrLargeResidual
+	}
+	if !positiveDefinite(X, tolerance) {
+		return ErrNotPositiveDefinite
+	}
+	var gain, closedLoop mat.Dense
+	if err := dareGain(A, B, R, X, &gain); err != nil {
+		return ErrNoStabilizingSolution
+	}
+	closedLoop.Mul(B, &gain)
+	closedLoop.Sub(A, &closedLoop)
+	var eigen mat.Eigen
+	if !eigen.Factorize(&closedLoop, false, false) {
+		return ErrNoStabilizingSolution
+	}
+	for _, value := range eigen.Values(nil) {
+		if math.Hypot(real(value), imag(value)) >= 1 {
+			return ErrNoStabilizingSolution
+		}
+	}
+	return nil
+}
+
+// dareGain computes K = (R + B^T X B)^(-1) B^T X A into gain
+func dareGain(A, B, R, X mat.Matrix, gain *mat.Dense) error {
+	var XB, S, BtXA mat.Dense
+	XB.Mul(X, B)
+	S.Mul(B.T(), &XB)
+	S.Add(R, &S)
+	BtXA.Mul(XB.T(), A)
+	return gain.Solve(&S, &BtXA)
+}
+
+// checkDAREDims checks that A, Q are n x n, B n x m and R m x m
+func checkDAREDims(A, B, Q, R mat.Matrix) (int, error) {
+	n, m := A.Dims()
+	if n != m {
+		return 0, errors.New("A is not square")
+	}
+	if rows, columns := Q.Dims(); rows != n || columns != n {
+		return 0, errors.New("Q must be of the same size as A")
+	}
+	rows, inputs := B.Dims()
+	if rows != n {
+		return 0, errors.New("B must have as many rows as A")
+	}
+	if rows, columns := R.Dims(); rows != inputs || columns != inputs {
+		return 0, errors.New("R must be square with as many rows as B has columns")
+	}
+	return n, nil
+}
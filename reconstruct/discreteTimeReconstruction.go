@@ -0,0 +1,203 @@
+package reconstruct
+
+import (
+	"errors"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/logging"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+// discreteTimeReconstruction is a steady state Kalman smoother designed for the
+// exactly discretized system
+//
+//	x[k+1] = Ad x[k] + s[k] + w[k]
+//	0 = C x[k] + z[k]
+//
+// where w[k] is the discretized input noise with covariance Qd and s[k] the
+// control contribution. The forward recursion is the steady state Kalman
+// predictor and the backward recursion the modified Bryson-Frazier smoother
+// driven by the innovations of the predictor.
+type discreteTimeReconstruction struct {
+	// Forward predictor dynamics Ad (I - K C)
+	Af mat.Dense
+	// Backward dynamics (I - K C)^T Ad^T
+	Ab mat.Dense
+	// Observation matrix
+	C mat.Matrix
+	// C^T S^(-1) with S = C P C^T + Sigma_z
+	innovationWeight mat.Dense
+	// Input weights Sigma_u Bd^T / Ts
+	W mat.Dense
+	// Control interface and its control contributions
+	control       control.Control
+	contributions *controlContributions
+}
+
+// NewDiscreteTimeReconstructor returns a steady state reconstructor designed
+// with the discrete algebraic Riccati equation for the exactly discretized
+// state space model. The parameters are those of the continuous time model, as
+// for NewSteadyStateReconstructor, where inputNoiseVariances holds the power
+// spectral density of each input and measurementNoiseCovariance the power
+// spectral density of the measurement noise. Both are discretized for the
+// sample period of the control, the input noise with Van Loan's method and the
+// measurement noise as measurementNoiseCovariance / Ts. The control
+// contributions are computed as for NewTimeVaryingReconstructor and the filter
+// contributions of the control are left unchanged.
+func NewDiscreteTimeReconstructor(cont control.Control, measurementNoiseCovariance mat.Matrix, inputNoiseVariances []float64, linearStateSpaceModel ssm.LinearStateSpaceModel) (Reconstruction, error) {
+	if cont == nil {
+		return nil, errors.New("A control is required for reconstruction")
+	}
+	if _, err := ssm.NewLinearStateSpaceModelChecked(linearStateSpaceModel.A, linearStateSpaceModel.C, linearStateSpaceModel.Input); err != nil {
+		return nil, err
+	}
+	order := linearStateSpaceModel.StateSpaceOrder()
+	numberOfInputs := linearStateSpaceModel.InputSpaceOrder()
+	observations := linearStateSpaceModel.ObservationSpaceOrder()
+	if len(inputNoiseVariances) != numberOfInputs {
+		return nil, &ssm.DimensionError{What: "Number of input noise variances doesn't match the number of inputs"}
+	}
+	if m, n := measurementNoiseCovariance.Dims(); m != observations || n != observations {
+		return nil, &ssm.DimensionError{What: "Measurement noise covariance doesn't match the observation space order"}
+	}
+	ts := cont.GetTs()
+
+	// B = [b_0, ..., b_N] and Q = B Sigma_u B^T
+	B := mat.NewDense(order, numberOfInputs, nil)
+	inputNoiseCovariance := mat.NewDense(numberOfInputs, numberOfInputs, nil)
+	for column, input := range linearStateSpaceModel.Input {
+		if inputNoiseVariances[column] <= 0 {
+			return nil, errors.New("Input noise variances must be positive")
+		}
+		inputNoiseCovariance.Set(column, column, inputNoiseVariances[column])
+		for row := 0; row < order; row++ {
+			B.Set(row, column, input.B.AtVec(row))
+		}
+	}
+	var BSigma, Q mat.Dense
+	BSigma.Mul(B, inputNoiseCovariance)
+	Q.Mul(&BSigma, B.T())
+
+	Ad, Qd := ssm.NoiseCovarianceDiscretization(linearStateSpaceModel.A, &Q, ts)
+	_, Bd := ssm.ZeroOrderHoldDiscretization(linearStateSpaceModel.A, B, ts)
+	contributions, err := newControlContributions(cont, linearStateSpaceModel.A)
+	if err != nil {
+		return nil, err
+	}
+	var measurementCovariance mat.Dense
+	measurementCovariance.Scale(1/ts, measurementNoiseCovariance)
+
+	// Prediction covariance P = Ad P Ad^T - Ad P C^T S^(-1) C P Ad^T + Qd
+	C := linearStateSpaceModel.C
+	P, err := SolveDARE(Ad.T(), C.T(), Qd, &measurementCovariance)
+	if err != nil {
+		return nil, err
+	}
+	logging.Debug(logger, "Solution to DARE", logging.F("P", P), logging.F("Qd", Qd))
+
+	// K^T = S^(-1) C P
+	var PCt, S, Kt, KC, IKC mat.Dense
+	PCt.Mul(P, C.T())
+	S.Mul(C, &PCt)
+	S.Add(&S, &measurementCovariance)
+	if err := Kt.Solve(&S, PCt.T()); err != nil {
+		return nil, err
+	}
+	KC.Mul(Kt.T(), C)
+	IKC.Sub(eye(order), &KC)
+
+	rec := discreteTimeReconstruction{
+		C:             C,
+		control:       cont,
+		contributions: contributions,
+	}
+	rec.Af.Mul(Ad, &IKC)
+	rec.Ab.Mul(IKC.T(), Ad.T())
+	// C^T S^(-1) = (S^(-1) C)^T
+	var SinvC mat.Dense
+	if err := SinvC.Solve(&S, C); err != nil {
+		return nil, err
+	}
+	rec.innovationWeight.Clone(SinvC.T())
+	rec.W.Mul(inputNoiseCovariance, Bd.T())
+	rec.W.Scale(1/ts, &rec.W)
+
+	logging.Debug(logger, "Discrete time filter dynamics", logging.F("Af", &rec.Af), logging.F("Ab", &rec.Ab))
+	return &rec, nil
+}
+
+// Reconstruction returns the reconstructed estimates based on the associated
+// control interface.
+//
+// The returned data structure is [number of time indices][number of estimates]float64
+//
+// Panics if the reconstruction fails, see ReconstructionChecked.
+func (rec *discreteTimeReconstruction) Reconstruction() [][]float64 {
+	res, err := rec.ReconstructionChecked()
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// ReconstructionChecked is Reconstruction returning an error, e.g.,
+// control.ErrIndexOutOfRange, if the control can't provide the control
+// contributions.
+func (rec *discreteTimeReconstruction) ReconstructionChecked() ([][]float64, error) {
+	n := rec.control.GetLength()
+	if n == 0 {
+		return nil, control.ErrIndexOutOfRange
+	}
+	order, _ := rec.Af.Dims()
+
+	// Forward predictor, storing the weighted innovations C^T S^(-1) e[k]
+	// with e[k] = 0 - C x[k|k-1]
+	innovations := make([]mat.VecDense, n)
+	state := mat.NewVecDense(order, nil)
+	for index := 0; index < n; index++ {
+		var observation mat.VecDense
+		observation.MulVec(rec.C, state)
+		innovations[index].MulVec(&rec.innovationWeight, &observation)
+		innovations[index].ScaleVec(-1, &innovations[index])
+
+		ctrl, err := rec.contributions.at(index)
+		if err != nil {
+			return nil, err
+		}
+		state.MulVec(&rec.Af, state)
+		state.AddVec(state, ctrl)
+	}
+
+	// Backward recursion lambda[k] = C^T S^(-1) e[k] + Ab lambda[k+1] with
+	// lambda[n] = 0 and the estimate u[k] = W lambda[k+1]
+	_, nrInputs := rec.W.T().Dims()
+	estimate := make([][]float64, n)
+	lambda := mat.NewVecDense(order, nil)
+	for index := n - 1; index >= 0; index-- {
+		var res mat.VecDense
+		res.MulVec(&rec.W, lambda)
+		estimate[index] = make([]float64, nrInputs)
+		for inp := range estimate[index] {
+			estimate[index][inp] = res.AtVec(inp)
+		}
+		lambda.MulVec(&rec.Ab, lambda)
+		lambda.AddVec(lambda, &innovations[index])
+	}
+	return estimate, nil
+}
+
+// GetStateDynamics returns copies of the forward predictor dynamics
+// Ad (I - K C) and the backward dynamics (I - K C)^T Ad^T.
+func (rec *discreteTimeReconstruction) GetStateDynamics() (Af, Ab *mat.Dense) {
+	return mat.DenseCopyOf(&rec.Af), mat.DenseCopyOf(&rec.Ab)
+}
+
+// eye returns the n x n identity matrix
+func eye(n int) *mat.Dense {
+	res := mat.NewDense(n, n, nil)
+	for index := 0; index < n; index++ {
+		res.Set(index, index, 1)
+	}
+	return res
+}
@@ -0,0 +1,182 @@
+package reconstruct
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/gonumExtensions"
+	"github.com/hammal/adc/signal"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestSolveDARE(t *testing.T) {
+	// x = a^2 x r / (r + x) + q for b = 1
+	r, q := 1., 1.
+	for _, a := range []float64{0.9, 2.} {
+		X, err := SolveDARE(mat.NewDense(1, 1, []float64{a}), mat.NewDense(1, 1, []float64{1}), mat.NewDense(1, 1, []float64{q}), mat.NewDense(1, 1, []float64{r}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := r - a*a*r - q
+		if expected := (-p + math.Sqrt(p*p+4*q*r)) / 2; math.Abs(X.At(0, 0)-expected) > 1e-9*expected {
+			t.Errorf("X = %v instead of %v for a = %v", X.At(0, 0), expected, a)
+		}
+	}
+
+	// An uncontrollable mode on the unit circle can't be stabilized
+	if _, err := SolveDARE(mat.NewDense(1, 1, []float64{1}), mat.NewDense(1, 1, []float64{0}), mat.NewDense(1, 1, []float64{q}), mat.NewDense(1, 1, []float64{r})); err != ErrNoStabilizingSolution {
+		t.Errorf("Expected ErrNoStabilizingSolution but got %v", err)
+	}
+	// More states than inputs, compared to the fixed point of the Riccati
+	// recursion
+	A := mat.NewDense(2, 2, []float64{0.9, 1, 0, 0.8})
+	B := mat.NewDense(2, 1, []float64{0, 1})
+	Q := mat.NewDense(2, 2, []float64{1, 0, 0, 1})
+	R := mat.NewDense(1, 1, []float64{1})
+	X, err := SolveDARE(A, B, Q, R)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recursion := mat.DenseCopyOf(Q)
+	for iteration := 0; iteration < 1000; iteration++ {
+		var gain, AtXA, AtXB, correction mat.Dense
+		if err := dareGain(A, B, R, recursion, &gain); err != nil {
+			t.Fatal(err)
+		}
+		AtXA.Mul(recursion, A)
+		AtXA.Mul(A.T(), &AtXA)
+		AtXB.Mul(recursion, B)
+		AtXB.Mul(A.T(), &AtXB)
+		correction.Mul(&AtXB, &gain)
+		recursion.Sub(&AtXA, &correction)
+		recursion.Add(recursion, Q)
+	}
+	if !mat.EqualApprox(X, recursion, 1e-6*mat.Norm(X, 2)) {
+		t.Errorf("X =\n%v\ninstead of\n%v", mat.Formatted(X), mat.Formatted(recursion))
+	}
+	if residual := DAREResidual(A, B, Q, R, X); residual > DARETolerance {
+		t.Errorf("Residual %v", residual)
+	}
+
+	if err := CheckDARESolution(mat.NewDense(1, 1, []float64{0.9}), mat.NewDense(1, 1, []float64{1}), mat.NewDense(1, 1, []float64{q}), mat.NewDense(1, 1, []float64{r}), mat.NewDense(1, 1, []float64{1}), DARETolerance); err != ErrLargeResidual {
+		t.Errorf("Expected ErrLargeResidual but got %v", err)
+	}
+}
+
+func TestDiscreteTimeReconstruction(t *testing.T) {
+	N := 3
+	sig := func(arg1 float64) float64 { return 0.5 * math.Sin(math.Pi*2.*arg1*100.) }
+	sm, b, controls := integratorChain(N, sig)
+	length := 1000
+	ctrl := simulateChain(t, sm, controls, length)
+
+	var inputNoiseCovariance, measurementNoiseCovariance mat.Dense
+	inputNoiseCovariance.Outer(1., b, b)
+	measurementNoiseCovariance.Scale(1e2, gonumExtensions.Eye(N, N, 0))
+	steadyState := NewSteadyStateReconstructor(ctrl, &measurementNoiseCovariance, &inputNoiseCovariance, *sm)
+	before := steadyState.Reconstruction()
+
+	// The discrete time reconstructor leaves the filter contributions of the
+	// steady state reconstructor sharing the control unchanged
+	rec, err := NewDiscreteTimeReconstructor(ctrl, &measurementNoiseCovariance, []float64{1.}, *sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	continuous := steadyState.Reconstruction()
+	if !reflect.DeepEqual(before, continuous) {
+		t.Error("The discrete time reconstructor changes the steady state reconstruction")
+	}
+	discrete, err := rec.ReconstructionChecked()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The discrete time design is at least as good as the continuous time
+	// approximation and both agree.
+	var continuousError, discreteError, difference float64
+	for index := 100; index < length-100; index++ {
+		continuousError += math.Pow(continuous[index][0]-sig(float64(index)*chainTs), 2)
+		discreteError += math.Pow(discrete[index][0]-sig(float64(index)*chainTs), 2)
+		difference = math.Max(difference, math.Abs(continuous[index][0]-discrete[index][0]))
+	}
+	if discreteError > continuousError {
+		t.Errorf("Discrete time error %v exceeds the continuous time error %v", math.Sqrt(discreteError/800), math.Sqrt(continuousError/800))
+	}
+	if difference > 0.05 {
+		t.Errorf("Discrete and continuous time reconstructions differ by %v", difference)
+	}
+
+	// Both filters are stable
+	Af, Ab := rec.GetStateDynamics()
+	for _, dynamics := range []*mat.Dense{Af, Ab} {
+		var eigen mat.Eigen
+		if !eigen.Factorize(dynamics, false, false) {
+			t.Fatal("Eigenvalue decomposition failed")
+		}
+		for _, value := range eigen.Values(nil) {
+			if math.Hypot(real(value), imag(value)) >= 1 {
+				t.Errorf("Unstable filter with eigenvalue %v", value)
+			}
+		}
+	}
+
+	if _, err := NewDiscreteTimeReconstructor(ctrl, &measurementNoiseCovariance, []float64{1., 1.}, *sm); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch but got %v", err)
+	}
+}
+
+func TestDiscreteTimeReconstructionObservation(t *testing.T) {
+	// Three states but only the first and last are controlled and observed
+	N := 3
+	b := mat.NewVecDense(N, []float64{chainGain, 0, 0})
+	frequency := 50.
+	sig := func(arg1 float64) float64 { return 0.5 * math.Sin(math.Pi*2.*arg1*frequency) }
+	A := mat.NewDense(N, N, []float64{0, 0, 0, 10 * chainGain, -chainGain, 0, 0, chainGain / 10., 0})
+	C := mat.NewDense(2, N, []float64{1, 0, 0, 0, 0, 1})
+	sm := ssm.NewLinearStateSpaceModel(A, C, []signal.VectorFunction{signal.NewInput(sig, b)})
+	controls := []mat.Vector{
+		mat.NewVecDense(N, []float64{-chainGain, 0, 0}),
+		mat.NewVecDense(N, []float64{0, 0, -chainGain}),
+	}
+	length := 4000
+	ctrl := control.NewAnalogSwitchControl(length, controls, chainTs, 0, nil, sm)
+	if err := ctrl.SetObservation(C); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctrl.UseExactDiscretization(ssm.ZeroOrderHold); err != nil {
+		t.Fatal(err)
+	}
+	ctrl.Simulate()
+
+	var inputNoiseCovariance, measurementNoiseCovariance mat.Dense
+	inputNoiseCovariance.Outer(1., b, b)
+	measurementNoiseCovariance.Scale(1e-2, gonumExtensions.Eye(2, 2, 0))
+	continuous, err := NewSteadyStateReconstructor(ctrl, &measurementNoiseCovariance, &inputNoiseCovariance, *sm).ReconstructionChecked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := NewDiscreteTimeReconstructor(ctrl, &measurementNoiseCovariance, []float64{1.}, *sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discrete, err := rec.ReconstructionChecked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, estimate := range [][][]float64{continuous, discrete} {
+		values := make([]float64, 0, length)
+		for index := length / 10; index < length-length/10; index++ {
+			values = append(values, estimate[index][0])
+		}
+		snr, _, err := signal.SNR(values, chainTs, frequency, 500)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snr < 40 {
+			t.Errorf("SNR %v dB from two observations", snr)
+		}
+	}
+}
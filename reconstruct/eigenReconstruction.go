@@ -0,0 +1,313 @@
+package reconstruct
+
+import (
+	"errors"
+	"math"
+	"math/cmplx"
+	"sync"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+// eigenReconstruction is the steady state reconstruction where the forward and
+// backward state dynamics are diagonalized, Af = Vf Lf Vf^(-1). In the modal
+// coordinates z = V^(-1) x each mode is a scalar complex IIR filter
+//
+//	z[k+1] = lambda z[k] + t^T s[k]
+//
+// where t^T is the corresponding row of V^(-1). The modes are computed
+// independently and each mode is further split into chunks of time.
+type eigenReconstruction struct {
+	// Forward and backward steady state dynamics
+	Af, Ab mat.Dense
+	// Forward and backward modes
+	forward, backward modes
+	// Number of time chunks per mode
+	chunks int
+	// Control interface
+	control control.Control
+}
+
+// modes is the modal decomposition of state dynamics together with the input
+// weights W such that the estimate contribution is W^T V z.
+type modes struct {
+	// Eigenvalues
+	values []complex128
+	// Rows of V^(-1)
+	inputs [][]complex128
+	// outputs[input][mode] = (W^T V)[input][mode]
+	outputs [][]complex128
+}
+
+// NewEigenReconstructor returns a steady state reconstructor computing the same
+// estimates as NewSteadyStateReconstructor by diagonalizing the forward and
+// backward state dynamics. Each mode is filtered in its own goroutines and split
+// into chunks of time, chunks < 1 corresponds to a single chunk. An error is
+// returned if the state dynamics are not diagonalizable.
+func NewEigenReconstructor(cont control.Control, measurementNoiseCovariance, inputNoiseCovariance mat.Matrix, linearStateSpaceModel ssm.LinearStateSpaceModel, chunks int) (Reconstruction, error) {
+	rec, err := NewSteadyStateReconstructorChecked(cont, measurementNoiseCovariance, inputNoiseCovariance, linearStateSpaceModel)
+	if err != nil {
+		return nil, err
+	}
+	steadyState := rec.(*steadyStateReconstruction)
+	forward, err := modalDecomposition(&steadyState.Af, &steadyState.W)
+	if err != nil {
+		return nil, err
+	}
+	backward, err := modalDecomposition(&steadyState.Ab, &steadyState.W)
+	if err != nil {
+		return nil, err
+	}
+	if chunks < 1 {
+		chunks = 1
+	}
+	return &eigenReconstruction{
+		Af:       steadyState.Af,
+		Ab:       steadyState.Ab,
+		forward:  forward,
+		backward: backward,
+		chunks:   chunks,
+		control:  cont,
+	}, nil
+}
+
+// Reconstruction returns the reconstructed estimates based on the associated
+// control interface.
+//
+// The returned data structure is [number of time indices][number of estimates]float64
+//
+// Panics if the reconstruction fails, see ReconstructionChecked.
+func (rec *eigenReconstruction) Reconstruction() [][]float64 {
+	res, err := rec.ReconstructionChecked()
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// ReconstructionChecked is Reconstruction returning an error, e.g.,
+// control.ErrNotPrecomputed, if the control can't provide the filter
+// contributions.
+func (rec *eigenReconstruction) ReconstructionChecked() ([][]float64, error) {
+	n := rec.control.GetLength()
+	if n == 0 {
+		return nil, control.ErrIndexOutOfRange
+	}
+
+	// The control contributions are collected first since the controls are
+	// not safe for concurrent use.
+	forwardContributions := make([]mat.Vector, n-1)
+	backwardContributions := make([]mat.Vector, n-1)
+	for index := 0; index < n-1; index++ {
+		var err error
+		if forwardContributions[index], err = rec.control.GetForwardControlFilterContribution(index); err != nil {
+			return nil, err
+		}
+		// The backward recursion runs in reversed time
+		if backwardContributions[n-2-index], err = rec.control.GetBackwardControlFilterContribution(index); err != nil {
+			return nil, err
+		}
+	}
+
+	forwardModes := make([][]complex128, len(rec.forward.values))
+	backwardModes := make([][]complex128, len(rec.backward.values))
+	var wg sync.WaitGroup
+	for _, direction := range []struct {
+		modes         modes
+		contributions []mat.Vector
+		res           [][]complex128
+	}{
+		{rec.forward, forwardContributions, forwardModes},
+		{rec.backward, backwardContributions, backwardModes},
+	} {
+		for mode := range direction.res {
+			wg.Add(1)
+			go func(modes modes, contributions []mat.Vector, res [][]complex128, mode int) {
+				defer wg.Done()
+				res[mode] = modalFilter(modes.values[mode], modes.inputs[mode], contributions, rec.chunks)
+			}(direction.modes, direction.contributions, direction.res, mode)
+		}
+	}
+	wg.Wait()
+
+	// estimate = W^T (Vf zf - Vb zb), computed in chunks of time
+	numberOfInputs := len(rec.forward.outputs)
+	estimate := make([][]float64, n)
+	size := (n + rec.chunks - 1) / rec.chunks
+	for start := 0; start < n; start += size {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for index := start; index < end; index++ {
+				estimate[index] = make([]float64, numberOfInputs)
+				for inp := range estimate[index] {
+					var sum complex128
+					for mode, z := range forwardModes {
+						sum += rec.forward.outputs[inp][mode] * z[index]
+					}
+					for mode, z := range backwardModes {
+						sum -= rec.backward.outputs[inp][mode] * z[n-1-index]
+					}
+					estimate[index][inp] = real(sum)
+				}
+			}
+		}(start, int(math.Min(float64(start+size), float64(n))))
+	}
+	wg.Wait()
+	return estimate, nil
+}
+
+// GetStateDynamics returns copies of the discrete forward and backward state
+// dynamics Af = e^(Adf Ts) and Ab = e^(Adb Ts).
+func (rec *eigenReconstruction) GetStateDynamics() (Af, Ab *mat.Dense) {
+	return mat.DenseCopyOf(&rec.Af), mat.DenseCopyOf(&rec.Ab)
+}
+
+// modalFilter returns z[0] = 0, z[k+1] = value z[k] + input^T contributions[k]
+// of length len(contributions) + 1. The time is split into chunks which are
+// filtered concurrently from a zero initial state and then corrected with the
+// true initial state of each chunk.
+func modalFilter(value complex128, input []complex128, contributions []mat.Vector, chunks int) []complex128 {
+	res := make([]complex128, len(contributions)+1)
+	if len(contributions) == 0 {
+		return res
+	}
+	size := (len(contributions) + chunks - 1) / chunks
+	var wg sync.WaitGroup
+	for start := 0; start < len(contributions); start += size {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			var state complex128
+			for index := start; index < end; index++ {
+				state *= value
+				for row, weight := range input {
+					state += weight * complex(contributions[index].AtVec(row), 0)
+				}
+				res[index+1] = state
+			}
+		}(start, int(math.Min(float64(start+size), float64(len(contributions)))))
+	}
+	wg.Wait()
+
+	// Propagate the last state of each chunk into the following chunk
+	power := cmplx.Pow(value, complex(float64(size), 0))
+	initialStates := []complex128{0}
+	for start := size; start < len(contributions); start += size {
+		previous := initialStates[len(initialStates)-1]
+		initialStates = append(initialStates, res[start]+power*previous)
+	}
+	for chunk, initialState := range initialStates[1:] {
+		start := (chunk + 1) * size
+		wg.Add(1)
+		go func(start, end int, state complex128) {
+			defer wg.Done()
+			for index := start; index < end; index++ {
+				state *= value
+				res[index+1] += state
+			}
+		}(start, int(math.Min(float64(start+size), float64(len(contributions)))), initialState)
+	}
+	wg.Wait()
+	return res
+}
+
+// modalDecomposition diagonalizes the dynamics and returns the modes
+func modalDecomposition(dynamics, W mat.Matrix) (modes, error) {
+	var eigen mat.Eigen
+	if !eigen.Factorize(dynamics, false, true) {
+		return modes{}, errors.New("Eigenvalue decomposition failed")
+	}
+	values := eigen.Values(nil)
+	realVectors := eigen.Vectors()
+
+	// Complex conjugate pairs are stored as real and imaginary parts in
+	// consecutive columns
+	order := len(values)
+	vectors := make([][]complex128, order)
+	for row := range vectors {
+		vectors[row] = make([]complex128, order)
+		for column := 0; column < order; column++ {
+			if imag(values[column]) == 0 {
+				vectors[row][column] = complex(realVectors.At(row, column), 0)
+				continue
+			}
+			vectors[row][column] = complex(realVectors.At(row, column), realVectors.At(row, column+1))
+			vectors[row][column+1] = complex(realVectors.At(row, column), -realVectors.At(row, column+1))
+			column++
+		}
+	}
+
+	inverse, err := complexInverse(vectors)
+	if err != nil {
+		return modes{}, errors.New("State dynamics are not diagonalizable")
+	}
+
+	_, numberOfInputs := W.Dims()
+	outputs := make([][]complex128, numberOfInputs)
+	for inp := range outputs {
+		outputs[inp] = make([]complex128, order)
+		for mode := range outputs[inp] {
+			for row := 0; row < order; row++ {
+				outputs[inp][mode] += complex(W.At(row, inp), 0) * vectors[row][mode]
+			}
+		}
+	}
+	return modes{
+		values:  values,
+		inputs:  inverse,
+		outputs: outputs,
+	}, nil
+}
+
+// complexInverse inverts a square complex matrix by Gauss-Jordan elimination
+// with partial pivoting. An error is returned if the matrix is numerically
+// singular.
+func complexInverse(a [][]complex128) ([][]complex128, error) {
+	n := len(a)
+	// augmented [a | I]
+	augmented := make([][]complex128, n)
+	for row := range augmented {
+		augmented[row] = make([]complex128, 2*n)
+		copy(augmented[row], a[row])
+		augmented[row][n+row] = 1
+	}
+	norm := 0.
+	for row := range a {
+		for _, value := range a[row] {
+			norm = math.Max(norm, cmplx.Abs(value))
+		}
+	}
+	for column := 0; column < n; column++ {
+		pivot := column
+		for row := column + 1; row < n; row++ {
+			if cmplx.Abs(augmented[row][column]) > cmplx.Abs(augmented[pivot][column]) {
+				pivot = row
+			}
+		}
+		if cmplx.Abs(augmented[pivot][column]) <= 1e-12*norm {
+			return nil, errors.New("Matrix is singular")
+		}
+		augmented[column], augmented[pivot] = augmented[pivot], augmented[column]
+		scale := 1 / augmented[column][column]
+		for entry := range augmented[column] {
+			augmented[column][entry] *= scale
+		}
+		for row := 0; row < n; row++ {
+			if row == column || augmented[row][column] == 0 {
+				continue
+			}
+			factor := augmented[row][column]
+			for entry := range augmented[row] {
+				augmented[row][entry] -= factor * augmented[column][entry]
+			}
+		}
+	}
+	inverse := make([][]complex128, n)
+	for row := range inverse {
+		inverse[row] = augmented[row][n:]
+	}
+	return inverse, nil
+}
@@ -0,0 +1,66 @@
+package reconstruct
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hammal/adc/gonumExtensions"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestEigenReconstruction(t *testing.T) {
+	N := 5
+	sig := func(arg1 float64) float64 { return 0.5 * math.Sin(math.Pi*2.*arg1*100.) }
+	sm, b, controls := integratorChain(N, sig)
+	length := 1000
+	ctrl := simulateChain(t, sm, controls, length)
+
+	var inputNoiseCovariance mat.Dense
+	inputNoiseCovariance.Outer(1., b, b)
+	reference := NewSteadyStateReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), &inputNoiseCovariance, *sm).Reconstruction()
+
+	for _, chunks := range []int{1, 4, 7} {
+		rec, err := NewEigenReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), &inputNoiseCovariance, *sm, chunks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := rec.ReconstructionChecked()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != length {
+			t.Fatalf("Reconstructed %v samples", len(res))
+		}
+		for index := range res {
+			if math.Abs(res[index][0]-reference[index][0]) > 1e-8 {
+				t.Fatalf("Estimate %v at index %v with %v chunks differs from %v", res[index][0], index, chunks, reference[index][0])
+			}
+		}
+	}
+}
+
+func TestComplexInverse(t *testing.T) {
+	a := [][]complex128{{1 + 1i, 2}, {0, 1i}}
+	inverse, err := complexInverse(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for row := range a {
+		for column := range a {
+			var sum complex128
+			for k := range a {
+				sum += a[row][k] * inverse[k][column]
+			}
+			expected := complex(0, 0)
+			if row == column {
+				expected = 1
+			}
+			if math.Abs(real(sum-expected))+math.Abs(imag(sum-expected)) > 1e-12 {
+				t.Errorf("(A A^(-1))[%v][%v] = %v", row, column, sum)
+			}
+		}
+	}
+	if _, err := complexInverse([][]complex128{{1, 2}, {2, 4}}); err == nil {
+		t.Error("Expected an error for a singular matrix")
+	}
+}
@@ -0,0 +1,285 @@
+package reconstruct
+
+import (
+	"errors"
+	"math"
+
+	"github.com/hammal/adc/control"
+	"gonum.org/v1/gonum/mat"
+)
+
+// FIRFilter is the steady state reconstruction expressed as a finite impulse
+// response filter over the control decisions. With s_c[k] = +-1 the decision
+// of control c at time index k the estimate is
+//
+//	u[k] = sum_c sum_j Forward[c][j] s_c[k-1-j] + Backward[c][j] s_c[k+j]
+//
+// where the coefficients are [number of inputs][number of controls][taps].
+type FIRFilter struct {
+	// Coefficients of the past control decisions k-1, ..., k-K1
+	Forward [][][]float64
+	// Coefficients of the control decisions k, ..., k+K2-1
+	Backward [][][]float64
+}
+
+// NewFIRFilter returns the FIR filter of a steady state reconstruction
+// truncated to K1 past and K2 present and future control decisions. The control
+// of the reconstruction must be a control.StreamingControl whose filter
+// contributions are linear in the control decisions.
+func NewFIRFilter(rec Reconstruction, K1, K2 int) (*FIRFilter, error) {
+	if K1 < 0 || K2 < 0 {
+		return nil, errors.New("Number of filter coefficients can't be negative")
+	}
+	steadyState, forwardVectors, backwardVectors, err := firVectors(rec)
+	if err != nil {
+		return nil, err
+	}
+	return &FIRFilter{
+		Forward:  firCoefficients(&steadyState.Af, &steadyState.W, forwardVectors, 1, K1, 0),
+		Backward: firCoefficients(&steadyState.Ab, &steadyState.W, backwardVectors, -1, K2, 0),
+	}, nil
+}
+
+// Filter convolves the control decision code words with the filter and
+// returns the estimates as [number of time indices][number of estimates]float64.
+// Control decisions outside of the record are assumed to be zero.
+func (fir *FIRFilter) Filter(decisions []uint) ([][]float64, error) {
+	numberOfInputs := len(fir.Forward)
+	numberOfControls := 0
+	if numberOfInputs > 0 {
+		numberOfControls = len(fir.Forward[0])
+	}
+
+	// s[c][k] = +-1
+	decisionSequences := make([][]float64, numberOfControls)
+	for c := range decisionSequences {
+		decisionSequences[c] = make([]float64, len(decisions))
+	}
+	for index, codeWord := range decisions {
+		if codeWord >= 1<<uint(numberOfControls) {
+			return nil, control.ErrIndexOutOfRange
+		}
+		for c := range decisionSequences {
+			decisionSequences[c][index] = 2*float64((codeWord>>uint(c))&1) - 1
+		}
+	}
+
+	res := make([][]float64, len(decisions))
+	for index := range res {
+		res[index] = make([]float64, numberOfInputs)
+		for inp := range res[index] {
+			sum := 0.
+			for c, sequence := range decisionSequences {
+				for tap, coefficient := range fir.Forward[inp][c] {
+					if index-1-tap < 0 {
+						break
+					}
+					sum += coefficient * sequence[index-1-tap]
+				}
+				for tap, coefficient := range fir.Backward[inp][c] {
+					if index+tap >= len(sequence) {
+						break
+					}
+					sum += coefficient * sequence[index+tap]
+				}
+			}
+			res[index][inp] = sum
+		}
+	}
+	return res, nil
+}
+
+// firReconstruction is a reconstruction convolving the control decisions with
+// an FIR filter.
+type firReconstruction struct {
+	filter  *FIRFilter
+	control control.StreamingControl
+	// state dynamics of the steady state reconstruction
+	Af, Ab mat.Dense
+}
+
+// NewFIRReconstructor returns a reconstruction that convolves the control
+// decisions with the FIR filter of the steady state reconstruction rec, see
+// NewFIRFilter.
+func NewFIRReconstructor(rec Reconstruction, K1, K2 int) (Reconstruction, error) {
+	filter, err := NewFIRFilter(rec, K1, K2)
+	if err != nil {
+		return nil, err
+	}
+	steadyState := rec.(*steadyStateReconstruction)
+	return &firReconstruction{
+		filter:  filter,
+		control: steadyState.control.(control.StreamingControl),
+		Af:      steadyState.Af,
+		Ab:      steadyState.Ab,
+	}, nil
+}
+
+// Reconstruction returns the reconstructed estimates based on the associated
+// control interface.
+//
+// Panics if the reconstruction fails, see ReconstructionChecked.
+func (rec *firReconstruction) Reconstruction() [][]float64 {
+	res, err := rec.ReconstructionChecked()
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// ReconstructionChecked is Reconstruction returning an error
+func (rec *firReconstruction) ReconstructionChecked() ([][]float64, error) {
+	if rec.control.GetLength() == 0 {
+		return nil, control.ErrIndexOutOfRange
+	}
+	return rec.filter.Filter(rec.control.GetControlDecisions())
+}
+
+// GetStateDynamics returns copies of the state dynamics of the steady state
+// reconstruction the filter was derived from.
+func (rec *firReconstruction) GetStateDynamics() (Af, Ab *mat.Dense) {
+	return mat.DenseCopyOf(&rec.Af), mat.DenseCopyOf(&rec.Ab)
+}
+
+// FIRTruncation describes the error of truncating the steady state
+// reconstruction to an FIR filter, per input.
+type FIRTruncation struct {
+	// Worst case absolute error, i.e., the sum of the magnitudes of the
+	// neglected coefficients
+	WorstCase []float64
+	// Energy of the neglected coefficients relative to the energy of all
+	// coefficients
+	RelativeEnergy []float64
+	// Number of coefficients until the impulse responses have decayed, past
+	// and present respectively
+	Length1, Length2 int
+}
+
+// TruncationError returns the error of truncating the steady state
+// reconstruction rec to K1 past and K2 present and future control decisions,
+// see NewFIRFilter. The neglected coefficients are summed until the impulse
+// responses have decayed by a factor of tolerance.
+func TruncationError(rec Reconstruction, K1, K2 int, tolerance float64) (*FIRTruncation, error) {
+	if K1 < 0 || K2 < 0 {
+		return nil, errors.New("Number of filter coefficients can't be negative")
+	}
+	if tolerance <= 0 || tolerance >= 1 {
+		return nil, errors.New("Tolerance must be in the interval (0, 1)")
+	}
+	steadyState, forwardVectors, backwardVectors, err := firVectors(rec)
+	if err != nil {
+		return nil, err
+	}
+	// Upper bound on the length to guard against marginally stable filters
+	maximumLength := 1 << 20
+	forward := firCoefficients(&steadyState.Af, &steadyState.W, forwardVectors, 1, maximumLength, tolerance)
+	backward := firCoefficients(&steadyState.Ab, &steadyState.W, backwardVectors, -1, maximumLength, tolerance)
+
+	res := &FIRTruncation{
+		WorstCase:      make([]float64, len(forward)),
+		RelativeEnergy: make([]float64, len(forward)),
+	}
+	for inp := range forward {
+		var total, neglected float64
+		for _, part := range []struct {
+			coefficients [][]float64
+			K            int
+		}{
+			{forward[inp], K1},
+			{backward[inp], K2},
+		} {
+			for _, taps := range part.coefficients {
+				for tap, coefficient := range taps {
+					total += coefficient * coefficient
+					if tap >= part.K {
+						neglected += coefficient * coefficient
+						res.WorstCase[inp] += math.Abs(coefficient)
+					}
+				}
+			}
+		}
+		if total > 0 {
+			res.RelativeEnergy[inp] = neglected / total
+		}
+	}
+	if len(forward) > 0 && len(forward[0]) > 0 {
+		res.Length1 = len(forward[0][0])
+		res.Length2 = len(backward[0][0])
+	}
+	return res, nil
+}
+
+// firVectors returns the steady state reconstruction of rec together with the
+// forward and backward filter contributions of each control, i.e., the
+// contribution of s_c = 1.
+func firVectors(rec Reconstruction) (*steadyStateReconstruction, []mat.Vector, []mat.Vector, error) {
+	steadyState, ok := rec.(*steadyStateReconstruction)
+	if !ok {
+		return nil, nil, nil, errors.New("FIR filters require a steady state reconstruction")
+	}
+	cont, ok := steadyState.control.(control.StreamingControl)
+	if !ok {
+		return nil, nil, nil, errors.New("FIR filters require a control.StreamingControl")
+	}
+	forwardVectors := make([]mat.Vector, cont.GetNumberOfControls())
+	backwardVectors := make([]mat.Vector, cont.GetNumberOfControls())
+	for c := range forwardVectors {
+		// The contribution is linear in s_c, hence (f(s_c = 1) - f(s_c = -1)) / 2
+		for _, vectors := range []struct {
+			res    []mat.Vector
+			lookUp func(uint) (mat.Vector, error)
+		}{
+			{forwardVectors, cont.GetForwardCodeWordContribution},
+			{backwardVectors, cont.GetBackwardCodeWordContribution},
+		} {
+			positive, err := vectors.lookUp(1 << uint(c))
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			negative, err := vectors.lookUp(0)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			var tmp mat.VecDense
+			tmp.SubVec(positive, negative)
+			tmp.ScaleVec(0.5, &tmp)
+			vectors.res[c] = &tmp
+		}
+	}
+	return steadyState, forwardVectors, backwardVectors, nil
+}
+
+// firCoefficients returns the impulse responses scale W^T dynamics^j v_c as
+// [number of inputs][number of controls][taps] with at most length taps. For a
+// positive tolerance the impulse responses end once all dynamics^j v_c have
+// decayed by tolerance.
+func firCoefficients(dynamics, W mat.Matrix, vectors []mat.Vector, scale float64, length int, tolerance float64) [][][]float64 {
+	_, numberOfInputs := W.Dims()
+	res := make([][][]float64, numberOfInputs)
+	for inp := range res {
+		res[inp] = make([][]float64, len(vectors))
+	}
+	states := make([]*mat.VecDense, len(vectors))
+	initialNorm := 0.
+	for c, vector := range vectors {
+		states[c] = mat.VecDenseCopyOf(vector)
+		initialNorm = math.Max(initialNorm, mat.Norm(vector, 2))
+	}
+
+	var coefficients mat.VecDense
+	for tap := 0; tap < length; tap++ {
+		norm := 0.
+		for c, state := range states {
+			coefficients.MulVec(W.T(), state)
+			for inp := range res {
+				res[inp][c] = append(res[inp][c], scale*coefficients.AtVec(inp))
+			}
+			norm = math.Max(norm, mat.Norm(state, 2))
+			state.MulVec(dynamics, state)
+		}
+		if tolerance > 0 && norm <= tolerance*initialNorm {
+			break
+		}
+	}
+	return res
+}
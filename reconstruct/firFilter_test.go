@@ -0,0 +1,85 @@
+package reconstruct
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hammal/adc/gonumExtensions"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestFIRFilter(t *testing.T) {
+	N := 3
+	sig := func(arg1 float64) float64 { return 0.5 * math.Sin(math.Pi*2.*arg1*100.) }
+	sm, b, controls := integratorChain(N, sig)
+	length := 500
+	ctrl := simulateChain(t, sm, controls, length)
+
+	var inputNoiseCovariance mat.Dense
+	inputNoiseCovariance.Outer(1., b, b)
+	rec := NewSteadyStateReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), &inputNoiseCovariance, *sm)
+	reference := rec.Reconstruction()
+
+	truncation, err := TruncationError(rec, 0, 0, 1e-12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncation.Length1 >= length/2 || truncation.Length2 >= length/2 {
+		t.Fatalf("Impulse responses of length %v and %v are too long for the test", truncation.Length1, truncation.Length2)
+	}
+
+	// Without truncation the filter equals the reconstruction, except at the
+	// end where the last control decision isn't used by the reconstruction.
+	full, err := NewFIRReconstructor(rec, truncation.Length1, truncation.Length2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := full.Reconstruction()
+	for index := 0; index < length-truncation.Length2; index++ {
+		if math.Abs(res[index][0]-reference[index][0]) > 1e-9 {
+			t.Fatalf("Estimate %v at index %v differs from %v", res[index][0], index, reference[index][0])
+		}
+	}
+
+	// The error of a truncated filter is bounded by the worst case error and
+	// decreases with the length.
+	previous := math.Inf(1)
+	for _, K := range []int{5, 10, 20} {
+		truncation, err := TruncationError(rec, K, K, 1e-12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if truncation.WorstCase[0] >= previous || truncation.RelativeEnergy[0] >= 1 {
+			t.Errorf("Truncation error %v doesn't decrease with K = %v", truncation.WorstCase[0], K)
+		}
+		previous = truncation.WorstCase[0]
+
+		filter, err := NewFIRFilter(rec, K, K)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(filter.Forward) != 1 || len(filter.Forward[0]) != N || len(filter.Backward[0][0]) != K {
+			t.Fatal("Unexpected filter dimensions")
+		}
+		res, err := filter.Filter(ctrl.GetControlDecisions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for index := truncation.Length1; index < length-truncation.Length2; index++ {
+			if math.Abs(res[index][0]-reference[index][0]) > truncation.WorstCase[0]+1e-9 {
+				t.Fatalf("Error at index %v exceeds the worst case %v", index, truncation.WorstCase[0])
+			}
+		}
+	}
+
+	if _, err := NewFIRFilter(rec, -1, 1); err == nil {
+		t.Error("Expected an error for a negative number of coefficients")
+	}
+	tv, err := NewTimeVaryingReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), []float64{1.}, *sm, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFIRFilter(tv, 1, 1); err == nil {
+		t.Error("Expected an error for a time-varying reconstruction")
+	}
+}
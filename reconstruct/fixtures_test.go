@@ -0,0 +1,49 @@
+package reconstruct
+
+import (
+	"testing"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/signal"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	// chainGain is the stage gain of the integrator chain fixture
+	chainGain = 6250.
+	// chainTs is the sampling period of the integrator chain fixture
+	chainTs = 1. / 16000.
+)
+
+// chainControls returns a control per state of an integrator chain of
+// order N.
+func chainControls(N int) []mat.Vector {
+	controls := make([]mat.Vector, N)
+	for index := range controls {
+		tmp := mat.NewVecDense(N, nil)
+		tmp.SetVec(index, -chainGain)
+		controls[index] = tmp
+	}
+	return controls
+}
+
+// integratorChain returns an integrator chain of order N where sig enters
+// the first state through b, together with a control per state.
+func integratorChain(N int, sig func(float64) float64) (*ssm.LinearStateSpaceModel, *mat.VecDense, []mat.Vector) {
+	b := mat.NewVecDense(N, nil)
+	b.SetVec(0, chainGain)
+	sm := ssm.NewIntegratorChain(N, chainGain, []signal.VectorFunction{signal.NewInput(sig, b)})
+	return sm, b, chainControls(N)
+}
+
+// simulateChain simulates length samples of an exactly discretized control
+// of the model.
+func simulateChain(t *testing.T, sm *ssm.LinearStateSpaceModel, controls []mat.Vector, length int) *control.AnalogSwitchControl {
+	ctrl := control.NewAnalogSwitchControl(length, controls, chainTs, 0, nil, sm)
+	if err := ctrl.UseExactDiscretization(ssm.ZeroOrderHold); err != nil {
+		t.Fatal(err)
+	}
+	ctrl.Simulate()
+	return ctrl
+}
@@ -0,0 +1,7 @@
+package reconstruct
+
+import "github.com/hammal/adc/logging"
+
+// logger is used for all diagnostics of the package, silent by default. Set it
+// with logging.SetLogger("reconstruct", l).
+var logger = logging.Package("reconstruct")
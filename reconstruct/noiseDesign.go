@@ -0,0 +1,155 @@
+package reconstruct
+
+import (
+	"errors"
+	"math"
+	"math/cmplx"
+
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+// NoiseDesign holds the noise covariance matrices of a reconstruction designed
+// for a signal transfer function (STF) target. With unit input noise variances
+// and measurement noise covariance eta^2 I the STF and noise transfer function
+// (NTF) are
+//
+//	STF(f) = ||G(f)||^2 / (||G(f)||^2 + eta^2)
+//	NTF(f) = ||G(f)|| / (||G(f)||^2 + eta^2)
+//
+// where G(f) = C (j 2 pi f I - A)^(-1) B and ||G(f)||^2 is the squared
+// Frobenius norm divided by the number of inputs.
+type NoiseDesign struct {
+	// eta^2, the ratio of the measurement and input noise variances
+	Eta2 float64
+	// Measurement noise covariance eta^2 I
+	MeasurementNoiseCovariance *mat.Dense
+	// Input noise covariance sum_i b_i b_i^T
+	InputNoiseCovariance *mat.Dense
+	// Achieved bandwidth [Hz], i.e., the frequency where the STF is 1/2
+	Bandwidth float64
+}
+
+// DesignForBandwidth returns the noise design where the STF of the model is 1/2
+// (-6 dB) at bandwidth [Hz], i.e., eta^2 = ||G(bandwidth)||^2.
+func DesignForBandwidth(model ssm.LinearStateSpaceModel, bandwidth float64) (*NoiseDesign, error) {
+	return DesignForSTF(model, bandwidth, 0.5)
+}
+
+// DesignForSTF returns the noise design where the STF of the model equals stf,
+// in the interval (0, 1), at frequency [Hz].
+func DesignForSTF(model ssm.LinearStateSpaceModel, frequency, stf float64) (*NoiseDesign, error) {
+	if stf <= 0 || stf >= 1 {
+		return nil, errors.New("Signal transfer function target must be in the interval (0, 1)")
+	}
+	gain, err := transferFunctionGain(model, frequency)
+	if err != nil {
+		return nil, err
+	}
+	return newNoiseDesign(model, gain*(1-stf)/stf)
+}
+
+// DesignForNTF returns the noise design where the NTF of the model equals ntf at
+// frequency [Hz]. The target must be below 1 / ||G(frequency)||.
+func DesignForNTF(model ssm.LinearStateSpaceModel, frequency, ntf float64) (*NoiseDesign, error) {
+	gain, err := transferFunctionGain(model, frequency)
+	if err != nil {
+		return nil, err
+	}
+	eta2 := math.Sqrt(gain)/ntf - gain
+	if ntf <= 0 || eta2 <= 0 {
+		return nil, errors.New("Noise transfer function target isn't achievable at this frequency")
+	}
+	return newNoiseDesign(model, eta2)
+}
+
+// newNoiseDesign returns the noise design for eta2 including the achieved
+// bandwidth.
+func newNoiseDesign(model ssm.LinearStateSpaceModel, eta2 float64) (*NoiseDesign, error) {
+	if _, err := ssm.NewLinearStateSpaceModelChecked(model.A, model.C, model.Input); err != nil {
+		return nil, err
+	}
+	order := model.StateSpaceOrder()
+	observations := model.ObservationSpaceOrder()
+
+	design := &NoiseDesign{
+		Eta2:                       eta2,
+		MeasurementNoiseCovariance: mat.NewDense(observations, observations, nil),
+		InputNoiseCovariance:       mat.NewDense(order, order, nil),
+	}
+	for index := 0; index < observations; index++ {
+		design.MeasurementNoiseCovariance.Set(index, index, eta2)
+	}
+	for _, input := range model.Input {
+		var tmp mat.Dense
+		tmp.Outer(1, input.B, input.B)
+		design.InputNoiseCovariance.Add(design.InputNoiseCovariance, &tmp)
+	}
+
+	bandwidth, err := bandwidthOf(model, eta2)
+	if err != nil {
+		return nil, err
+	}
+	design.Bandwidth = bandwidth
+	return design, nil
+}
+
+// bandwidthOf returns the lowest frequency [Hz] where the STF falls below 1/2,
+// i.e., where ||G(f)||^2 = eta2. The frequencies are scanned logarithmically
+// relative to the norm of A and the crossing is refined by bisection.
+func bandwidthOf(model ssm.LinearStateSpaceModel, eta2 float64) (float64, error) {
+	scale := mat.Norm(model.A, 2) / (2 * math.Pi)
+	if scale == 0 {
+		scale = 1
+	}
+	points := 800
+	lowest, highest := 1e-4*scale, 1e4*scale
+	previous := lowest
+	previousGain, err := transferFunctionGain(model, previous)
+	if err != nil {
+		return 0, err
+	}
+	if previousGain < eta2 {
+		return 0, errors.New("Signal transfer function is below 1/2 at all frequencies")
+	}
+	for point := 1; point <= points; point++ {
+		frequency := lowest * math.Pow(highest/lowest, float64(point)/float64(points))
+		gain, err := transferFunctionGain(model, frequency)
+		if err != nil {
+			return 0, err
+		}
+		if gain < eta2 {
+			// Bisection in the logarithm of the frequency
+			low, high := previous, frequency
+			for iteration := 0; iteration < 100 && high-low > 1e-12*high; iteration++ {
+				middle := math.Sqrt(low * high)
+				if gain, err = transferFunctionGain(model, middle); err != nil {
+					return 0, err
+				}
+				if gain < eta2 {
+					high = middle
+				} else {
+					low = middle
+				}
+			}
+			return math.Sqrt(low * high), nil
+		}
+		previous = frequency
+	}
+	return 0, errors.New("Signal transfer function doesn't fall below 1/2")
+}
+
+// transferFunctionGain returns ||G(f)||^2, the squared Frobenius norm of
+// G(f) = C (j 2 pi f I - A)^(-1) B divided by the number of inputs.
+func transferFunctionGain(model ssm.LinearStateSpaceModel, frequency float64) (float64, error) {
+	if len(model.Input) == 0 {
+		return 0, errors.New("The model has no inputs")
+	}
+	gain := 0.
+	for _, observation := range model.FrequencyResponse([]float64{frequency}) {
+		for _, input := range observation {
+			gain += math.Pow(cmplx.Abs(input[0]), 2)
+		}
+	}
+	return gain / float64(len(model.Input)), nil
+}
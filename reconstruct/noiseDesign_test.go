@@ -0,0 +1,57 @@
+package reconstruct
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNoiseDesign(t *testing.T) {
+	N := 3
+	sm, _, _ := integratorChain(N, func(float64) float64 { return 0 })
+
+	// For an integrator chain ||G(f)||^2 = sum_k (beta / (2 pi f))^(2k)
+	gain := func(f float64) (res float64) {
+		for k := 1; k <= N; k++ {
+			res += math.Pow(chainGain/(2*math.Pi*f), 2*float64(k))
+		}
+		return
+	}
+
+	bandwidth := 1000.
+	design, err := DesignForBandwidth(*sm, bandwidth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(design.Eta2-gain(bandwidth)) > 1e-9*design.Eta2 {
+		t.Errorf("eta^2 = %v instead of %v", design.Eta2, gain(bandwidth))
+	}
+	if math.Abs(design.Bandwidth-bandwidth) > 1e-6*bandwidth {
+		t.Errorf("Achieved bandwidth %v instead of %v", design.Bandwidth, bandwidth)
+	}
+	if design.MeasurementNoiseCovariance.At(N-1, N-1) != design.Eta2 || design.InputNoiseCovariance.At(0, 0) != chainGain*chainGain {
+		t.Error("Wrong covariance matrices")
+	}
+
+	// STF and NTF targets are met at the design frequency
+	design, err = DesignForSTF(*sm, 100., 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stf := gain(100.) / (gain(100.) + design.Eta2); math.Abs(stf-0.99) > 1e-9 {
+		t.Errorf("STF = %v instead of 0.99", stf)
+	}
+	design, err = DesignForNTF(*sm, 100., 1e-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ntf := math.Sqrt(gain(100.)) / (gain(100.) + design.Eta2); math.Abs(ntf-1e-3) > 1e-12 {
+		t.Errorf("NTF = %v instead of 1e-3", ntf)
+	}
+
+	if _, err := DesignForSTF(*sm, 100., 1.); err == nil {
+		t.Error("Expected an error for an STF target of 1")
+	}
+	if _, err := DesignForNTF(*sm, 100., 1.); err == nil {
+		t.Error("Expected an error for an unachievable NTF target")
+	}
+}
@@ -2,10 +2,23 @@ package reconstruct
 
 import "gonum.org/v1/gonum/mat"
 
+// Reconstruction is implemented by all reconstructors such that they can be
+// used interchangeably.
 type Reconstruction interface {
-	// Runs the reconstruction and returns the result
-	Reconstruct() [][]*float64
+	// Runs the reconstruction and returns the result as
+	// [number of time indices][number of estimates]float64
+	Reconstruction() [][]float64
+
+	// Same as Reconstruction but returns an error instead of panicking
+	ReconstructionChecked() ([][]float64, error)
 
 	// Get State dynamics, used to pre-compute control decision vectors.
 	GetStateDynamics() (Af, Ab *mat.Dense)
 }
+
+// Compile-time checks that the reconstructors implement Reconstruction
+var (
+	_ Reconstruction = (*steadyStateReconstruction)(nil)
+	_ Reconstruction = (*TimeVaryingReconstruction)(nil)
+	_ Reconstruction = (*discreteTimeReconstruction)(nil)
+)
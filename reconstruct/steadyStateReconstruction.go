@@ -2,11 +2,11 @@ package reconstruct
 
 import (
 	// "fmt"
-	"fmt"
+	"errors"
 	"sync"
 
 	"github.com/hammal/adc/control"
-	"github.com/hammal/adc/gonumExtensions"
+	"github.com/hammal/adc/logging"
 	"github.com/hammal/adc/ssm"
 	"gonum.org/v1/gonum/mat"
 )
@@ -29,19 +29,46 @@ type steadyStateReconstruction struct {
 	forwardMessage []mat.VecDense
 	// BackwardMessage
 	backwardMessage []mat.VecDense
+	// first error of the message passing
+	err      error
+	errMutex sync.Mutex
 }
 
 // Reconstruction returns the reconstructed estimates based on the associated
 // control interface.
 //
 // The returned data structure is [number of time indices][number of estimates]*float64
+//
+// Panics if the reconstruction fails, see ReconstructionChecked.
 func (rec *steadyStateReconstruction) Reconstruction() [][]float64 {
+	res, err := rec.ReconstructionChecked()
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// ReconstructionChecked is Reconstruction returning an error, e.g.,
+// control.ErrNotPrecomputed, if the control can't provide the filter
+// contributions.
+func (rec *steadyStateReconstruction) ReconstructionChecked() ([][]float64, error) {
 
 	// Sync group for go routines
 	var wg sync.WaitGroup
 
 	// Number of estimates to produce
 	n := rec.control.GetLength()
+	if n == 0 {
+		return nil, control.ErrIndexOutOfRange
+	}
+	// Check that the control has the filter contributions
+	if _, err := rec.control.GetForwardControlFilterContribution(0); err != nil {
+		return nil, err
+	}
+	if _, err := rec.control.GetBackwardControlFilterContribution(0); err != nil {
+		return nil, err
+	}
+	rec.err = nil
 
 	// Initialize the state
 	rec.forwardMessage = make([]mat.VecDense, n)
@@ -124,7 +151,25 @@ func (rec *steadyStateReconstruction) Reconstruction() [][]float64 {
 
 	// Wait until all computations done
 	wg.Wait()
-	return rec.estimate
+	if rec.err != nil {
+		return nil, rec.err
+	}
+	return rec.estimate, nil
+}
+
+// GetStateDynamics returns copies of the discrete forward and backward state
+// dynamics Af = e^(Adf Ts) and Ab = e^(Adb Ts).
+func (rec *steadyStateReconstruction) GetStateDynamics() (Af, Ab *mat.Dense) {
+	return mat.DenseCopyOf(&rec.Af), mat.DenseCopyOf(&rec.Ab)
+}
+
+// setError keeps the first error of the message passing
+func (rec *steadyStateReconstruction) setError(err error) {
+	rec.errMutex.Lock()
+	defer rec.errMutex.Unlock()
+	if rec.err == nil {
+		rec.err = err
+	}
 }
 
 // forwardMessagePassing is a utility function that performs the forward message passing
@@ -139,9 +184,11 @@ func (rec *steadyStateReconstruction) forwardMessagePassing(wait *sync.WaitGroup
 		rec.forwardMessage[index+1].MulVec(&rec.Af, &rec.forwardMessage[index])
 		ctrl, err := rec.control.GetForwardControlFilterContribution(index)
 		if err != nil {
-			panic(err)
+			// Keep reporting such that the input estimation completes
+			rec.setError(err)
+		} else {
+			rec.forwardMessage[index+1].AddVec(&rec.forwardMessage[index+1], ctrl)
 		}
-		rec.forwardMessage[index+1].AddVec(&rec.forwardMessage[index+1], ctrl)
 		report <- index + 1
 	}
 }
@@ -159,9 +206,11 @@ func (rec *steadyStateReconstruction) backwardMessagePassing(wait *sync.WaitGrou
 		rec.backwardMessage[index-1].MulVec(&rec.Ab, &rec.backwardMessage[index])
 		ctrl, err := rec.control.GetBackwardControlFilterContribution(index - 1)
 		if err != nil {
-			panic(err)
+			// Keep reporting such that the input estimation completes
+			rec.setError(err)
+		} else {
+			rec.backwardMessage[index-1].AddVec(&rec.backwardMessage[index-1], ctrl)
 		}
-		rec.backwardMessage[index-1].AddVec(&rec.backwardMessage[index-1], ctrl)
 		report <- index - 1
 		// fmt.Printf("BackwardIndex %v\n", index-1)
 	}
@@ -198,7 +247,19 @@ func (rec *steadyStateReconstruction) inputEstimate(resindex int, sync *sync.Wai
 
 // NewSteadyStateReconstructor returns a Steady-state reconstructor based on the
 // control.
-func NewSteadyStateReconstructor(cont control.Control, measurementNoiseCovariance, inputNoiseCovariance mat.Matrix, linearStateSpaceModel ssm.LinearStateSpaceModel) *steadyStateReconstruction {
+//
+// Panics if the algebraic Riccati equations have no valid solution, see
+// NewSteadyStateReconstructorChecked.
+func NewSteadyStateReconstructor(cont control.Control, measurementNoiseCovariance, inputNoiseCovariance mat.Matrix, linearStateSpaceModel ssm.LinearStateSpaceModel) Reconstruction {
+	rec, err := newSteadyStateReconstruction(cont, measurementNoiseCovariance, inputNoiseCovariance, linearStateSpaceModel)
+	if err != nil {
+		panic(err)
+	}
+	return rec
+}
+
+// newSteadyStateReconstruction computes the steady state reconstruction
+func newSteadyStateReconstruction(cont control.Control, measurementNoiseCovariance, inputNoiseCovariance mat.Matrix, linearStateSpaceModel ssm.LinearStateSpaceModel) (*steadyStateReconstruction, error) {
 	// This function needs to do the following
 	// - Compute steady state covariance matrices
 	// 	- Compute filter state dynamics, forward and backward.
@@ -217,7 +278,8 @@ func NewSteadyStateReconstructor(cont control.Control, measurementNoiseCovarianc
 		tmpMatrix1                        mat.Dense
 		tmpMatrix2                        mat.Dense
 		W, Af, Ab                         mat.Dense
-		Vf, Vb                            mat.Matrix
+		Vf, Vb                            *mat.Dense
+		err                               error
 		R                                 mat.Dense
 		rec                               steadyStateReconstruction
 	)
@@ -228,23 +290,27 @@ func NewSteadyStateReconstructor(cont control.Control, measurementNoiseCovarianc
 	// Compute inverse measurement noise covariance
 	inverseMeasurementNoiseCovariance.Inverse(measurementNoiseCovariance)
 	// Solve forward and backward steady state covariance function
-	Vf = gonumExtensions.Eye(order, order, 0)
 	// careOption := Recursion{
 	// 	precision:  1e-1,
 	// 	stepLength: 5e-6,
 	// }
 
-	R.Mul(&inverseMeasurementNoiseCovariance, linearStateSpaceModel.C.T())
-	R.Mul(linearStateSpaceModel.C, &R)
+	// R = C^T Sigma_z^(-1) C
+	var observationWeight mat.Dense
+	observationWeight.Mul(linearStateSpaceModel.C.T(), &inverseMeasurementNoiseCovariance)
+	R.Mul(&observationWeight, linearStateSpaceModel.C)
 
-	Vf = care(linearStateSpaceModel.A.T(), &R, inputNoiseCovariance, Vf, MatrixFactorization{})
+	if Vf, err = SolveCARE(linearStateSpaceModel.A.T(), &R, inputNoiseCovariance); err != nil {
+		return nil, err
+	}
 	// Backward recursion with sign changes
-	Vb = gonumExtensions.Eye(order, order, 0)
 	tmpMatrix1.Scale(-1, linearStateSpaceModel.A.T())
 
-	Vb = care(&tmpMatrix1, &R, inputNoiseCovariance, Vb, MatrixFactorization{})
+	if Vb, err = SolveCARE(&tmpMatrix1, &R, inputNoiseCovariance); err != nil {
+		return nil, err
+	}
 
-	fmt.Printf("Solution To ARE is:\nVf =\n%v\nVb\n%v\n", mat.Formatted(Vf), mat.Formatted(Vb))
+	logging.Debug(logger, "Solution to ARE", logging.F("Vf", Vf), logging.F("Vb", Vb))
 
 	// Compute state dynamics
 	// Forward: (A - Vf C Sigma_z^(-1) C^T )
@@ -257,7 +323,7 @@ func NewSteadyStateReconstructor(cont control.Control, measurementNoiseCovarianc
 	tmpMatrix2.Add(linearStateSpaceModel.A, &tmpMatrix2)
 	BackwardStateDynamics.Scale(-1, &tmpMatrix2)
 
-	fmt.Printf("Forward and Backward Filter Dynamics are:\nAdf = \n%v\nAdb = \n%v\n", mat.Formatted(&ForwardStateDynamics), mat.Formatted(&BackwardStateDynamics))
+	logging.Debug(logger, "Forward and backward filter dynamics", logging.F("Adf", &ForwardStateDynamics), logging.F("Adb", &BackwardStateDynamics))
 
 	// Let control initialize the filter contributions
 	cont.PreComputeFilterContributions(&ForwardStateDynamics, &BackwardStateDynamics)
@@ -274,7 +340,9 @@ func NewSteadyStateReconstructor(cont control.Control, measurementNoiseCovarianc
 			tmpMatrix2.Set(row, index, -input.B.AtVec(row))
 		}
 	}
-	W.Solve(&tmpMatrix1, &tmpMatrix2)
+	if err = W.Solve(&tmpMatrix1, &tmpMatrix2); err != nil {
+		return nil, err
+	}
 
 	// Compute Af
 	Af.Scale(cont.GetTs(), &ForwardStateDynamics)
@@ -284,7 +352,7 @@ func NewSteadyStateReconstructor(cont control.Control, measurementNoiseCovarianc
 	Ab.Scale(cont.GetTs(), &BackwardStateDynamics)
 	Ab.Exp(&Ab)
 
-	fmt.Printf("PreComputed Matrix\nAf = \n%v\nAb = \n%v\n", mat.Formatted(&Af), mat.Formatted(&Ab))
+	logging.Debug(logger, "Precomputed matrices", logging.F("Af", &Af), logging.F("Ab", &Ab))
 
 	// Initialize steady state reconstruction instance
 	rec = steadyStateReconstruction{
@@ -294,7 +362,38 @@ func NewSteadyStateReconstructor(cont control.Control, measurementNoiseCovarianc
 		control: cont,
 	}
 
-	return &rec
+	return &rec, nil
+}
+
+// NewSteadyStateReconstructorChecked is NewSteadyStateReconstructor returning a
+// *ssm.DimensionError if the covariance matrices don't match the state space
+// model, an error if the measurement noise covariance isn't invertible and the
+// errors of SolveCARE if the algebraic Riccati equations have no valid
+// solution.
+func NewSteadyStateReconstructorChecked(cont control.Control, measurementNoiseCovariance, inputNoiseCovariance mat.Matrix, linearStateSpaceModel ssm.LinearStateSpaceModel) (Reconstruction, error) {
+	if cont == nil {
+		return nil, errors.New("A control is required for reconstruction")
+	}
+	if _, err := ssm.NewLinearStateSpaceModelChecked(linearStateSpaceModel.A, linearStateSpaceModel.C, linearStateSpaceModel.Input); err != nil {
+		return nil, err
+	}
+	order := linearStateSpaceModel.StateSpaceOrder()
+	observations := linearStateSpaceModel.ObservationSpaceOrder()
+	if m, n := measurementNoiseCovariance.Dims(); m != observations || n != observations {
+		return nil, &ssm.DimensionError{What: "Measurement noise covariance doesn't match the observation space order"}
+	}
+	if m, n := inputNoiseCovariance.Dims(); m != order || n != order {
+		return nil, &ssm.DimensionError{What: "Input noise covariance doesn't match the state space order"}
+	}
+	var inverse mat.Dense
+	if err := inverse.Inverse(measurementNoiseCovariance); err != nil {
+		return nil, err
+	}
+	rec, err := newSteadyStateReconstruction(cont, measurementNoiseCovariance, inputNoiseCovariance, linearStateSpaceModel)
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
 }
 
 type SafeLedger struct {
@@ -6,6 +6,8 @@ import (
 	"testing"
 
 	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/gonumExtensions"
+	"github.com/hammal/adc/logging"
 	"github.com/hammal/adc/signal"
 	"github.com/hammal/adc/ssm"
 	"gonum.org/v1/gonum/mat"
@@ -210,6 +212,35 @@ func TestNewSteadyStateReconstructorSwitchedCapactior(t *testing.T) {
 
 }
 
+func TestSteadyStateReconstructorLogging(t *testing.T) {
+	N := 3
+	sm, b, controls := integratorChain(N, func(arg1 float64) float64 { return 0.5 })
+	ctrl := simulateChain(t, sm, controls, 10)
+
+	var inputNoiseCovariance mat.Dense
+	inputNoiseCovariance.Outer(1., b, b)
+
+	recorder := &logging.Recorder{Level: logging.DebugLevel}
+	if err := logging.SetLogger("reconstruct", recorder); err != nil {
+		t.Fatal(err)
+	}
+	defer logging.SetLogger("reconstruct", nil)
+	NewSteadyStateReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), &inputNoiseCovariance, *sm)
+
+	found := false
+	for _, entry := range recorder.Entries() {
+		if Af, ok := entry.Fields["Af"]; ok {
+			found = true
+			if rows, _ := Af.(mat.Matrix).Dims(); rows != N {
+				t.Errorf("Captured Af has %v rows", rows)
+			}
+		}
+	}
+	if !found {
+		t.Error("Precomputed matrices were not logged")
+	}
+}
+
 // randomPoints returns some random x, y points.
 func plottify(data [][]float64) []plotter.XYs {
 	NumberOfSamples := len(data)
@@ -227,3 +258,74 @@ func plottify(data [][]float64) []plotter.XYs {
 	}
 	return res
 }
+
+func TestNewSteadyStateReconstructorChecked(t *testing.T) {
+	N := 2
+	b := mat.NewVecDense(N, []float64{1, 0})
+	sm := ssm.NewIntegratorChain(N, 1, []signal.VectorFunction{signal.NewInput(math.Sin, b)})
+	controls := []mat.Vector{mat.NewVecDense(N, []float64{-1, 0}), mat.NewVecDense(N, []float64{0, -1})}
+	ctrl := control.NewAnalogSwitchControl(10, controls, 1e-1, 0, nil, sm)
+
+	var inputNoiseCovariance mat.Dense
+	inputNoiseCovariance.Outer(1., b, b)
+
+	if _, err := NewSteadyStateReconstructorChecked(ctrl, gonumExtensions.Eye(3, 3, 0), &inputNoiseCovariance, *sm); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for the measurement noise covariance but got %v", err)
+	}
+	if _, err := NewSteadyStateReconstructorChecked(ctrl, mat.NewDense(N, N, nil), &inputNoiseCovariance, *sm); err == nil {
+		t.Error("Expected an error for a singular measurement noise covariance")
+	}
+	rec, err := NewSteadyStateReconstructorChecked(ctrl, gonumExtensions.Eye(N, N, 0), &inputNoiseCovariance, *sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := rec.ReconstructionChecked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 10 {
+		t.Errorf("Reconstructed %v samples", len(res))
+	}
+}
+
+func TestSteadyStateReconstructionInterface(t *testing.T) {
+	N := 3
+	sm, b, controls := integratorChain(N, math.Sin)
+	ctrl := simulateChain(t, sm, controls, 20)
+
+	var inputNoiseCovariance mat.Dense
+	inputNoiseCovariance.Outer(1., b, b)
+
+	var rec Reconstruction = NewSteadyStateReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), &inputNoiseCovariance, *sm)
+
+	Af, Ab := rec.GetStateDynamics()
+	for _, dynamics := range []*mat.Dense{Af, Ab} {
+		if rows, columns := dynamics.Dims(); rows != N || columns != N {
+			t.Fatalf("State dynamics are %v x %v", rows, columns)
+		}
+		// Both filters must be stable
+		var eigen mat.Eigen
+		if !eigen.Factorize(dynamics, false, false) {
+			t.Fatal("Eigenvalue decomposition failed")
+		}
+		for _, value := range eigen.Values(nil) {
+			if math.Hypot(real(value), imag(value)) >= 1 {
+				t.Errorf("Unstable filter with eigenvalue %v", value)
+			}
+		}
+	}
+
+	// The state dynamics are copies
+	Af.Set(0, 0, 100)
+	if tmp, _ := rec.GetStateDynamics(); tmp.At(0, 0) == 100 {
+		t.Error("GetStateDynamics exposes the internal state dynamics")
+	}
+
+	res, err := rec.ReconstructionChecked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != ctrl.GetLength() || len(res[0]) != 1 {
+		t.Errorf("Unexpected reconstruction dimensions %v x %v", len(res), len(res[0]))
+	}
+}
@@ -0,0 +1,137 @@
+package reconstruct
+
+import (
+	"errors"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+// StreamingReconstruction is a steady state reconstructor that consumes
+// control decisions incrementally. The backward message of each estimate is
+// computed by a backward recursion over a finite block of lookahead control
+// decisions instead of the whole record. Only the forward message and the
+// control decisions of the block are stored which bounds the memory
+// requirement regardless of the record length.
+type StreamingReconstruction struct {
+	// Forward and backward steady state dynamics
+	Af, Ab mat.Dense
+	// Input Matrix
+	W mat.Dense
+	// Control interface
+	control control.StreamingControl
+	// Number of control decisions used by the backward recursion
+	lookahead int
+	// error that ended the last stream
+	err error
+}
+
+// NewStreamingReconstructor returns a streaming reconstructor with the same
+// filter as NewSteadyStateReconstructor where each estimate uses lookahead
+// control decisions starting from its own time index. The estimate of time
+// index k is emitted once the control decision k + lookahead - 1 is received.
+func NewStreamingReconstructor(cont control.StreamingControl, measurementNoiseCovariance, inputNoiseCovariance mat.Matrix, linearStateSpaceModel ssm.LinearStateSpaceModel, lookahead int) (*StreamingReconstruction, error) {
+	if lookahead < 1 {
+		return nil, errors.New("Lookahead must be at least one control decision")
+	}
+	rec, err := NewSteadyStateReconstructorChecked(cont, measurementNoiseCovariance, inputNoiseCovariance, linearStateSpaceModel)
+	if err != nil {
+		return nil, err
+	}
+	steadyState := rec.(*steadyStateReconstruction)
+	return &StreamingReconstruction{
+		Af:        steadyState.Af,
+		Ab:        steadyState.Ab,
+		W:         steadyState.W,
+		control:   cont,
+		lookahead: lookahead,
+	}, nil
+}
+
+// Stream reconstructs the control decision code words received from decisions
+// and sends the estimates, [number of estimates]float64, in order on the
+// returned channel. The returned channel is closed after decisions has been
+// closed and the remaining estimates have been sent, or after an error, see
+// Err. In both cases decisions is drained such that the sender never blocks.
+func (rec *StreamingReconstruction) Stream(decisions <-chan uint) <-chan []float64 {
+	estimates := make(chan []float64, rec.lookahead)
+	rec.err = nil
+	go func() {
+		defer close(estimates)
+		m, _ := rec.Af.Dims()
+		// Forward message of the oldest control decision in the block
+		forwardMessage := mat.NewVecDense(m, nil)
+		block := make([]uint, 0, rec.lookahead)
+		for codeWord := range decisions {
+			block = append(block, codeWord)
+			if len(block) < rec.lookahead {
+				continue
+			}
+			if rec.err = rec.emit(forwardMessage, block, len(block), estimates); rec.err != nil {
+				for range decisions {
+				}
+				return
+			}
+			block = block[:copy(block, block[1:])]
+		}
+		// At the end of the stream the last control decision isn't used by the
+		// backward recursion, just as for the steady state reconstruction.
+		for len(block) > 0 {
+			if rec.err = rec.emit(forwardMessage, block, len(block)-1, estimates); rec.err != nil {
+				return
+			}
+			block = block[:copy(block, block[1:])]
+		}
+	}()
+	return estimates
+}
+
+// Err returns the error that ended the last stream, e.g.,
+// control.ErrIndexOutOfRange for an invalid code word. It should be called
+// after the estimate channel has been closed.
+func (rec *StreamingReconstruction) Err() error {
+	return rec.err
+}
+
+// GetStateDynamics returns copies of the discrete forward and backward state
+// dynamics Af = e^(Adf Ts) and Ab = e^(Adb Ts).
+func (rec *StreamingReconstruction) GetStateDynamics() (Af, Ab *mat.Dense) {
+	return mat.DenseCopyOf(&rec.Af), mat.DenseCopyOf(&rec.Ab)
+}
+
+// emit sends the estimate of the first control decision of block, where the
+// backward recursion covers the first length control decisions of block, and
+// advances the forward message past it.
+func (rec *StreamingReconstruction) emit(forwardMessage *mat.VecDense, block []uint, length int, estimates chan<- []float64) error {
+	m, _ := rec.Ab.Dims()
+	backwardMessage := mat.NewVecDense(m, nil)
+	for index := length - 1; index >= 0; index-- {
+		ctrl, err := rec.control.GetBackwardCodeWordContribution(block[index])
+		if err != nil {
+			return err
+		}
+		backwardMessage.MulVec(&rec.Ab, backwardMessage)
+		backwardMessage.AddVec(backwardMessage, ctrl)
+	}
+
+	// estimate = W^T (fm - bm)
+	var tmp, res mat.VecDense
+	tmp.SubVec(forwardMessage, backwardMessage)
+	res.MulVec(rec.W.T(), &tmp)
+	_, nrInputs := rec.W.Dims()
+	estimate := make([]float64, nrInputs)
+	for inp := range estimate {
+		estimate[inp] = res.AtVec(inp)
+	}
+
+	ctrl, err := rec.control.GetForwardCodeWordContribution(block[0])
+	if err != nil {
+		return err
+	}
+	forwardMessage.MulVec(&rec.Af, forwardMessage)
+	forwardMessage.AddVec(forwardMessage, ctrl)
+
+	estimates <- estimate
+	return nil
+}
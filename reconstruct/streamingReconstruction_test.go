@@ -0,0 +1,104 @@
+package reconstruct
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/gonumExtensions"
+	"github.com/hammal/adc/signal"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestStreamingReconstruction(t *testing.T) {
+	N := 3
+	sig := func(arg1 float64) float64 { return 0.5 * math.Sin(math.Pi*2.*arg1*100.) }
+	sm, b, controls := integratorChain(N, sig)
+	length := 500
+	ctrl := simulateChain(t, sm, controls, length)
+
+	var inputNoiseCovariance mat.Dense
+	inputNoiseCovariance.Outer(1., b, b)
+	reference := NewSteadyStateReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), &inputNoiseCovariance, *sm).Reconstruction()
+
+	// stream runs a streaming reconstruction of the simulated control decisions
+	stream := func(lookahead int) [][]float64 {
+		rec, err := NewStreamingReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), &inputNoiseCovariance, *sm, lookahead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decisions := make(chan uint)
+		go func() {
+			defer close(decisions)
+			for _, codeWord := range ctrl.GetControlDecisions() {
+				decisions <- codeWord
+			}
+		}()
+		var res [][]float64
+		for estimate := range rec.Stream(decisions) {
+			res = append(res, estimate)
+		}
+		if err := rec.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != length {
+			t.Fatalf("Streamed %v estimates instead of %v", len(res), length)
+		}
+		return res
+	}
+
+	// With a lookahead covering the whole record the reconstructions agree
+	for index, estimate := range stream(length) {
+		if math.Abs(estimate[0]-reference[index][0]) > 1e-9 {
+			t.Fatalf("Estimate %v at index %v differs from %v", estimate[0], index, reference[index][0])
+		}
+	}
+
+	// A finite lookahead only matters at the end of the window
+	maxError := 0.
+	for index, estimate := range stream(100) {
+		maxError = math.Max(maxError, math.Abs(estimate[0]-reference[index][0]))
+	}
+	if maxError > 1e-3 {
+		t.Errorf("Finite lookahead deviates by %v from the reconstruction", maxError)
+	}
+}
+
+func TestStreamingReconstructionErrors(t *testing.T) {
+	N := 2
+	b := mat.NewVecDense(N, []float64{1, 0})
+	sm := ssm.NewIntegratorChain(N, 1, []signal.VectorFunction{signal.NewInput(math.Sin, b)})
+	controls := []mat.Vector{mat.NewVecDense(N, []float64{-1, 0}), mat.NewVecDense(N, []float64{0, -1})}
+	ctrl := control.NewAnalogSwitchControl(10, controls, 1e-1, 0, nil, sm)
+
+	var inputNoiseCovariance mat.Dense
+	inputNoiseCovariance.Outer(1., b, b)
+
+	if _, err := NewStreamingReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), &inputNoiseCovariance, *sm, 0); err == nil {
+		t.Error("Expected an error for a zero lookahead")
+	}
+	rec, err := NewStreamingReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), &inputNoiseCovariance, *sm, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An invalid code word ends the stream without blocking the sender
+	decisions := make(chan uint)
+	go func() {
+		defer close(decisions)
+		for _, codeWord := range []uint{0, 1, 2, 3, 4, 0, 1, 2} {
+			decisions <- codeWord
+		}
+	}()
+	count := 0
+	for range rec.Stream(decisions) {
+		count++
+	}
+	if rec.Err() != control.ErrIndexOutOfRange {
+		t.Errorf("Expected control.ErrIndexOutOfRange but got %v", rec.Err())
+	}
+	if count != 3 {
+		t.Errorf("Expected the 3 estimates before the invalid code word but got %v", count)
+	}
+}
@@ -0,0 +1,360 @@
+package reconstruct
+
+import (
+	"errors"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+// TimeVaryingReconstruction is a Rauch-Tung-Striebel Kalman smoother where the
+// covariance matrices are propagated per sample instead of assuming that they
+// have converged to a steady state. This makes it suitable for short records
+// where the transients at the start and end dominate. The discrete model is
+//
+//	x[k+1] = Ad x[k] + Bd u[k] + s[k]
+//	0 = C x[k] + z[k]
+//
+// where the inputs u[k] are held constant over a sample, s[k] is the control
+// contribution and z[k] the measurement noise.
+type TimeVaryingReconstruction struct {
+	// Discrete state dynamics and input matrix
+	Ad, Bd *mat.Dense
+	// Observation matrix
+	C mat.Matrix
+	// Per sample input noise covariance (diagonal) and measurement noise
+	// covariance
+	inputNoiseCovariance       *mat.Dense
+	measurementNoiseCovariance mat.Matrix
+	// Initial state prior
+	initialState      *mat.VecDense
+	initialCovariance *mat.Dense
+	// Control interface and its control contributions
+	control       control.Control
+	contributions *controlContributions
+	// Per sample discretization of bilinear models, nil for linear models
+	biLinear *biLinearDiscretization
+	// estimate and estimate variances
+	estimate  [][]float64
+	variances [][]float64
+}
+
+// NewTimeVaryingReconstructor returns a time-varying Kalman smoother
+// reconstructor based on the control. The inputNoiseVariances, one per input,
+// and the measurementNoiseCovariance are per sample. The initial state prior is
+// given by initialState and initialCovariance, where a nil initialState is the
+// zero vector and a nil initialCovariance the identity matrix.
+//
+// The control contributions are the zero-order hold discretization of the
+// control vectors, which requires a control with constant control vectors,
+// e.g., control.AnalogSwitchControl. The filter contributions of the control
+// are left unchanged.
+func NewTimeVaryingReconstructor(cont control.Control, measurementNoiseCovariance mat.Matrix, inputNoiseVariances []float64, linearStateSpaceModel ssm.LinearStateSpaceModel, initialState mat.Vector, initialCovariance mat.Matrix) (*TimeVaryingReconstruction, error) {
+	if cont == nil {
+		return nil, errors.New("A control is required for reconstruction")
+	}
+	if _, err := ssm.NewLinearStateSpaceModelChecked(linearStateSpaceModel.A, linearStateSpaceModel.C, linearStateSpaceModel.Input); err != nil {
+		return nil, err
+	}
+	order := linearStateSpaceModel.StateSpaceOrder()
+	numberOfInputs := linearStateSpaceModel.InputSpaceOrder()
+	observations := linearStateSpaceModel.ObservationSpaceOrder()
+	if len(inputNoiseVariances) != numberOfInputs {
+		return nil, &ssm.DimensionError{What: "Number of input noise variances doesn't match the number of inputs"}
+	}
+	if m, n := measurementNoiseCovariance.Dims(); m != observations || n != observations {
+		return nil, &ssm.DimensionError{What: "Measurement noise covariance doesn't match the observation space order"}
+	}
+	if initialState != nil && initialState.Len() != order {
+		return nil, &ssm.DimensionError{What: "Initial state doesn't match the state space order"}
+	}
+	if initialCovariance != nil {
+		if m, n := initialCovariance.Dims(); m != order || n != order {
+			return nil, &ssm.DimensionError{What: "Initial covariance doesn't match the state space order"}
+		}
+	}
+
+	// Collect the input vectors as B = [b_0, ..., b_N]
+	B := mat.NewDense(order, numberOfInputs, nil)
+	for column, input := range linearStateSpaceModel.Input {
+		for row := 0; row < order; row++ {
+			B.Set(row, column, input.B.AtVec(row))
+		}
+	}
+	Ad, Bd := ssm.ZeroOrderHoldDiscretization(linearStateSpaceModel.A, B, cont.GetTs())
+	contributions, err := newControlContributions(cont, linearStateSpaceModel.A)
+	if err != nil {
+		return nil, err
+	}
+
+	inputNoiseCovariance := mat.NewDense(numberOfInputs, numberOfInputs, nil)
+	for index, variance := range inputNoiseVariances {
+		if variance <= 0 {
+			return nil, errors.New("Input noise variances must be positive")
+		}
+		inputNoiseCovariance.Set(index, index, variance)
+	}
+
+	rec := TimeVaryingReconstruction{
+		Ad:                         Ad,
+		Bd:                         Bd,
+		C:                          linearStateSpaceModel.C,
+		inputNoiseCovariance:       inputNoiseCovariance,
+		measurementNoiseCovariance: measurementNoiseCovariance,
+		initialState:               mat.NewVecDense(order, nil),
+		initialCovariance:          mat.NewDense(order, order, nil),
+		control:                    cont,
+		contributions:              contributions,
+	}
+	if initialState != nil {
+		rec.initialState.CopyVec(initialState)
+	}
+	if initialCovariance != nil {
+		rec.initialCovariance.Copy(initialCovariance)
+	} else {
+		for index := 0; index < order; index++ {
+			rec.initialCovariance.Set(index, index, 1.)
+		}
+	}
+
+	return &rec, nil
+}
+
+// Reconstruction returns the reconstructed estimates based on the associated
+// control interface.
+//
+// The returned data structure is [number of time indices][number of estimates]float64
+//
+// Panics if the reconstruction fails, see ReconstructionChecked.
+func (rec *TimeVaryingReconstruction) Reconstruction() [][]float64 {
+	res, err := rec.ReconstructionChecked()
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// ReconstructionChecked is Reconstruction returning an error if the control
+// can't provide the control contributions or if a covariance matrix becomes
+// singular.
+func (rec *TimeVaryingReconstruction) ReconstructionChecked() ([][]float64, error) {
+	n := rec.control.GetLength()
+	if n == 0 {
+		return nil, control.ErrIndexOutOfRange
+	}
+
+	// Forward Kalman filter
+	//
+	// filtered[k] = x[k|k], predicted[k] = x[k+1|k]
+	filteredState := make([]*mat.VecDense, n)
+	filteredCovariance := make([]*mat.Dense, n)
+	predictedState := make([]*mat.VecDense, n)
+	predictedCovariance := make([]*mat.Dense, n)
+
+	Ad, Bd, controlContributions, err := rec.discretization(n)
+	if err != nil {
+		return nil, err
+	}
+
+	order, _ := rec.Ad.Dims()
+	identity := mat.NewDense(order, order, nil)
+	for index := 0; index < order; index++ {
+		identity.Set(index, index, 1.)
+	}
+
+	state := mat.VecDenseCopyOf(rec.initialState)
+	covariance := mat.DenseCopyOf(rec.initialCovariance)
+	for index := 0; index < n; index++ {
+		// Measurement update with the observation 0 = C x[k] + z[k]
+		// S = C P C^T + Sigma_z
+		var S, PCt, Kt, IKC, KSigma, tmp mat.Dense
+		PCt.Mul(covariance, rec.C.T())
+		S.Mul(rec.C, &PCt)
+		S.Add(&S, rec.measurementNoiseCovariance)
+		// K = P C^T S^(-1)  <=> S K^T = C P
+		if err := Kt.Solve(&S, PCt.T()); err != nil {
+			return nil, err
+		}
+		K := Kt.T()
+
+		var observation, correction mat.VecDense
+		observation.MulVec(rec.C, state)
+		correction.MulVec(K, &observation)
+		state.SubVec(state, &correction)
+
+		// Joseph form (I - K C) P (I - K C)^T + K Sigma_z K^T
+		IKC.Mul(K, rec.C)
+		IKC.Sub(identity, &IKC)
+		tmp.Mul(&IKC, covariance)
+		covariance.Mul(&tmp, IKC.T())
+		KSigma.Mul(K, rec.measurementNoiseCovariance)
+		tmp.Mul(&KSigma, &Kt)
+		covariance.Add(covariance, &tmp)
+		symmetrize(covariance)
+
+		filteredState[index] = mat.VecDenseCopyOf(state)
+		filteredCovariance[index] = mat.DenseCopyOf(covariance)
+
+		// Prediction with the process noise covariance Bd Sigma_u Bd^T
+		var BSigma mat.Dense
+		BSigma.Mul(Bd[index], rec.inputNoiseCovariance)
+		state.MulVec(Ad[index], state)
+		state.AddVec(state, controlContributions[index])
+		tmp.Mul(Ad[index], covariance)
+		covariance.Mul(&tmp, Ad[index].T())
+		tmp.Mul(&BSigma, Bd[index].T())
+		covariance.Add(covariance, &tmp)
+		symmetrize(covariance)
+
+		predictedState[index] = mat.VecDenseCopyOf(state)
+		predictedCovariance[index] = mat.DenseCopyOf(covariance)
+	}
+
+	// Backward Rauch-Tung-Striebel recursion. There is no observation of the
+	// last state x[n] which is why x[n|n] = x[n|n-1].
+	numberOfInputs, _ := rec.inputNoiseCovariance.Dims()
+	rec.estimate = make([][]float64, n)
+	rec.variances = make([][]float64, n)
+	smoothedState := mat.VecDenseCopyOf(predictedState[n-1])
+	smoothedCovariance := mat.DenseCopyOf(predictedCovariance[n-1])
+	for index := n - 1; index >= 0; index-- {
+		var difference mat.VecDense
+		var covarianceDifference, GP, Gt, Jt, tmp mat.Dense
+		// x[k+1|n] - x[k+1|k] and P[k+1|n] - P[k+1|k]
+		difference.SubVec(smoothedState, predictedState[index])
+		covarianceDifference.Sub(smoothedCovariance, predictedCovariance[index])
+
+		// Input estimate u[k|n] = G (x[k+1|n] - x[k+1|k]) with
+		// G = Sigma_u Bd^T P[k+1|k]^(-1)  <=> P[k+1|k] G^T = Bd Sigma_u
+		var BSigma mat.Dense
+		BSigma.Mul(Bd[index], rec.inputNoiseCovariance)
+		if err := Gt.Solve(predictedCovariance[index], &BSigma); err != nil {
+			return nil, err
+		}
+		var input mat.VecDense
+		input.MulVec(Gt.T(), &difference)
+		// Var(u[k|n]) = Sigma_u + G (P[k+1|n] - P[k+1|k]) G^T
+		var inputCovariance mat.Dense
+		GP.Mul(Gt.T(), &covarianceDifference)
+		inputCovariance.Mul(&GP, &Gt)
+		inputCovariance.Add(rec.inputNoiseCovariance, &inputCovariance)
+
+		rec.estimate[index] = make([]float64, numberOfInputs)
+		rec.variances[index] = make([]float64, numberOfInputs)
+		for inp := 0; inp < numberOfInputs; inp++ {
+			rec.estimate[index][inp] = input.AtVec(inp)
+			rec.variances[index][inp] = inputCovariance.At(inp, inp)
+		}
+
+		// State smoothing with J = P[k|k] Ad^T P[k+1|k]^(-1)
+		// <=> P[k+1|k] J^T = Ad P[k|k]
+		tmp.Mul(Ad[index], filteredCovariance[index])
+		if err := Jt.Solve(predictedCovariance[index], &tmp); err != nil {
+			return nil, err
+		}
+		difference.MulVec(Jt.T(), &difference)
+		smoothedState.AddVec(filteredState[index], &difference)
+		tmp.Mul(Jt.T(), &covarianceDifference)
+		smoothedCovariance.Mul(&tmp, &Jt)
+		smoothedCovariance.Add(filteredCovariance[index], smoothedCovariance)
+		symmetrize(smoothedCovariance)
+	}
+
+	return rec.estimate, nil
+}
+
+// discretization returns the discrete state dynamics, input matrices and
+// control contributions of the first n samples.
+func (rec *TimeVaryingReconstruction) discretization(n int) (Ad, Bd []*mat.Dense, controlContributions []mat.Vector, err error) {
+	if rec.biLinear != nil {
+		return rec.biLinear.discretization(n)
+	}
+	Ad = make([]*mat.Dense, n)
+	Bd = make([]*mat.Dense, n)
+	controlContributions = make([]mat.Vector, n)
+	for index := 0; index < n; index++ {
+		Ad[index], Bd[index] = rec.Ad, rec.Bd
+		if controlContributions[index], err = rec.contributions.at(index); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return Ad, Bd, controlContributions, nil
+}
+
+// Variances returns the variances of the estimates of the last reconstruction
+// as [number of time indices][number of estimates]float64. The reconstruction
+// is computed if needed.
+func (rec *TimeVaryingReconstruction) Variances() ([][]float64, error) {
+	if rec.variances == nil {
+		if _, err := rec.ReconstructionChecked(); err != nil {
+			return nil, err
+		}
+	}
+	return rec.variances, nil
+}
+
+// GetStateDynamics returns the discrete state dynamics Ad = e^(A Ts) forward
+// in time and its inverse e^(-A Ts) backward in time. For bilinear models the
+// state dynamics of the linear part AL are returned.
+func (rec *TimeVaryingReconstruction) GetStateDynamics() (Af, Ab *mat.Dense) {
+	Af = mat.DenseCopyOf(rec.Ad)
+	Ab = &mat.Dense{}
+	if err := Ab.Inverse(rec.Ad); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// controlContributions computes the control contributions s[k] of a control
+// with constant control vectors from the zero-order hold discretization of the
+// control vectors. Unlike the filter contributions of the control they don't
+// depend on the filter dynamics of any reconstructor sharing the control.
+type controlContributions struct {
+	// Discretized control vectors Gammad = [gamma_0, ..., gamma_M]
+	Gammad  *mat.Dense
+	control switchedControl
+}
+
+// newControlContributions returns the control contributions of the control
+// for the state dynamics A.
+func newControlContributions(cont control.Control, A mat.Matrix) (*controlContributions, error) {
+	switched, ok := cont.(switchedControl)
+	if !ok || len(switched.GetControlVectors()) == 0 {
+		return nil, errors.New("Reconstruction requires a control with constant control vectors")
+	}
+	order, _ := A.Dims()
+	vectors := switched.GetControlVectors()
+	Gamma := mat.NewDense(order, len(vectors), nil)
+	for column, vector := range vectors {
+		if vector.Len() != order {
+			return nil, &ssm.DimensionError{What: "Control vector doesn't match the state space order"}
+		}
+		Gamma.SetCol(column, mat.Col(nil, 0, vector))
+	}
+	_, Gammad := ssm.ZeroOrderHoldDiscretization(A, Gamma, cont.GetTs())
+	return &controlContributions{Gammad: Gammad, control: switched}, nil
+}
+
+// at returns the control contribution s[k] = Gammad (2 b[k] - 1) where b[k]
+// are the control decisions at index.
+func (c *controlContributions) at(index int) (mat.Vector, error) {
+	decisions := c.control.GetControlDecisions()
+	if index < 0 || index >= len(decisions) {
+		return nil, control.ErrIndexOutOfRange
+	}
+	_, numberOfControls := c.Gammad.Dims()
+	decision := mat.NewVecDense(numberOfControls, nil)
+	for controlIndex := 0; controlIndex < numberOfControls; controlIndex++ {
+		decision.SetVec(controlIndex, 2.*float64((decisions[index]>>uint(controlIndex))&1)-1.)
+	}
+	var res mat.VecDense
+	res.MulVec(c.Gammad, decision)
+	return &res, nil
+}
+
+// symmetrize removes the numerical asymmetry of a covariance matrix
+func symmetrize(m *mat.Dense) {
+	m.Add(m, m.T())
+	m.Scale(0.5, m)
+}
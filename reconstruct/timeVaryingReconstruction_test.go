@@ -0,0 +1,132 @@
+package reconstruct
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/hammal/adc/control"
+	"github.com/hammal/adc/gonumExtensions"
+	"github.com/hammal/adc/signal"
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestTimeVaryingReconstruction(t *testing.T) {
+	N := 3
+	sig := func(arg1 float64) float64 { return 0.5 * math.Sin(math.Pi*2.*arg1*100.) }
+	sm, _, controls := integratorChain(N, sig)
+
+	// A short record starting from a non-zero initial state
+	length := 200
+	initialState := mat.NewVecDense(N, []float64{0.8, -0.7, 0.9})
+	ctrl := control.NewAnalogSwitchControl(length, controls, chainTs, 0, mat.VecDenseCopyOf(initialState), sm)
+	ctrl.UseExactDiscretization(ssm.ZeroOrderHold)
+	ctrl.Simulate()
+
+	// rmse returns the root mean square error over the indices [from, to)
+	rmse := func(res [][]float64, from, to int) float64 {
+		sum := 0.
+		for index := from; index < to; index++ {
+			sum += math.Pow(res[index][0]-sig(float64(index)*chainTs), 2)
+		}
+		return math.Sqrt(sum / float64(to-from))
+	}
+
+	var rec Reconstruction
+	rec, err := NewTimeVaryingReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), []float64{1.}, *sm, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := rec.ReconstructionChecked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != length || len(res[0]) != 1 {
+		t.Fatalf("Unexpected reconstruction dimensions %v x %v", len(res), len(res[0]))
+	}
+	if e := rmse(res, 50, 150); e > 0.1 {
+		t.Errorf("Root mean square error %v is too large", e)
+	}
+
+	// The estimate is most uncertain at the edges of the record and the last
+	// input is not observed at all.
+	variances, err := rec.(*TimeVaryingReconstruction).Variances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index := range variances {
+		if variances[index][0] <= 0 || variances[index][0] > 1+1e-9 {
+			t.Fatalf("Variance %v at index %v is out of range", variances[index][0], index)
+		}
+	}
+	if variances[0][0] <= variances[length/2][0] || variances[length-1][0] <= variances[length/2][0] {
+		t.Errorf("Variances %v, %v and %v don't show the transients", variances[0][0], variances[length/2][0], variances[length-1][0])
+	}
+	if math.Abs(variances[length-1][0]-1.) > 1e-6 {
+		t.Errorf("The variance of the last estimate %v should equal the prior", variances[length-1][0])
+	}
+
+	// A known initial state improves the estimate at the start
+	priorRec, err := NewTimeVaryingReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), []float64{1.}, *sm, initialState, mat.NewDiagonal(N, []float64{1e-6, 1e-6, 1e-6}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ePrior := rmse(res, 0, 20), rmse(priorRec.Reconstruction(), 0, 20); ePrior >= e {
+		t.Errorf("Initial state prior doesn't improve the error at the start, %v >= %v", ePrior, e)
+	}
+}
+
+func TestNewTimeVaryingReconstructorErrors(t *testing.T) {
+	N := 2
+	b := mat.NewVecDense(N, []float64{1, 0})
+	sm := ssm.NewIntegratorChain(N, 1, []signal.VectorFunction{signal.NewInput(math.Sin, b)})
+	controls := []mat.Vector{mat.NewVecDense(N, []float64{-1, 0}), mat.NewVecDense(N, []float64{0, -1})}
+	ctrl := control.NewAnalogSwitchControl(10, controls, 1e-1, 0, nil, sm)
+
+	if _, err := NewTimeVaryingReconstructor(ctrl, gonumExtensions.Eye(3, 3, 0), []float64{1.}, *sm, nil, nil); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for the measurement noise covariance but got %v", err)
+	}
+	if _, err := NewTimeVaryingReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), []float64{1., 1.}, *sm, nil, nil); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for the input noise variances but got %v", err)
+	}
+	if _, err := NewTimeVaryingReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), []float64{1.}, *sm, mat.NewVecDense(3, nil), nil); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for the initial state but got %v", err)
+	}
+	if _, err := NewTimeVaryingReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), []float64{1.}, *sm, nil, gonumExtensions.Eye(3, 3, 0)); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for the initial covariance but got %v", err)
+	}
+	if _, err := NewTimeVaryingReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), []float64{0.}, *sm, nil, nil); err == nil {
+		t.Error("Expected an error for a zero input noise variance")
+	}
+	if _, err := NewTimeVaryingReconstructor(control.NewSwitchedCapacitorControl(10, nil, 1e-1, 0, nil, sm), gonumExtensions.Eye(N, N, 0), []float64{1.}, *sm, nil, nil); err == nil {
+		t.Error("Expected an error for a control without constant control vectors")
+	}
+}
+
+func TestTimeVaryingReconstructionSharedControl(t *testing.T) {
+	N := 3
+	sig := func(arg1 float64) float64 { return 0.5 * math.Sin(math.Pi*2.*arg1*100.) }
+	sm, b, controls := integratorChain(N, sig)
+	ctrl := simulateChain(t, sm, controls, 500)
+
+	// A time-varying reconstructor doesn't change the filter contributions
+	// of a steady state reconstructor of the same control
+	var inputNoiseCovariance mat.Dense
+	inputNoiseCovariance.Outer(1., b, b)
+	steadyState := NewSteadyStateReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), &inputNoiseCovariance, *sm)
+	before, err := steadyState.ReconstructionChecked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTimeVaryingReconstructor(ctrl, gonumExtensions.Eye(N, N, 0), []float64{1.}, *sm, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	after, err := steadyState.ReconstructionChecked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Error("The time-varying reconstructor changes the steady state reconstruction")
+	}
+}
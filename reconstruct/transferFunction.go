@@ -0,0 +1,242 @@
+package reconstruct
+
+import (
+	"errors"
+	"math"
+	"math/cmplx"
+
+	"github.com/hammal/adc/ssm"
+	"gonum.org/v1/gonum/mat"
+)
+
+// TransferFunctions holds the frequency responses of the steady state
+// reconstruction. With Phi(f) = C (j 2 pi f I - A)^(-1) the estimate of input l
+// is
+//
+//	u_l(f) = b_l^T Phi(f)^H (Phi(f) Q Phi(f)^H + Sigma_z)^(-1) y(f)
+//
+// where Q is the input noise covariance and Sigma_z the measurement noise
+// covariance. The input vectors b_l are assumed to be in the range of Q.
+type TransferFunctions struct {
+	// Frequencies [Hz]
+	Frequency []float64
+	// Signal transfer function from input i to estimate l as
+	// [estimate][input][frequency]complex128
+	Signal [][][]complex128
+	// Noise transfer function from the noise of observation m to estimate l as
+	// [estimate][observation][frequency]complex128
+	Noise [][][]complex128
+	// Transfer function from control signal c to estimate l as
+	// [estimate][control][frequency]complex128
+	Control [][][]complex128
+}
+
+// NewTransferFunctions computes the signal and noise transfer functions of the
+// steady state reconstruction of the linearStateSpaceModel over the frequency
+// grid. The control transfer functions are computed for the control vectors,
+// e.g., AnalogSwitchControl.GetControlVectors, which may be nil. Returns
+// ssm.ErrSingular for a frequency at a pole of the linearStateSpaceModel.
+func NewTransferFunctions(linearStateSpaceModel ssm.LinearStateSpaceModel, measurementNoiseCovariance, inputNoiseCovariance mat.Matrix, controls []mat.Vector, frequency []float64) (*TransferFunctions, error) {
+	if _, err := ssm.NewLinearStateSpaceModelChecked(linearStateSpaceModel.A, linearStateSpaceModel.C, linearStateSpaceModel.Input); err != nil {
+		return nil, err
+	}
+	order := linearStateSpaceModel.StateSpaceOrder()
+	numberOfInputs := linearStateSpaceModel.InputSpaceOrder()
+	observations := linearStateSpaceModel.ObservationSpaceOrder()
+	if m, n := measurementNoiseCovariance.Dims(); m != observations || n != observations {
+		return nil, &ssm.DimensionError{What: "Measurement noise covariance doesn't match the observation space order"}
+	}
+	if m, n := inputNoiseCovariance.Dims(); m != order || n != order {
+		return nil, &ssm.DimensionError{What: "Input noise covariance doesn't match the state space order"}
+	}
+	for _, control := range controls {
+		if control.Len() != order {
+			return nil, &ssm.DimensionError{What: "Control vector doesn't match the state space order"}
+		}
+	}
+
+	// Sigma_z^(-1)
+	var precision mat.Dense
+	if err := precision.Inverse(measurementNoiseCovariance); err != nil {
+		return nil, err
+	}
+
+	// Q = L L^T and b_l = L c_l with L = V D^(1/2) from the eigenvalue
+	// decomposition of Q where the zero eigenvalues are dropped.
+	var eigen mat.EigenSym
+	symmetric := mat.NewSymDense(order, nil)
+	for row := 0; row < order; row++ {
+		for column := row; column < order; column++ {
+			symmetric.SetSym(row, column, (inputNoiseCovariance.At(row, column)+inputNoiseCovariance.At(column, row))/2)
+		}
+	}
+	if !eigen.Factorize(symmetric, true) {
+		return nil, errors.New("Eigenvalue decomposition of the input noise covariance failed")
+	}
+	values := eigen.Values(nil)
+	var vectors mat.Dense
+	vectors.EigenvectorsSym(&eigen)
+	largest := 0.
+	for _, value := range values {
+		largest = math.Max(largest, value)
+	}
+	var rank []int
+	for index, value := range values {
+		if value < -1e-12*largest {
+			return nil, ErrNotPositiveDefinite
+		}
+		if value > 1e-12*largest {
+			rank = append(rank, index)
+		}
+	}
+	if len(rank) == 0 {
+		return nil, errors.New("Input noise covariance is zero")
+	}
+	L := mat.NewDense(order, len(rank), nil)
+	coefficients := mat.NewDense(len(rank), numberOfInputs, nil)
+	for column, index := range rank {
+		scale := math.Sqrt(values[index])
+		for row := 0; row < order; row++ {
+			L.Set(row, column, vectors.At(row, index)*scale)
+		}
+		for inp, input := range linearStateSpaceModel.Input {
+			coefficients.Set(column, inp, mat.Dot(vectors.ColView(index), input.B)/scale)
+		}
+	}
+
+	// [B, Gamma] for the signal and control transfer functions
+	B := mat.NewDense(order, numberOfInputs+len(controls), nil)
+	for column, input := range linearStateSpaceModel.Input {
+		B.SetCol(column, mat.Col(nil, 0, input.B))
+	}
+	for column, control := range controls {
+		B.SetCol(numberOfInputs+column, mat.Col(nil, 0, control))
+	}
+
+	tf := &TransferFunctions{
+		Frequency: frequency,
+		Signal:    complexTensor(numberOfInputs, numberOfInputs, len(frequency)),
+		Noise:     complexTensor(numberOfInputs, observations, len(frequency)),
+		Control:   complexTensor(numberOfInputs, len(controls), len(frequency)),
+	}
+	for index, f := range frequency {
+		omega := 2 * math.Pi * f
+		// Phi^T solves (j omega I - A^T) Phi^T = C^T
+		re, im, err := ssm.Resolvent(linearStateSpaceModel.A.T(), linearStateSpaceModel.C.T(), omega)
+		if err != nil {
+			return nil, err
+		}
+		phi := complexMatrix(re.T(), im.T())
+
+		// With F = Phi L and K = I + F^H Sigma_z^(-1) F the noise transfer
+		// function is c_l^T K^(-1) F^H Sigma_z^(-1) which avoids the
+		// cancellation of Phi Q Phi^H + Sigma_z at high gains.
+		F := complexMul(phi, complexMatrix(L, nil))
+		FhS := complexMul(conjugateTranspose(F), complexMatrix(&precision, nil))
+		K := complexMul(FhS, F)
+		for diagonal := range K {
+			K[diagonal][diagonal]++
+		}
+		Kinv, err := complexInverse(K)
+		if err != nil {
+			return nil, err
+		}
+		ntf := complexMul(complexMul(conjugateTranspose(complexMatrix(coefficients, nil)), Kinv), FhS)
+		response := complexMul(ntf, complexMul(phi, complexMatrix(B, nil)))
+
+		for estimate := 0; estimate < numberOfInputs; estimate++ {
+			for observation := 0; observation < observations; observation++ {
+				tf.Noise[estimate][observation][index] = ntf[estimate][observation]
+			}
+			for input := 0; input < numberOfInputs; input++ {
+				tf.Signal[estimate][input][index] = response[estimate][input]
+			}
+			// The control contributions enter the observations as Phi gamma_c s_c
+			for control := range controls {
+				tf.Control[estimate][control][index] = -response[estimate][numberOfInputs+control]
+			}
+		}
+	}
+	return tf, nil
+}
+
+// Magnitude returns the magnitudes of a frequency response.
+func Magnitude(response []complex128) []float64 {
+	res := make([]float64, len(response))
+	for index, value := range response {
+		res[index] = cmplx.Abs(value)
+	}
+	return res
+}
+
+// MagnitudeDB returns the magnitudes of a frequency response in dB.
+func MagnitudeDB(response []complex128) []float64 {
+	res := Magnitude(response)
+	for index, value := range res {
+		res[index] = 20 * math.Log10(value)
+	}
+	return res
+}
+
+// Phase returns the phases of a frequency response in radians.
+func Phase(response []complex128) []float64 {
+	res := make([]float64, len(response))
+	for index, value := range response {
+		res[index] = cmplx.Phase(value)
+	}
+	return res
+}
+
+// complexTensor allocates a [a][b][c]complex128 tensor
+func complexTensor(a, b, c int) [][][]complex128 {
+	res := make([][][]complex128, a)
+	for first := range res {
+		res[first] = make([][]complex128, b)
+		for second := range res[first] {
+			res[first][second] = make([]complex128, c)
+		}
+	}
+	return res
+}
+
+// complexMatrix returns re + j im where im may be nil
+func complexMatrix(re, im mat.Matrix) [][]complex128 {
+	rows, columns := re.Dims()
+	res := make([][]complex128, rows)
+	for row := range res {
+		res[row] = make([]complex128, columns)
+		for column := range res[row] {
+			res[row][column] = complex(re.At(row, column), 0)
+			if im != nil {
+				res[row][column] += complex(0, im.At(row, column))
+			}
+		}
+	}
+	return res
+}
+
+// complexMul returns the matrix product a b
+func complexMul(a, b [][]complex128) [][]complex128 {
+	res := make([][]complex128, len(a))
+	for row := range a {
+		res[row] = make([]complex128, len(b[0]))
+		for column := range res[row] {
+			for inner := range b {
+				res[row][column] += a[row][inner] * b[inner][column]
+			}
+		}
+	}
+	return res
+}
+
+// conjugateTranspose returns a^H
+func conjugateTranspose(a [][]complex128) [][]complex128 {
+	res := make([][]complex128, len(a[0]))
+	for row := range res {
+		res[row] = make([]complex128, len(a))
+		for column := range res[row] {
+			res[row][column] = cmplx.Conj(a[column][row])
+		}
+	}
+	return res
+}
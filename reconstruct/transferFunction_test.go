@@ -0,0 +1,60 @@
+package reconstruct
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/hammal/adc/gonumExtensions"
+	"github.com/hammal/adc/ssm"
+)
+
+func TestTransferFunctions(t *testing.T) {
+	N := 3
+	sm, _, controls := integratorChain(N, func(float64) float64 { return 0 })
+
+	design, err := DesignForBandwidth(*sm, 1000.)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frequency := []float64{1., 100., design.Bandwidth, 1e4}
+	tf, err := NewTransferFunctions(*sm, design.MeasurementNoiseCovariance, design.InputNoiseCovariance, controls, frequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// For the integrator chain G_m(f) = (beta / (j 2 pi f))^(m+1) and the
+	// NTF is G^H / (||G||^2 + eta^2)
+	for index, f := range frequency {
+		G := make([]complex128, N)
+		gain := 0.
+		for m := range G {
+			G[m] = cmplx.Pow(complex(0, -chainGain/(2*math.Pi*f)), complex(float64(m+1), 0))
+			gain += math.Pow(cmplx.Abs(G[m]), 2)
+		}
+		stf := gain / (gain + design.Eta2)
+		if cmplx.Abs(tf.Signal[0][0][index]-complex(stf, 0)) > 1e-9 {
+			t.Errorf("STF(%v) = %v instead of %v", f, tf.Signal[0][0][index], stf)
+		}
+		for m := range G {
+			ntf := cmplx.Conj(G[m]) / complex(gain+design.Eta2, 0)
+			if cmplx.Abs(tf.Noise[0][m][index]-ntf) > 1e-9*cmplx.Abs(ntf) {
+				t.Errorf("NTF_%v(%v) = %v instead of %v", m, f, tf.Noise[0][m][index], ntf)
+			}
+		}
+		// The first control vector is -b
+		if cmplx.Abs(tf.Control[0][0][index]-tf.Signal[0][0][index]) > 1e-9 {
+			t.Errorf("Control transfer function %v doesn't equal the STF %v", tf.Control[0][0][index], tf.Signal[0][0][index])
+		}
+	}
+	if magnitude := MagnitudeDB(tf.Signal[0][0])[2]; math.Abs(magnitude+20*math.Log10(2)) > 1e-6 {
+		t.Errorf("STF at the bandwidth is %v dB", magnitude)
+	}
+	if phase := Phase(tf.Noise[0][0])[1]; math.Abs(phase-math.Pi/2) > 1e-9 {
+		t.Errorf("Phase of the NTF is %v instead of pi/2", phase)
+	}
+
+	if _, err := NewTransferFunctions(*sm, gonumExtensions.Eye(N-1, N-1, 0), design.InputNoiseCovariance, nil, frequency); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch but got %v", err)
+	}
+}
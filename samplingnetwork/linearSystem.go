@@ -27,12 +27,42 @@ func (sys LinearSystem) StateSpaceOrder() int {
 	return M
 }
 
+// Validate checks that A is square and that B and C match the state space
+// order. Returns a *ssm.DimensionError otherwise.
+func (sys LinearSystem) Validate() error {
+	if sys.A == nil || sys.B == nil || sys.C == nil {
+		return &ssm.DimensionError{What: "The linear system is missing A, B or C"}
+	}
+	m, n := sys.A.Dims()
+	mB, _ := sys.B.Dims()
+	_, nC := sys.C.Dims()
+	if m != n || mB != m || nC != m {
+		return &ssm.DimensionError{What: "The A, B and C matrices of the linear system don't agree"}
+	}
+	return nil
+}
+
 // LinearSystemToLinearStateSpaceModel converts a system and an array of input
-// functions into a linear state space model.
+// functions into a linear state space model. Panics if the dimensions don't
+// match, see LinearSystemToLinearStateSpaceModelChecked.
 func LinearSystemToLinearStateSpaceModel(system LinearSystem, inputFunction []func(float64) float64) *ssm.LinearStateSpaceModel {
+	model, err := LinearSystemToLinearStateSpaceModelChecked(system, inputFunction)
+	if err != nil {
+		panic(err)
+	}
+	return model
+}
+
+// LinearSystemToLinearStateSpaceModelChecked is
+// LinearSystemToLinearStateSpaceModel returning a *ssm.DimensionError if the
+// system or the number of input functions don't match.
+func LinearSystemToLinearStateSpaceModelChecked(system LinearSystem, inputFunction []func(float64) float64) (*ssm.LinearStateSpaceModel, error) {
+	if err := system.Validate(); err != nil {
+		return nil, err
+	}
 
 	if len(inputFunction) != system.InputSpaceOrder() {
-		panic("The B vector and size of inputFunction don't agree")
+		return nil, &ssm.DimensionError{What: "The B vector and size of inputFunction don't agree"}
 	}
 
 	input := make([]signal.VectorFunction, system.InputSpaceOrder())
@@ -45,5 +75,5 @@ func LinearSystemToLinearStateSpaceModel(system LinearSystem, inputFunction []fu
 		input[index] = signal.NewInput(inputFunction[index], tmpB.ColView(index))
 	}
 
-	return ssm.NewLinearStateSpaceModel(system.A, system.C, input)
+	return ssm.NewLinearStateSpaceModelChecked(system.A, system.C, input)
 }
@@ -1,6 +1,36 @@
 package samplingnetwork
 
+import "github.com/hammal/adc/ssm"
+
 type SamplingNetwork struct {
 	System  LinearSystem
 	Control []Control
 }
+
+// Validate checks that the linear system is valid and that every control
+// vector matches the state space order. Returns a *ssm.DimensionError
+// otherwise.
+func (network SamplingNetwork) Validate() error {
+	if err := network.System.Validate(); err != nil {
+		return err
+	}
+	for _, control := range network.Control {
+		if control.GetVector() == nil || control.GetVector().Len() != network.System.StateSpaceOrder() {
+			return &ssm.DimensionError{What: "Control vector doesn't match the state space order"}
+		}
+	}
+	return nil
+}
+
+// validateNetworks validates a non-empty list of sampling networks
+func validateNetworks(systems []SamplingNetwork) error {
+	if len(systems) == 0 {
+		return &ssm.DimensionError{What: "At least one sampling network is required"}
+	}
+	for _, system := range systems {
+		if err := system.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
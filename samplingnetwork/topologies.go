@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 
+	"github.com/hammal/adc/ssm"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -57,13 +58,36 @@ func OscillatorBlock(gain, resonanceFrequency float64) SamplingNetwork {
 	}
 }
 
+// SplitBlock connects the systems in parallel where all systems share the same
+// inputs. Panics if the dimensions don't match, see SplitBlockChecked.
 func SplitBlock(systems []SamplingNetwork) SamplingNetwork {
-	if len(systems) == 2 {
+	res, err := SplitBlockChecked(systems)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
 
+// SplitBlockChecked is SplitBlock returning a *ssm.DimensionError if the
+// systems don't have the same number of inputs.
+func SplitBlockChecked(systems []SamplingNetwork) (SamplingNetwork, error) {
+	if err := validateNetworks(systems); err != nil {
+		return SamplingNetwork{}, err
+	}
+	for index := 1; index < len(systems); index++ {
 		// Check if splitting is possible (same number of inputs)
-		if systems[0].System.InputSpaceOrder() != systems[1].System.InputSpaceOrder() {
-			panic("The input dimensions must be equal in order to create split block")
+		if systems[0].System.InputSpaceOrder() != systems[index].System.InputSpaceOrder() {
+			return SamplingNetwork{}, &ssm.DimensionError{What: "The input dimensions must be equal in order to create split block"}
 		}
+	}
+	return splitBlock(systems), nil
+}
+
+func splitBlock(systems []SamplingNetwork) SamplingNetwork {
+	if len(systems) == 1 {
+		return systems[0]
+	}
+	if len(systems) == 2 {
 
 		// Update LinearSystem
 		systemOrder0 := systems[0].System.StateSpaceOrder()
@@ -123,19 +147,42 @@ func SplitBlock(systems []SamplingNetwork) SamplingNetwork {
 	}
 	// If not 2 systems left pop the first and split with the recursive command
 	tmpSysArray := make([]SamplingNetwork, 2)
-	tmpSysArray[1] = SplitBlock(systems[1:])
+	tmpSysArray[1] = splitBlock(systems[1:])
 	tmpSysArray[0] = systems[0]
-	return SplitBlock(tmpSysArray)
+	return splitBlock(tmpSysArray)
 
 }
 
+// MergeBlock connects the systems in parallel where the outputs of all systems
+// are summed. Panics if the dimensions don't match, see MergeBlockChecked.
 func MergeBlock(systems []SamplingNetwork) SamplingNetwork {
-	if len(systems) == 2 {
+	res, err := MergeBlockChecked(systems)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
 
+// MergeBlockChecked is MergeBlock returning a *ssm.DimensionError if the
+// systems don't have the same number of outputs.
+func MergeBlockChecked(systems []SamplingNetwork) (SamplingNetwork, error) {
+	if err := validateNetworks(systems); err != nil {
+		return SamplingNetwork{}, err
+	}
+	for index := 1; index < len(systems); index++ {
 		// Check if Merge is possible
-		if systems[0].System.OutputSpaceOrder() != systems[1].System.OutputSpaceOrder() {
-			panic("The output dimensions must be equal in order to create a merge block")
+		if systems[0].System.OutputSpaceOrder() != systems[index].System.OutputSpaceOrder() {
+			return SamplingNetwork{}, &ssm.DimensionError{What: "The output dimensions must be equal in order to create a merge block"}
 		}
+	}
+	return mergeBlock(systems), nil
+}
+
+func mergeBlock(systems []SamplingNetwork) SamplingNetwork {
+	if len(systems) == 1 {
+		return systems[0]
+	}
+	if len(systems) == 2 {
 
 		// Update LinearSystem
 		systemOrder0 := systems[0].System.StateSpaceOrder()
@@ -195,9 +242,9 @@ func MergeBlock(systems []SamplingNetwork) SamplingNetwork {
 	}
 	// If not 2 systems left pop the first and split with the recursive command
 	tmpSysArray := make([]SamplingNetwork, 2)
-	tmpSysArray[1] = MergeBlock(systems[1:])
+	tmpSysArray[1] = mergeBlock(systems[1:])
 	tmpSysArray[0] = systems[0]
-	return MergeBlock(tmpSysArray)
+	return mergeBlock(tmpSysArray)
 
 }
 
@@ -272,13 +319,37 @@ func ParallelBlock(systems []SamplingNetwork) SamplingNetwork {
 
 }
 
+// SeriesBlock connects the systems in series, i.e., the output of a system is
+// the input of the next. Panics if the dimensions don't match, see
+// SeriesBlockChecked.
 func SeriesBlock(systems []SamplingNetwork) SamplingNetwork {
-	if len(systems) == 2 {
+	res, err := SeriesBlockChecked(systems)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
 
+// SeriesBlockChecked is SeriesBlock returning a *ssm.DimensionError if the
+// output space of a system doesn't match the input space of the next.
+func SeriesBlockChecked(systems []SamplingNetwork) (SamplingNetwork, error) {
+	if err := validateNetworks(systems); err != nil {
+		return SamplingNetwork{}, err
+	}
+	for index := 1; index < len(systems); index++ {
 		// Check if series connection is possible.
-		if systems[0].System.OutputSpaceOrder() != systems[1].System.InputSpaceOrder() {
-			panic("The first systems output space must be equal to the second systems input space.")
+		if systems[index-1].System.OutputSpaceOrder() != systems[index].System.InputSpaceOrder() {
+			return SamplingNetwork{}, &ssm.DimensionError{What: "The first systems output space must be equal to the second systems input space."}
 		}
+	}
+	return seriesBlock(systems), nil
+}
+
+func seriesBlock(systems []SamplingNetwork) SamplingNetwork {
+	if len(systems) == 1 {
+		return systems[0]
+	}
+	if len(systems) == 2 {
 
 		// Update LinearSystem
 		systemOrder0 := systems[0].System.StateSpaceOrder()
@@ -337,9 +408,9 @@ func SeriesBlock(systems []SamplingNetwork) SamplingNetwork {
 	}
 	// If not 2 systems left pop the first and split with the recursive command
 	tmpSysArray := make([]SamplingNetwork, 2)
-	tmpSysArray[1] = SeriesBlock(systems[1:])
+	tmpSysArray[1] = seriesBlock(systems[1:])
 	tmpSysArray[0] = systems[0]
-	return SeriesBlock(tmpSysArray)
+	return seriesBlock(tmpSysArray)
 
 }
 
@@ -347,14 +418,27 @@ func SeriesBlock(systems []SamplingNetwork) SamplingNetwork {
 // Conjecture: Any feedback system of order N can be stabilized using at most
 // N Oscillating controls
 func FeedbackBlock(system1, system2 SamplingNetwork) SamplingNetwork {
+	res, err := FeedbackBlockChecked(system1, system2)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// FeedbackBlockChecked is FeedbackBlock returning a *ssm.DimensionError if the
+// feedforward or feedback path don't match.
+func FeedbackBlockChecked(system1, system2 SamplingNetwork) (SamplingNetwork, error) {
+	if err := validateNetworks([]SamplingNetwork{system1, system2}); err != nil {
+		return SamplingNetwork{}, err
+	}
 	// Check if feedforward is possible
 	if system1.System.OutputSpaceOrder() != system2.System.InputSpaceOrder() {
-		panic("The feedforward path between system1 and system2 does not have the same dimensions")
+		return SamplingNetwork{}, &ssm.DimensionError{What: "The feedforward path between system1 and system2 does not have the same dimensions"}
 	}
 
 	// Check if splitting is possible (same number of inputs)
 	if system1.System.InputSpaceOrder() != system2.System.OutputSpaceOrder() {
-		panic("The feedback path between system2 and system2 does not have the same dimensions")
+		return SamplingNetwork{}, &ssm.DimensionError{What: "The feedback path between system2 and system2 does not have the same dimensions"}
 	}
 
 	// Update LinearSystem
@@ -413,15 +497,27 @@ func FeedbackBlock(system1, system2 SamplingNetwork) SamplingNetwork {
 			C: &C,
 		},
 		Control: controls,
-	}
+	}, nil
 }
 
+// MultiPlexer maps the inputs of the system through maps, i.e., B maps. Panics
+// if the dimensions don't match, see MultiPlexerChecked.
 func MultiPlexer(system SamplingNetwork, maps mat.Matrix) SamplingNetwork {
+	res, err := MultiPlexerChecked(system, maps)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// MultiPlexerChecked is MultiPlexer returning a *ssm.DimensionError if maps
+// doesn't match the inputs of the system.
+func MultiPlexerChecked(system SamplingNetwork, maps mat.Matrix) (SamplingNetwork, error) {
 	M, _ := maps.Dims()
 	_, N := system.System.B.Dims()
 
 	if M != N {
-		panic(fmt.Sprintf("Not a valid mapping for this Sampling Network. B = \n%v\nmaps = \n%v\n", mat.Formatted(system.System.B), mat.Formatted(maps)))
+		return SamplingNetwork{}, &ssm.DimensionError{What: fmt.Sprintf("Not a valid mapping for this Sampling Network. B = \n%v\nmaps = \n%v\n", mat.Formatted(system.System.B), mat.Formatted(maps))}
 	}
 	var tmpB mat.Dense
 	tmpB.Mul(system.System.B, maps)
@@ -429,18 +525,30 @@ func MultiPlexer(system SamplingNetwork, maps mat.Matrix) SamplingNetwork {
 
 	// Let's not forget that the controls need to be adjusted
 	// TODO way of adjusting control weights.
-	return system
+	return system, nil
 }
 
+// DeMultiPlexer maps the outputs of the system through maps, i.e., maps C.
+// Panics if the dimensions don't match, see DeMultiPlexerChecked.
 func DeMultiPlexer(system SamplingNetwork, maps mat.Matrix) SamplingNetwork {
+	res, err := DeMultiPlexerChecked(system, maps)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// DeMultiPlexerChecked is DeMultiPlexer returning a *ssm.DimensionError if
+// maps doesn't match the outputs of the system.
+func DeMultiPlexerChecked(system SamplingNetwork, maps mat.Matrix) (SamplingNetwork, error) {
 	_, N := maps.Dims()
 	M, _ := system.System.C.Dims()
 
 	if M != N {
-		panic(fmt.Sprintf("Not a valid mapping for this Sampling Network. C = \n%v\nmaps = \n%v\n", mat.Formatted(system.System.B), mat.Formatted(maps)))
+		return SamplingNetwork{}, &ssm.DimensionError{What: fmt.Sprintf("Not a valid mapping for this Sampling Network. C = \n%v\nmaps = \n%v\n", mat.Formatted(system.System.C), mat.Formatted(maps))}
 	}
 	var tmpC mat.Dense
 	tmpC.Mul(maps, system.System.C)
 	system.System.C = &tmpC
-	return system
+	return system, nil
 }
@@ -2,8 +2,10 @@ package samplingnetwork
 
 import (
 	"fmt"
+	"math"
 	"testing"
 
+	"github.com/hammal/adc/ssm"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -114,3 +116,43 @@ func TestIntegratorChain(t *testing.T) {
 	sys := SeriesBlock(integrators)
 	fmt.Printf("A = \n%v\nB = \n%v\nC =\n%v\n", mat.Formatted(sys.System.A), mat.Formatted(sys.System.B), mat.Formatted(sys.System.C))
 }
+
+func TestCheckedTopologies(t *testing.T) {
+	gain := 1.
+	i := IntegratorBlock(gain)
+	o := OscillatorBlock(gain, gain)
+
+	if _, err := SplitBlockChecked([]SamplingNetwork{i, o}); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for split block but got %v", err)
+	}
+	if _, err := SplitBlockChecked(nil); err == nil {
+		t.Error("Expected an error for no systems")
+	}
+	if _, err := FeedbackBlockChecked(i, o); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for feedback block but got %v", err)
+	}
+	if _, err := MultiPlexerChecked(i, mat.NewDense(2, 1, nil)); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for multiplexer but got %v", err)
+	}
+	if _, err := DeMultiPlexerChecked(i, mat.NewDense(1, 2, nil)); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for demultiplexer but got %v", err)
+	}
+	if _, err := LinearSystemToLinearStateSpaceModelChecked(o.System, []func(float64) float64{math.Sin}); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for the input functions but got %v", err)
+	}
+
+	// A control vector that doesn't match the system
+	broken := IntegratorBlock(gain)
+	broken.Control[0].SetVector(mat.NewVecDense(2, nil))
+	if _, err := SeriesBlockChecked([]SamplingNetwork{i, broken}); !ssm.IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for the control vector but got %v", err)
+	}
+
+	series, err := SeriesBlockChecked([]SamplingNetwork{IntegratorBlock(gain), IntegratorBlock(gain), IntegratorBlock(gain)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.System.StateSpaceOrder() != 3 || series.Validate() != nil {
+		t.Error("Invalid series block")
+	}
+}
@@ -0,0 +1,66 @@
+package signal
+
+import (
+	"errors"
+	"math"
+)
+
+// mainLobe is the half width, in bins, of the main lobe of the
+// Blackman-Harris window.
+const mainLobe = 4
+
+// SNR returns the signal-to-noise ratio and the signal-to-noise-and-distortion
+// ratio [dB] of a sinusoid with the frequency, sampled with the sample period
+// ts, within the band [0, bandwidth]. The spectrum is estimated with a
+// Blackman-Harris window where DC is excluded and the harmonics of the
+// sinusoid within the band are counted as distortion.
+func SNR(values []float64, ts, frequency, bandwidth float64) (snr, sndr float64, err error) {
+	N := len(values)
+	resolution := 1. / (float64(N) * ts)
+	fundamental := int(math.Floor(frequency/resolution + 0.5))
+	band := int(math.Min(math.Floor(bandwidth/resolution), float64(N/2)))
+	if ts <= 0 || fundamental <= mainLobe || fundamental+mainLobe > band {
+		return 0, 0, errors.New("The frequency must be resolvable within the band")
+	}
+
+	window := make([]float64, N)
+	for index := range window {
+		phase := 2 * math.Pi * float64(index) / float64(N)
+		window[index] = (0.35875 - 0.48829*math.Cos(phase) + 0.14128*math.Cos(2*phase) - 0.01168*math.Cos(3*phase)) * values[index]
+	}
+	power := make([]float64, band+1)
+	for bin := range power {
+		var re, im float64
+		for index, value := range window {
+			phase := 2 * math.Pi * float64(bin*index) / float64(N)
+			re += value * math.Cos(phase)
+			im -= value * math.Sin(phase)
+		}
+		power[bin] = re*re + im*im
+	}
+
+	var signal, noise, distortion float64
+	noiseBins, distortionBins := 0, 0
+	for bin := mainLobe + 1; bin <= band; bin++ {
+		nearest := int(math.Floor(float64(bin)/float64(fundamental) + 0.5))
+		switch {
+		case nearest == 1 && abs(bin-fundamental) <= mainLobe:
+			signal += power[bin]
+		case nearest > 1 && abs(bin-nearest*fundamental) <= mainLobe:
+			distortion += power[bin]
+			distortionBins++
+		default:
+			noise += power[bin]
+			noiseBins++
+		}
+	}
+	// The noise within the harmonics is extrapolated from the remaining band
+	return 10 * math.Log10(signal/noise*float64(noiseBins)/float64(noiseBins+distortionBins)), 10 * math.Log10(signal/(noise+distortion)), nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
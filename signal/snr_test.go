@@ -0,0 +1,37 @@
+package signal
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSNR(t *testing.T) {
+	N, ts, frequency := 4096, 1e-3, 25.
+	noise := 1e-3
+	rng := rand.New(rand.NewSource(1))
+	values := make([]float64, N)
+	for index := range values {
+		phase := 2 * math.Pi * frequency * float64(index) * ts
+		values[index] = math.Sin(phase) + 1e-2*math.Sin(2*phase) + noise*rng.NormFloat64()
+	}
+
+	// The noise is white, i.e., evenly spread up to half the sample rate
+	bandwidth := 100.
+	snr, sndr, err := SNR(values, ts, frequency, bandwidth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := 10 * math.Log10(0.5/(noise*noise*2*bandwidth*ts))
+	if math.Abs(snr-expected) > 1 {
+		t.Errorf("SNR = %v instead of %v dB", snr, expected)
+	}
+	expected = 10 * math.Log10(0.5/(noise*noise*2*bandwidth*ts+0.5e-4))
+	if math.Abs(sndr-expected) > 1 {
+		t.Errorf("SNDR = %v instead of %v dB", sndr, expected)
+	}
+
+	if _, _, err := SNR(values, ts, frequency, 10); err == nil {
+		t.Error("Expected an error for a frequency outside the band")
+	}
+}
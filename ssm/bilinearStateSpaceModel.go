@@ -1,11 +1,21 @@
 package ssm
 
 import (
+	"errors"
+
 	"github.com/hammal/adc/gonumExtensions"
 	"github.com/hammal/adc/signal"
 	"gonum.org/v1/gonum/mat"
 )
 
+// BiLinearStateSpaceModel struct represent the system
+//
+// x'(t) = AL x(t) + B u(t) + AB Vec(x(t) u(t)^T)
+//
+// y(t) = C x(t)
+//
+// where Vec stacks the columns such that AB = [AB_0, ..., AB_N] and
+// AB Vec(x(t) u(t)^T) = u_0(t) AB_0 x(t) + ... + u_N(t) AB_N x(t).
 type BiLinearStateSpaceModel struct {
 	// Linear State Dynamics
 	AL mat.Matrix
@@ -17,6 +27,82 @@ type BiLinearStateSpaceModel struct {
 	Input []signal.VectorFunction
 }
 
+// NewBiLinearStateSpaceModel creates a new bilinear state space model. Panics
+// if the dimensions don't match, see NewBiLinearStateSpaceModelChecked.
+func NewBiLinearStateSpaceModel(AL, AB, C mat.Matrix, input []signal.VectorFunction) *BiLinearStateSpaceModel {
+	sys, err := NewBiLinearStateSpaceModelChecked(AL, AB, C, input)
+	if err != nil {
+		panic(err)
+	}
+	return sys
+}
+
+// NewBiLinearStateSpaceModelChecked creates a new bilinear state space model
+// and returns a *DimensionError if the system parameters don't match.
+func NewBiLinearStateSpaceModelChecked(AL, AB, C mat.Matrix, input []signal.VectorFunction) (*BiLinearStateSpaceModel, error) {
+	if AL == nil || AB == nil || C == nil {
+		return nil, errors.New("System parameters are required")
+	}
+	m, n := AL.Dims()
+	_, nC := C.Dims()
+	if m != n || nC != m {
+		return nil, &DimensionError{What: "System Parameters don't match"}
+	}
+	if mAB, nAB := AB.Dims(); mAB != m || nAB != m*len(input) {
+		return nil, &DimensionError{What: "Bilinear state dynamics don't match the state space order and number of inputs"}
+	}
+	for _, inp := range input {
+		if inp.B == nil || inp.B.Len() != m {
+			return nil, &DimensionError{What: "Input vector doesn't match the state space order"}
+		}
+	}
+	return &BiLinearStateSpaceModel{
+		AL:    AL,
+		AB:    AB,
+		C:     C,
+		Input: input,
+	}, nil
+}
+
+// StateDynamics returns the state dynamics
+//
+//	A(t) = AL + u_0(t) AB_0 + ... + u_N(t) AB_N
+//
+// for the input functions of the model.
+func (model BiLinearStateSpaceModel) StateDynamics(t float64) *mat.Dense {
+	u := make([]float64, len(model.Input))
+	for index, input := range model.Input {
+		u[index] = input.U(t)
+	}
+	return model.stateDynamics(u)
+}
+
+// Linearize returns the linear state space model with the state dynamics
+// AL + u_0 AB_0 + ... + u_N AB_N for the constant inputs u. Zero inputs result
+// in the linear part of the model.
+func (model BiLinearStateSpaceModel) Linearize(u []float64) (*LinearStateSpaceModel, error) {
+	if len(u) != len(model.Input) {
+		return nil, &DimensionError{What: "Number of linearization points doesn't match the number of inputs"}
+	}
+	return NewLinearStateSpaceModelChecked(model.stateDynamics(u), model.C, model.Input)
+}
+
+func (model BiLinearStateSpaceModel) stateDynamics(u []float64) *mat.Dense {
+	order := model.StateSpaceOrder()
+	A := mat.DenseCopyOf(model.AL)
+	for index, value := range u {
+		if value == 0 {
+			continue
+		}
+		for row := 0; row < order; row++ {
+			for column := 0; column < order; column++ {
+				A.Set(row, column, A.At(row, column)+value*model.AB.At(row, index*order+column))
+			}
+		}
+	}
+	return A
+}
+
 // Derivative returns the state derivative.
 // x'(t) = ALx(t) + Bu(t) + AB Vec(x(t)u(t)^T)
 // where state = x(t) at an arbitrary time t. Furthermore, Bu is the input vector field.
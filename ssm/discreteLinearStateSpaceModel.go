@@ -0,0 +1,170 @@
+package ssm
+
+import (
+	"errors"
+
+	"github.com/hammal/adc/signal"
+	"gonum.org/v1/gonum/mat"
+)
+
+// InputHold describes how the inputs are assumed to behave in between two
+// samples when discretizing a continuous-time model.
+type InputHold int
+
+const (
+	// ZeroOrderHold assumes piecewise constant inputs.
+	ZeroOrderHold InputHold = iota
+	// FirstOrderHold assumes piecewise linear inputs.
+	FirstOrderHold
+)
+
+// DiscreteLinearStateSpaceModel is the exact discretization of a
+// LinearStateSpaceModel with sample period Ts
+//
+// x[k+1] = Ad x[k] + B0 u[k] + B1 (u[k+1] - u[k])
+//
+// where u[k] = (input[0](t_k), ..., input[N](t_k)) and B1 is zero for
+// zero-order hold.
+type DiscreteLinearStateSpaceModel struct {
+	// Discrete state dynamics e^(A Ts)
+	Ad *mat.Dense
+	// Discrete input matrices
+	B0, B1 *mat.Dense
+	// Sample period
+	Ts float64
+	// Assumed input behavior between samples
+	Hold InputHold
+	// List of input functions
+	Input []signal.VectorFunction
+}
+
+// Discretize returns the exact discretization of the model for the sample
+// period ts. The matrices are computed once using the block matrix exponential
+// of Van Loan.
+func (model LinearStateSpaceModel) Discretize(ts float64, hold InputHold) *DiscreteLinearStateSpaceModel {
+	order := model.StateSpaceOrder()
+	numberOfInputs := model.InputSpaceOrder()
+
+	// Collect the input vectors as B = [b_0, ..., b_N]
+	B := mat.NewDense(order, numberOfInputs, nil)
+	for column, input := range model.Input {
+		for row := 0; row < order; row++ {
+			B.Set(row, column, input.B.AtVec(row))
+		}
+	}
+
+	var Ad, B0, B1 *mat.Dense
+	switch hold {
+	case ZeroOrderHold:
+		Ad, B0 = ZeroOrderHoldDiscretization(model.A, B, ts)
+		B1 = mat.NewDense(order, numberOfInputs, nil)
+	case FirstOrderHold:
+		// exp([A, B, 0; 0, 0, I; 0, 0, 0] Ts) = [Ad, G1, G2; 0, I, Ts I; 0, 0, I]
+		// where G1 = int_0^Ts e^(A s) ds B and G2 = int_0^Ts e^(A s) (Ts - s) ds B
+		size := order + 2*numberOfInputs
+		M := mat.NewDense(size, size, nil)
+		M.Slice(0, order, 0, order).(*mat.Dense).Copy(model.A)
+		M.Slice(0, order, order, order+numberOfInputs).(*mat.Dense).Copy(B)
+		for index := 0; index < numberOfInputs; index++ {
+			M.Set(order+index, order+numberOfInputs+index, 1.)
+		}
+		M.Scale(ts, M)
+		M.Exp(M)
+
+		Ad = mat.DenseCopyOf(M.Slice(0, order, 0, order))
+		B0 = mat.DenseCopyOf(M.Slice(0, order, order, order+numberOfInputs))
+		B1 = mat.DenseCopyOf(M.Slice(0, order, order+numberOfInputs, size))
+		B1.Scale(1./ts, B1)
+	default:
+		panic(errors.New("Unknown input hold"))
+	}
+
+	return &DiscreteLinearStateSpaceModel{
+		Ad:    Ad,
+		B0:    B0,
+		B1:    B1,
+		Ts:    ts,
+		Hold:  hold,
+		Input: model.Input,
+	}
+}
+
+// ZeroOrderHoldDiscretization returns
+//
+// Ad = e^(A ts) and Bd = int_0^ts e^(A s) ds B
+//
+// computed from the block matrix exponential exp([A, B; 0, 0] ts).
+func ZeroOrderHoldDiscretization(A, B mat.Matrix, ts float64) (Ad, Bd *mat.Dense) {
+	order, _ := A.Dims()
+	_, numberOfInputs := B.Dims()
+	size := order + numberOfInputs
+
+	M := mat.NewDense(size, size, nil)
+	M.Slice(0, order, 0, order).(*mat.Dense).Copy(A)
+	M.Slice(0, order, order, size).(*mat.Dense).Copy(B)
+	M.Scale(ts, M)
+	M.Exp(M)
+
+	Ad = mat.DenseCopyOf(M.Slice(0, order, 0, order))
+	Bd = mat.DenseCopyOf(M.Slice(0, order, order, size))
+	return
+}
+
+// NoiseCovarianceDiscretization returns the discretization of white noise
+// with power spectral density Q driving dx/dt = A x, i.e.,
+//
+// Ad = e^(A ts) and Qd = int_0^ts e^(A s) Q e^(A^T s) ds
+//
+// computed with Van Loan's method from exp([-A, Q; 0, A^T] ts).
+func NoiseCovarianceDiscretization(A, Q mat.Matrix, ts float64) (Ad, Qd *mat.Dense) {
+	order, _ := A.Dims()
+	size := 2 * order
+
+	M := mat.NewDense(size, size, nil)
+	M.Slice(0, order, 0, order).(*mat.Dense).Scale(-1, A)
+	M.Slice(0, order, order, size).(*mat.Dense).Copy(Q)
+	M.Slice(order, size, order, size).(*mat.Dense).Copy(A.T())
+	M.Scale(ts, M)
+	M.Exp(M)
+
+	// The lower right block is Ad^T and the upper right block Ad^(-1) Qd
+	Ad = mat.DenseCopyOf(M.Slice(order, size, order, size).T())
+	Qd = &mat.Dense{}
+	Qd.Mul(Ad, M.Slice(0, order, order, size))
+	// Remove the numerical asymmetry
+	Qd.Add(Qd, Qd.T())
+	Qd.Scale(0.5, Qd)
+	return
+}
+
+// Step returns the state at time t + Ts given the state at time t.
+func (model DiscreteLinearStateSpaceModel) Step(t float64, state mat.Vector) *mat.VecDense {
+	var res, tmp mat.VecDense
+
+	numberOfInputs := len(model.Input)
+	u := mat.NewVecDense(numberOfInputs, nil)
+	for index, input := range model.Input {
+		u.SetVec(index, input.U(t))
+	}
+
+	res.MulVec(model.Ad, state)
+	tmp.MulVec(model.B0, u)
+	res.AddVec(&res, &tmp)
+
+	if model.Hold == FirstOrderHold {
+		// du = u[k+1] - u[k]
+		du := mat.NewVecDense(numberOfInputs, nil)
+		for index, input := range model.Input {
+			du.SetVec(index, input.U(t+model.Ts)-u.AtVec(index))
+		}
+		tmp.MulVec(model.B1, du)
+		res.AddVec(&res, &tmp)
+	}
+	return &res
+}
+
+// StateSpaceOrder returns the state space order
+func (model DiscreteLinearStateSpaceModel) StateSpaceOrder() int {
+	m, _ := model.Ad.Dims()
+	return m
+}
@@ -0,0 +1,40 @@
+package ssm
+
+import "errors"
+
+// ErrDimensionMismatch is matched by all dimension errors, i.e.,
+// errors.Is(err, ErrDimensionMismatch) is true for any *DimensionError.
+var ErrDimensionMismatch = errors.New("Dimension mismatch")
+
+// ErrSingular is returned when a system of equations is singular, e.g., by
+// Resolvent at a pole.
+var ErrSingular = errors.New("Singular system")
+
+// DimensionError reports matrices, vectors or inputs with dimensions that
+// don't agree. It's used throughout the library to validate models and
+// topologies without panicking.
+type DimensionError struct {
+	// Description of what doesn't match
+	What string
+}
+
+func (e *DimensionError) Error() string {
+	if e.What == "" {
+		return ErrDimensionMismatch.Error()
+	}
+	return e.What
+}
+
+// Is makes all dimension errors match ErrDimensionMismatch
+func (e *DimensionError) Is(target error) bool {
+	return target == ErrDimensionMismatch
+}
+
+// IsDimensionMismatch reports whether err is a dimension error
+func IsDimensionMismatch(err error) bool {
+	if err == ErrDimensionMismatch {
+		return true
+	}
+	_, ok := err.(*DimensionError)
+	return ok
+}
@@ -2,6 +2,9 @@ package ssm
 
 import (
 	"errors"
+	"math"
+	"math/cmplx"
+	"runtime"
 	"sync"
 
 	"github.com/hammal/adc/signal"
@@ -47,23 +50,38 @@ func NewIntegratorChain(N int, stageGain float64, input []signal.VectorFunction)
 	return NewLinearStateSpaceModel(A, C, input)
 }
 
-// NewLinearStateSpaceModel creates a new Linear state space model
+// NewLinearStateSpaceModel creates a new Linear state space model. Panics if
+// the dimensions don't match, see NewLinearStateSpaceModelChecked.
 func NewLinearStateSpaceModel(A, C mat.Matrix, input []signal.VectorFunction) *LinearStateSpaceModel {
+	sys, err := NewLinearStateSpaceModelChecked(A, C, input)
+	if err != nil {
+		panic(err)
+	}
+	return sys
+}
+
+// NewLinearStateSpaceModelChecked creates a new Linear state space model and
+// returns a *DimensionError if the system parameters don't match.
+func NewLinearStateSpaceModelChecked(A, C mat.Matrix, input []signal.VectorFunction) (*LinearStateSpaceModel, error) {
 	// Check that system parameters match
 	m, n := A.Dims()
 	_, nC := C.Dims()
-	mB, _ := input[0].B.Dims()
-	if m != n || nC != m || mB != m {
-		panic(errors.New("System Parameters don't match"))
+	if m != n || nC != m {
+		return nil, &DimensionError{What: "System Parameters don't match"}
 	}
 	// Check that input dimensions match
+	for _, inp := range input {
+		if inp.B == nil || inp.B.Len() != m {
+			return nil, &DimensionError{What: "Input vector doesn't match the state space order"}
+		}
+	}
 
 	sys := LinearStateSpaceModel{
 		A:     A,
 		C:     C,
 		Input: input,
 	}
-	return &sys
+	return &sys, nil
 }
 
 func (model LinearStateSpaceModel) Order() int {
@@ -116,54 +134,123 @@ func (model LinearStateSpaceModel) Observation(t float64, state mat.Vector) mat.
 	return res
 }
 
-// computeStateTransistion computes the e^(At) where A is a square matrix and
-// t is a scalar.
-func computeStateTransistion(t float64, m *mat.Dense) {
-	m.Scale(t, m)
-	m.Exp(m)
+// ImpulseResponse computes the impulse responses C e^(A t) b of the inputs and
+// returns them in an array [numberOfObservations][numberOfInputs][tap at time t]float64
+func (model LinearStateSpaceModel) ImpulseResponse(t []float64) [][][]float64 {
+	return model.response(t, false)
 }
 
-// ImpulseResponse computes the impulse response of the system and returns it in
-// an array [numberOfObservations][numberOfInputs][tap at time t]float64
-func (model LinearStateSpaceModel) ImpulseResponse(t []float64) [][][]float64 {
-	var wg sync.WaitGroup
-	numberOfTaps := len(t)
+// StepResponse computes the step responses C int_0^t e^(A s) ds b of the inputs
+// and returns them in an array [numberOfObservations][numberOfInputs][tap at time t]float64
+func (model LinearStateSpaceModel) StepResponse(t []float64) [][][]float64 {
+	return model.response(t, true)
+}
+
+// response computes the impulse or step responses. For a uniform grid,
+// t[k] = t[0] + k dt, the responses are propagated by repeated multiplication
+// with e^(A dt). Otherwise the taps are computed by at most runtime.NumCPU()
+// workers.
+func (model LinearStateSpaceModel) response(t []float64, step bool) [][][]float64 {
+	order := model.StateSpaceOrder()
 	numberOfObservations, _ := model.C.Dims()
-	numberOfInputs := 1
+	numberOfInputs := len(model.Input)
 
 	// Initalise an 3D array --> (numberOfObservations X numberOfInputs X numberOfTaps)
 	res := make([][][]float64, numberOfObservations)
 	for obs := range res {
 		res[obs] = make([][]float64, numberOfInputs)
 		for inp := range res[obs] {
-			res[obs][inp] = make([]float64, numberOfTaps)
+			res[obs][inp] = make([]float64, len(t))
 		}
 	}
+	if numberOfInputs == 0 || len(t) == 0 {
+		return res
+	}
 
-	wg.Add(numberOfTaps)
-	for index, time := range t {
-		// Compute the different impulses as a go routine
-		go func(i int, t float64) {
-			defer wg.Done()
-			var tmp, tmp2 mat.Dense
-			tmp.Clone(model.A)
-			// tmp = e^(tmp * t)
-			computeStateTransistion(t, &tmp)
-			// C e^(tmp * t)
-			tmp2.Mul(model.C, &tmp)
-			var tempInput mat.VecDense
-			for _, input := range model.Input {
-				tempInput.MulVec(&tmp2, input.B)
+	B := mat.NewDense(order, numberOfInputs, nil)
+	for column, input := range model.Input {
+		B.SetCol(column, mat.Col(nil, 0, input.B))
+	}
+	// evaluate returns e^(A t) B or int_0^t e^(A s) ds B
+	evaluate := func(t float64) *mat.Dense {
+		if step {
+			_, Bd := ZeroOrderHoldDiscretization(model.A, B, t)
+			return Bd
+		}
+		var transition, X mat.Dense
+		transition.Scale(t, model.A)
+		transition.Exp(&transition)
+		X.Mul(&transition, B)
+		return &X
+	}
+	store := func(index int, X mat.Matrix) {
+		var Y mat.Dense
+		Y.Mul(model.C, X)
+		for obs := range res {
+			for inp := range res[obs] {
+				res[obs][inp][index] = Y.At(obs, inp)
+			}
+		}
+	}
+
+	if uniformGrid(t) {
+		// e^(A (t + dt)) B = e^(A dt) e^(A t) B and
+		// int_0^(t + dt) e^(A s) ds B = e^(A dt) int_0^t e^(A s) ds B + int_0^dt e^(A s) ds B
+		Ad, Bd := ZeroOrderHoldDiscretization(model.A, B, t[1]-t[0])
+		X := evaluate(t[0])
+		store(0, X)
+		for index := 1; index < len(t); index++ {
+			var next mat.Dense
+			next.Mul(Ad, X)
+			if step {
+				next.Add(&next, Bd)
 			}
-			for obs := range res {
-				res[obs][0][i] = tempInput.AtVec(obs)
+			X = &next
+			store(index, X)
+		}
+		return res
+	}
+
+	var wg sync.WaitGroup
+	workers := runtime.NumCPU()
+	if workers > len(t) {
+		workers = len(t)
+	}
+	indices := make(chan int)
+	wg.Add(workers)
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				store(index, evaluate(t[index]))
 			}
-		}(index, time)
+		}()
+	}
+	for index := range t {
+		indices <- index
 	}
+	close(indices)
 	wg.Wait()
 	return res
 }
 
+// uniformGrid returns true if t[k] = t[0] + k dt for some dt != 0.
+func uniformGrid(t []float64) bool {
+	if len(t) < 2 {
+		return false
+	}
+	dt := t[1] - t[0]
+	if dt == 0 {
+		return false
+	}
+	for index := 2; index < len(t); index++ {
+		if math.Abs(t[index]-t[index-1]-dt) > 1e-9*math.Abs(dt) {
+			return false
+		}
+	}
+	return true
+}
+
 func (ssm LinearStateSpaceModel) StateSpaceOrder() int {
 	m, _ := ssm.A.Dims()
 	return m
@@ -178,43 +265,111 @@ func (ssm LinearStateSpaceModel) InputSpaceOrder() int {
 	return len(ssm.Input)
 }
 
-// func (model LinearStateSpaceModel) FrequencyResponse(f []float64) [][][]complex128 {
-// 	numberOfTaps := len(f)
-// 	numberOfObservations := model.Order
-// 	numberOfInputs := len(model.Input)
+// FrequencyResponse computes the frequency response
 //
-// 	// Initalise an 3D array --> (numberOfObservations X numberOfInputs X numberOfTaps)
-// 	res := make([][][]complex128, numberOfObservations)
-// 	for obs := range res {
-// 		res[obs] = make([][]complex128, numberOfInputs)
-// 		for inp := range res[obs] {
-// 			res[obs][inp] = make([]complex128, numberOfTaps)
-// 		}
-// 	}
-
-// Have not implemented this since I don't fully understand how to work out
-// complex matrices
-// panic(errors.New("Not yet implemented"))
-
-// for index, frequency := range f {
-// 	// Compute the different frequency response as a go routine
-// 	go func(i int, f float64) {
-// 		tmp := mat.NewDense(model.order, model.order, nil)
-// 		tmp.Clone(model.A)
-// 		// tmp = e^(tmp * t)
-// 		computeStateTransistion(t, tmp)
-// 		// C e^(tmp * t)
-// 		tmp.Mul(model.C, tmp)
-// 		for inp, inputObject := range model.Input {
-// 			tempInput := mat.NewVecDense(model.order, nil)
-// 			tempInput.MulVec(tmp, inputObject.B)
-// 			tempInput.MulVec(model.C, tempInput)
-// 			for obs := range res {
-// 				res[obs][inp][index] = tempInput.AtVec(obs)
-// 			}
-// 		}
-// 	}(index, frequency)
-// }
-// return res
-
-// }
+//	H(f) = C (j 2 pi f I - A)^(-1) B
+//
+// of the system and returns it in an array
+// [numberOfObservations][numberOfInputs][frequency]complex128. The frequencies
+// are evaluated concurrently by one worker per CPU and frequencies at poles of
+// the system result in cmplx.Inf().
+func (model LinearStateSpaceModel) FrequencyResponse(f []float64) [][][]complex128 {
+	var wg sync.WaitGroup
+	numberOfObservations, _ := model.C.Dims()
+	numberOfInputs := len(model.Input)
+
+	// Initalise an 3D array --> (numberOfObservations X numberOfInputs X numberOfFrequencies)
+	res := make([][][]complex128, numberOfObservations)
+	for obs := range res {
+		res[obs] = make([][]complex128, numberOfInputs)
+		for inp := range res[obs] {
+			res[obs][inp] = make([]complex128, len(f))
+		}
+	}
+	if numberOfInputs == 0 {
+		return res
+	}
+
+	B := mat.NewDense(model.StateSpaceOrder(), numberOfInputs, nil)
+	for column, input := range model.Input {
+		B.SetCol(column, mat.Col(nil, 0, input.B))
+	}
+
+	workers := runtime.NumCPU()
+	if workers > len(f) {
+		workers = len(f)
+	}
+	indices := make(chan int)
+	wg.Add(workers)
+	for worker := 0; worker < workers; worker++ {
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				re, im, err := Resolvent(model.A, B, 2*math.Pi*f[index])
+				ok := err == nil
+				var cRe, cIm mat.Dense
+				if ok {
+					cRe.Mul(model.C, re)
+					cIm.Mul(model.C, im)
+				}
+				for obs := range res {
+					for inp := range res[obs] {
+						if ok {
+							res[obs][inp][index] = complex(cRe.At(obs, inp), cIm.At(obs, inp))
+						} else {
+							res[obs][inp][index] = cmplx.Inf()
+						}
+					}
+				}
+			}
+		}()
+	}
+	for index := range f {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+	return res
+}
+
+// Resolvent returns the real and imaginary parts of (j omega I - A)^(-1) R
+// which, since the complex system can't be solved directly, are solved as the
+// real system
+//
+//	[-A, -omega I; omega I, -A] [Xr; Xi] = [R; 0]
+//
+// An ill-conditioned system is still solved, see mat.Condition, whereas
+// ErrSingular is returned if j omega is an eigenvalue of A.
+func Resolvent(A, R mat.Matrix, omega float64) (re, im *mat.Dense, err error) {
+	order, _ := A.Dims()
+	rows, columns := R.Dims()
+	if rows != order {
+		return nil, nil, &DimensionError{What: "Right hand side doesn't match the state transition matrix"}
+	}
+	system := mat.NewDense(2*order, 2*order, nil)
+	right := mat.NewDense(2*order, columns, nil)
+	for row := 0; row < order; row++ {
+		for column := 0; column < order; column++ {
+			system.Set(row, column, -A.At(row, column))
+			system.Set(row+order, column+order, -A.At(row, column))
+		}
+		system.Set(row, row+order, -omega)
+		system.Set(row+order, row, omega)
+		for column := 0; column < columns; column++ {
+			right.Set(row, column, R.At(row, column))
+		}
+	}
+	var X mat.Dense
+	if err := X.Solve(system, right); err != nil {
+		condition, isCondition := err.(mat.Condition)
+		if !isCondition {
+			return nil, nil, err
+		}
+		if math.IsInf(float64(condition), 1) {
+			return nil, nil, ErrSingular
+		}
+	}
+	re = mat.DenseCopyOf(X.Slice(0, order, 0, columns))
+	im = mat.DenseCopyOf(X.Slice(order, 2*order, 0, columns))
+	return re, im, nil
+}
@@ -0,0 +1,168 @@
+package ssm
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// saturationBand is the fraction of the saturation below which a saturating
+// integrator starts to stop.
+const saturationBand = 1e-2
+
+// Integrator holds the non-idealities of the integrator realizing a state.
+// Zero values are ideal.
+type Integrator struct {
+	// Output saturation, the state is clipped to [-Saturation, Saturation]
+	Saturation float64
+	// Finite DC gain which makes the integrator leaky with the leak rate
+	// g / DCGain where g is the stage gain, i.e., the largest absolute
+	// coefficient of the state derivative in A and the input vectors.
+	DCGain float64
+	// Slew rate, the state derivative is limited to [-SlewRate, SlewRate]
+	SlewRate float64
+	// Polynomial distortion coefficients [a_2, a_3, ...] of the state seen by
+	// the system x + a_2 x^2 + a_3 x^3 + ...
+	Distortion []float64
+}
+
+// NonLinearStateSpaceModel is a linear state space model where the integrators
+// of the states have non-idealities. The system is
+//
+//	x'(t) = limit(A f(x(t)) + B u(t) - L x(t))
+//	y(t) = C f(x(t))
+//
+// where f clips and distorts each state, L is the diagonal of leak rates and
+// limit applies the slew rates and stops the integration at saturation. The
+// embedded linear state space model is the nominal, ideal, model.
+type NonLinearStateSpaceModel struct {
+	LinearStateSpaceModel
+	// Non-idealities, one per state
+	Integrators []Integrator
+	// leak rates of the integrators
+	leak []float64
+}
+
+// NewNonLinearStateSpaceModel creates a new nonlinear state space model. Panics
+// if the integrators don't match the linear state space model, see
+// NewNonLinearStateSpaceModelChecked.
+func NewNonLinearStateSpaceModel(model *LinearStateSpaceModel, integrators []Integrator) *NonLinearStateSpaceModel {
+	sys, err := NewNonLinearStateSpaceModelChecked(model, integrators)
+	if err != nil {
+		panic(err)
+	}
+	return sys
+}
+
+// NewNonLinearStateSpaceModelChecked creates a new nonlinear state space model
+// and returns a *DimensionError if there isn't one integrator per state or an
+// error if the non-idealities are negative.
+func NewNonLinearStateSpaceModelChecked(model *LinearStateSpaceModel, integrators []Integrator) (*NonLinearStateSpaceModel, error) {
+	if model == nil {
+		return nil, errors.New("A linear state space model is required")
+	}
+	if _, err := NewLinearStateSpaceModelChecked(model.A, model.C, model.Input); err != nil {
+		return nil, err
+	}
+	order := model.StateSpaceOrder()
+	if len(integrators) != order {
+		return nil, &DimensionError{What: "Number of integrators doesn't match the state space order"}
+	}
+	leak := make([]float64, order)
+	for index, integrator := range integrators {
+		if integrator.Saturation < 0 || integrator.DCGain < 0 || integrator.SlewRate < 0 {
+			return nil, errors.New("Integrator non-idealities must be non-negative")
+		}
+		if integrator.DCGain == 0 {
+			continue
+		}
+		// The stage gain
+		gain := 0.
+		for column := 0; column < order; column++ {
+			gain = math.Max(gain, math.Abs(model.A.At(index, column)))
+		}
+		for _, input := range model.Input {
+			gain = math.Max(gain, math.Abs(input.B.AtVec(index)))
+		}
+		leak[index] = gain / integrator.DCGain
+	}
+	return &NonLinearStateSpaceModel{
+		LinearStateSpaceModel: *model,
+		Integrators:           integrators,
+		leak:                  leak,
+	}, nil
+}
+
+// Derivative returns the state derivative
+// x'(t) = limit(A f(x(t)) + B u(t) - L x(t)).
+func (model NonLinearStateSpaceModel) Derivative(t float64, state mat.Vector) mat.Vector {
+	return model.ControlledDerivative(t, state, nil)
+}
+
+// ControlledDerivative returns the state derivative where the control
+// contribution, which may be nil, is added before the slew rate and saturation
+// limits.
+func (model NonLinearStateSpaceModel) ControlledDerivative(t float64, state, control mat.Vector) mat.Vector {
+	order := model.StateSpaceOrder()
+	if state.Len() != order {
+		panic(errors.New("State vector doesn't match state transition matrix"))
+	}
+
+	res := mat.NewVecDense(order, nil)
+	res.MulVec(model.A, model.observed(state))
+	for _, input := range model.Input {
+		res.AddVec(res, input.Bu(t))
+	}
+	if control != nil {
+		res.AddVec(res, control)
+	}
+	for index, integrator := range model.Integrators {
+		derivative := res.AtVec(index) - model.leak[index]*state.AtVec(index)
+		if integrator.SlewRate > 0 {
+			derivative = math.Max(-integrator.SlewRate, math.Min(integrator.SlewRate, derivative))
+		}
+		// A saturated integrator can only move back towards zero. The
+		// integration stops linearly within the saturationBand below the
+		// saturation such that the derivative remains continuous.
+		if integrator.Saturation > 0 {
+			x := state.AtVec(index)
+			if derivative < 0 {
+				x = -x
+			}
+			band := saturationBand * integrator.Saturation
+			derivative *= math.Max(0, math.Min(1, (integrator.Saturation-x)/band))
+		}
+		res.SetVec(index, derivative)
+	}
+	return res
+}
+
+// Observation returns the observation y(t) = C f(x(t)).
+func (model NonLinearStateSpaceModel) Observation(t float64, state mat.Vector) mat.Vector {
+	if state.Len() != model.StateSpaceOrder() {
+		panic(errors.New("State vector doesn't match state transition matrix"))
+	}
+	mC, _ := model.C.Dims()
+	res := mat.NewVecDense(mC, nil)
+	res.MulVec(model.C, model.observed(state))
+	return res
+}
+
+// observed returns f(x), the clipped and distorted state seen by the system.
+func (model NonLinearStateSpaceModel) observed(state mat.Vector) *mat.VecDense {
+	res := mat.NewVecDense(state.Len(), nil)
+	for index, integrator := range model.Integrators {
+		x := state.AtVec(index)
+		if integrator.Saturation > 0 {
+			x = math.Max(-integrator.Saturation, math.Min(integrator.Saturation, x))
+		}
+		value, power := x, x
+		for _, coefficient := range integrator.Distortion {
+			power *= x
+			value += coefficient * power
+		}
+		res.SetVec(index, value)
+	}
+	return res
+}
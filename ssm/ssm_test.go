@@ -3,6 +3,8 @@ package ssm
 import (
 	"errors"
 	"fmt"
+	"math"
+	"math/cmplx"
 	"testing"
 
 	"github.com/hammal/adc/signal"
@@ -74,6 +76,87 @@ func TestImpulseResponse(t *testing.T) {
 	fmt.Print(impulseResponse)
 }
 
+func TestFrequencyResponse(t *testing.T) {
+	N := 4
+	beta := 10.
+	inputs := make([]signal.VectorFunction, 2)
+	for index := range inputs {
+		b := mat.NewVecDense(N, nil)
+		b.SetVec(index, beta)
+		inputs[index] = signal.NewInput(func(t float64) float64 { return 0 }, b)
+	}
+	stateSpaceModel := NewIntegratorChain(N, beta, inputs)
+	frequency := []float64{0.1, 1., 10., 100.}
+	response := stateSpaceModel.FrequencyResponse(frequency)
+
+	// H_(obs,inp)(f) = (beta / (j 2 pi f))^(obs - inp + 1) for obs >= inp
+	for obs := range response {
+		for inp := range response[obs] {
+			for index, f := range frequency {
+				var expected complex128
+				if obs >= inp {
+					expected = cmplx.Pow(complex(0, -beta/(2*math.Pi*f)), complex(float64(obs-inp+1), 0))
+				}
+				if cmplx.Abs(response[obs][inp][index]-expected) > 1e-9*(1+cmplx.Abs(expected)) {
+					t.Errorf("H_%v%v(%v) = %v instead of %v", obs, inp, f, response[obs][inp][index], expected)
+				}
+			}
+		}
+	}
+
+	// The integrators have a pole at f = 0
+	if value := stateSpaceModel.FrequencyResponse([]float64{0})[0][0][0]; !cmplx.IsInf(value) {
+		t.Errorf("Expected an infinite response at the pole but got %v", value)
+	}
+	if _, _, err := Resolvent(stateSpaceModel.A, stateSpaceModel.C, 0); err != ErrSingular {
+		t.Errorf("Expected ErrSingular but got %v", err)
+	}
+	if _, _, err := Resolvent(stateSpaceModel.A, mat.NewDense(N+1, 1, nil), 1); !IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch but got %v", err)
+	}
+}
+
+func TestMultiInputResponses(t *testing.T) {
+	N := 3
+	beta := 2.
+	inputs := make([]signal.VectorFunction, 2)
+	for index := range inputs {
+		b := mat.NewVecDense(N, nil)
+		b.SetVec(index, beta)
+		inputs[index] = signal.NewInput(func(t float64) float64 { return 0 }, b)
+	}
+	stateSpaceModel := NewIntegratorChain(N, beta, inputs)
+
+	uniform := make([]float64, 200)
+	for index := range uniform {
+		uniform[index] = 0.1 + float64(index)*0.01
+	}
+	nonUniform := []float64{0, 0.3, 0.35, 1.2, 2.}
+	for _, time := range [][]float64{uniform, nonUniform} {
+		impulse := stateSpaceModel.ImpulseResponse(time)
+		step := stateSpaceModel.StepResponse(time)
+		// For an integrator chain h(t) = beta^(d+1) t^d / d! and
+		// s(t) = beta^(d+1) t^(d+1) / (d+1)! where d = obs - inp >= 0
+		for obs := 0; obs < N; obs++ {
+			for inp := range inputs {
+				for index, t0 := range time {
+					var expectedImpulse, expectedStep float64
+					if d := obs - inp; d >= 0 {
+						expectedImpulse = math.Pow(beta, float64(d+1)) * math.Pow(t0, float64(d)) / math.Gamma(float64(d+1))
+						expectedStep = math.Pow(beta, float64(d+1)) * math.Pow(t0, float64(d+1)) / math.Gamma(float64(d+2))
+					}
+					if math.Abs(impulse[obs][inp][index]-expectedImpulse) > 1e-9*(1+math.Abs(expectedImpulse)) {
+						t.Errorf("h_%v%v(%v) = %v instead of %v", obs, inp, t0, impulse[obs][inp][index], expectedImpulse)
+					}
+					if math.Abs(step[obs][inp][index]-expectedStep) > 1e-9*(1+math.Abs(expectedStep)) {
+						t.Errorf("s_%v%v(%v) = %v instead of %v", obs, inp, t0, step[obs][inp][index], expectedStep)
+					}
+				}
+			}
+		}
+	}
+}
+
 func BenchmarkImpulseResponse(b *testing.B) {
 	A := mat.NewDense(3, 3, []float64{1, 0, 0, 1, 0, 0, 0, 1, 0})
 	B := mat.NewVecDense(3, []float64{1, 0, 0})
@@ -94,3 +177,164 @@ func BenchmarkImpulseResponse(b *testing.B) {
 		stateSpaceModel.ImpulseResponse(time)
 	}
 }
+
+func TestDiscretize(t *testing.T) {
+	gain := 10.
+	ts := 1e-2
+	N := 2
+	B := mat.NewVecDense(N, []float64{gain, 0})
+
+	// Constant input is exact for zero-order hold
+	// x_0(t) = g t, x_1(t) = g^2 t^2 / 2
+	inputs := []signal.VectorFunction{signal.NewInput(func(t float64) float64 { return 1. }, B)}
+	discreteModel := NewIntegratorChain(N, gain, inputs).Discretize(ts, ZeroOrderHold)
+	state := mat.NewVecDense(N, nil)
+	for index := 0; index < 100; index++ {
+		state = discreteModel.Step(float64(index)*ts, state)
+	}
+	time := 100 * ts
+	if math.Abs(state.AtVec(0)-gain*time) > 1e-9 || math.Abs(state.AtVec(1)-gain*gain*time*time/2.) > 1e-9 {
+		t.Errorf("Zero-order hold discretization is not exact, got \n%v\n", mat.Formatted(state))
+	}
+
+	// Ramp input is exact for first-order hold
+	// x_0(t) = g t^2 / 2, x_1(t) = g^2 t^3 / 6
+	inputs = []signal.VectorFunction{signal.NewInput(func(t float64) float64 { return t }, B)}
+	discreteModel = NewIntegratorChain(N, gain, inputs).Discretize(ts, FirstOrderHold)
+	state = mat.NewVecDense(N, nil)
+	for index := 0; index < 100; index++ {
+		state = discreteModel.Step(float64(index)*ts, state)
+	}
+	if math.Abs(state.AtVec(0)-gain*time*time/2.) > 1e-9 || math.Abs(state.AtVec(1)-gain*gain*time*time*time/6.) > 1e-9 {
+		t.Errorf("First-order hold discretization is not exact, got \n%v\n", mat.Formatted(state))
+	}
+}
+
+func TestNewLinearStateSpaceModelChecked(t *testing.T) {
+	input := []signal.VectorFunction{signal.NewInput(math.Sin, mat.NewVecDense(3, nil))}
+	if _, err := NewLinearStateSpaceModelChecked(mat.NewDense(2, 2, nil), mat.NewDense(2, 2, nil), input); !IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch but got %v", err)
+	}
+	if _, err := NewLinearStateSpaceModelChecked(mat.NewDense(3, 2, nil), mat.NewDense(3, 2, nil), nil); !IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch for a non-square A but got %v", err)
+	}
+	if _, err := NewLinearStateSpaceModelChecked(mat.NewDense(3, 3, nil), mat.NewDense(1, 3, nil), input); err != nil {
+		t.Error(err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewLinearStateSpaceModel did not panic")
+		}
+	}()
+	NewLinearStateSpaceModel(mat.NewDense(2, 2, nil), mat.NewDense(2, 2, nil), input)
+}
+
+func TestBiLinearStateSpaceModel(t *testing.T) {
+	input := []signal.VectorFunction{
+		signal.NewInput(math.Cos, mat.NewVecDense(2, []float64{1, 0})),
+		signal.NewInput(func(float64) float64 { return 2 }, mat.NewVecDense(2, nil)),
+	}
+	AL := mat.NewDense(2, 2, []float64{0, 0, 1, 0})
+	if _, err := NewBiLinearStateSpaceModelChecked(AL, mat.NewDense(2, 2, nil), mat.NewDense(2, 2, nil), input); !IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch but got %v", err)
+	}
+	if _, err := NewBiLinearStateSpaceModelChecked(AL, mat.NewDense(2, 4, nil), mat.NewDense(2, 3, nil), input); !IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch but got %v", err)
+	}
+	// AB_0 couples the first state to itself and AB_1 to the second state
+	AB := mat.NewDense(2, 4, []float64{-1, 0, 0, 0, 0, 0, 3, 0})
+	model := NewBiLinearStateSpaceModel(AL, AB, mat.NewDense(1, 2, []float64{0, 1}), input)
+
+	// The state dynamics match the derivative
+	state := mat.NewVecDense(2, []float64{0.3, -0.2})
+	for _, time := range []float64{0, 0.5, 2} {
+		var expected mat.VecDense
+		expected.MulVec(model.StateDynamics(time), state)
+		expected.AddVec(&expected, input[0].Bu(time))
+		if !mat.EqualApprox(&expected, model.Derivative(time, state), 1e-12) {
+			t.Errorf("State dynamics don't match the derivative at time %v", time)
+		}
+	}
+	linear, err := model.Linearize([]float64{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mat.EqualApprox(linear.A, mat.NewDense(2, 2, []float64{-1, 0, 7, 0}), 1e-12) {
+		t.Errorf("Wrong linearization \n%v", mat.Formatted(linear.A))
+	}
+	if _, err := model.Linearize([]float64{1}); !IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch but got %v", err)
+	}
+}
+
+func TestNonLinearStateSpaceModel(t *testing.T) {
+	beta := 10.
+	b := mat.NewVecDense(2, []float64{beta, 0})
+	linear := NewIntegratorChain(2, beta, []signal.VectorFunction{signal.NewInput(func(float64) float64 { return 1 }, b)})
+	if _, err := NewNonLinearStateSpaceModelChecked(linear, make([]Integrator, 1)); !IsDimensionMismatch(err) {
+		t.Errorf("Expected a dimension mismatch but got %v", err)
+	}
+	if _, err := NewNonLinearStateSpaceModelChecked(linear, []Integrator{{DCGain: -1}, {}}); err == nil {
+		t.Error("Expected an error for a negative DC gain")
+	}
+
+	// Ideal integrators are linear
+	state := mat.NewVecDense(2, []float64{0.5, -0.25})
+	ideal := NewNonLinearStateSpaceModel(linear, make([]Integrator, 2))
+	if !mat.EqualApprox(ideal.Derivative(0, state), linear.Derivative(0, state), 1e-12) {
+		t.Error("Ideal integrators don't match the linear model")
+	}
+
+	// x0' = beta u - beta / 100 x0 and x1' = beta f(x0) limited to 2
+	model := NewNonLinearStateSpaceModel(linear, []Integrator{
+		{DCGain: 100, Distortion: []float64{0, 0.1}},
+		{SlewRate: 2},
+	})
+	derivative := model.Derivative(0, state)
+	if expected := beta - beta/100*0.5; math.Abs(derivative.AtVec(0)-expected) > 1e-12 {
+		t.Errorf("Leaky derivative %v instead of %v", derivative.AtVec(0), expected)
+	}
+	if derivative.AtVec(1) != 2 {
+		t.Errorf("Slew rate limited derivative %v instead of 2", derivative.AtVec(1))
+	}
+	if observed, expected := model.Observation(0, state).AtVec(0), 0.5+0.1*0.125; math.Abs(observed-expected) > 1e-12 {
+		t.Errorf("Distorted observation %v instead of %v", observed, expected)
+	}
+
+	// A saturated integrator only moves back towards zero
+	saturated := NewNonLinearStateSpaceModel(linear, []Integrator{{Saturation: 0.5}, {Saturation: 1}})
+	derivative = saturated.Derivative(0, mat.NewVecDense(2, []float64{0.6, 0}))
+	if derivative.AtVec(0) != 0 || derivative.AtVec(1) != beta*0.5 {
+		t.Errorf("Saturated derivative %v", mat.Formatted(derivative.T()))
+	}
+	control := mat.NewVecDense(2, []float64{-2 * beta, 0})
+	if derivative = saturated.ControlledDerivative(0, mat.NewVecDense(2, []float64{0.6, 0}), control); derivative.AtVec(0) != -beta {
+		t.Errorf("Controlled derivative %v instead of %v", derivative.AtVec(0), -beta)
+	}
+}
+
+func TestNoiseCovarianceDiscretization(t *testing.T) {
+	gain := 10.
+	ts := 1e-2
+	q := 3.
+	A := NewIntegratorChain(2, gain, nil).A
+	Q := mat.NewDense(2, 2, []float64{q, 0, 0, 0})
+
+	// Brownian motion x_0 integrated by x_1
+	// var(x_0) = q t, cov(x_0, x_1) = q g t^2 / 2, var(x_1) = q g^2 t^3 / 3
+	Ad, Qd := NoiseCovarianceDiscretization(A, Q, ts)
+	expected := mat.NewDense(2, 2, []float64{
+		q * ts, q * gain * ts * ts / 2,
+		q * gain * ts * ts / 2, q * gain * gain * ts * ts * ts / 3,
+	})
+	if !mat.EqualApprox(Qd, expected, 1e-12) {
+		t.Errorf("Qd = \n%v\ninstead of\n%v", mat.Formatted(Qd), mat.Formatted(expected))
+	}
+	var expectedAd mat.Dense
+	expectedAd.Scale(ts, A)
+	expectedAd.Exp(&expectedAd)
+	if !mat.EqualApprox(Ad, &expectedAd, 1e-12) {
+		t.Errorf("Ad = \n%v\ninstead of\n%v", mat.Formatted(Ad), mat.Formatted(&expectedAd))
+	}
+}